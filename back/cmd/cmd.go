@@ -1,39 +1,94 @@
 package cmd
 
 import (
+	"backend/internal/config"
 	"backend/internal/gateway"
 	"backend/internal/model"
 	"backend/internal/service"
 	"backend/utils"
+	"context"
+	"crypto/rand"
+	"database/sql"
 	"log"
-	"time"
+	"net/http"
+	"os/signal"
+	"sync"
+	"syscall"
 
 	_ "github.com/lib/pq"
 	"gorm.io/gorm"
 )
 
 type App struct {
-	db model.MailDB
+	db   model.MailDB
+	pool *sql.DB
+	conf *config.Config
 }
 
-func NewApp(db *gorm.DB) *App {
+func NewApp(db *gorm.DB, conf *config.Config) *App {
+	pool, err := db.DB()
+	if err != nil {
+		log.Fatal("Failed to get database pool:", err)
+	}
+
 	return &App{
-		db: model.NewMailDB(db),
+		db:   model.NewMailDB(db),
+		pool: pool,
+		conf: conf,
 	}
 }
 
+// Run serves the API and the background workers until SIGINT or SIGTERM.
+// On a signal it stops accepting connections, lets in-flight requests finish
+// within HTTP_SHUTDOWN_TIMEOUT, stops the workers and closes the database
+// pool.
 func (a *App) Run() {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	store, err := utils.NewAttachmentStore(a.conf.AttachmentsDir)
+	if err != nil {
+		log.Fatal("Failed to open attachment store:", err)
+	}
+
+	transport, err := utils.NewTransport(a.conf.Transport, store)
+	if err != nil {
+		log.Fatal("Failed to configure mail transport:", err)
+	}
+
+	var workers sync.WaitGroup
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		utils.NewOutboxWorker(a.db, transport).Run(ctx)
+	}()
+
+	workers.Add(1)
 	go func() {
-		for {
-			if err := utils.ReadMailIMAP(a.db); err != nil {
-				log.Println("Error reading emails:", err)
-			}
-			time.Sleep(10 * time.Second)
-		}
+		defer workers.Done()
+		utils.NewPurgeWorker(a.db, store, a.conf.Purge).Run(ctx)
 	}()
 
-	mailServ := service.NewMailService(a.db)
-	authServ := service.NewAuthService(a.db)
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		utils.NewScheduleWorker(a.db, a.conf.Schedule).Run(ctx)
+	}()
+
+	if a.conf.IMAP.Host != "" {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			utils.NewImapWorker(a.db, store, a.conf.IMAP).Run(ctx)
+		}()
+	} else {
+		log.Println("IMAP_HOST is not set, external mail is not imported")
+	}
+
+	tokens := utils.NewTokenIssuer(tokenSecret(a.conf.TokenSecret), utils.AccessTokenTTL)
+
+	mailServ := service.NewMailService(a.db, store)
+	authServ := service.NewAuthService(a.db, tokens)
 	adminServ := service.NewAdminService(a.db)
 
 	services := service.Service{
@@ -42,9 +97,69 @@ func (a *App) Run() {
 		AdminService: adminServ,
 	}
 
-	basicAuthMw := utils.NewBasicAuthMiddleware(a.db)
+	tokenAuthMw := utils.NewTokenAuthMiddleware(tokens)
 	roleMw := utils.NewRoleMiddleware(a.db)
 
 	log.Println("Initialize router")
-	gateway.InitRouter(services, basicAuthMw, roleMw)
+	httpConf := a.conf.HTTP
+	srv := &http.Server{
+		Addr:              httpConf.Addr,
+		Handler:           gateway.InitRouter(services, tokenAuthMw, roleMw, httpConf.MaxBodyBytes),
+		ReadHeaderTimeout: httpConf.ReadHeaderTimeout,
+		ReadTimeout:       httpConf.ReadTimeout,
+		WriteTimeout:      httpConf.WriteTimeout,
+		IdleTimeout:       httpConf.IdleTimeout,
+		MaxHeaderBytes:    httpConf.MaxHeaderBytes,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("Run server on %s", httpConf.Addr)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case <-ctx.Done():
+		log.Println("Shutting down")
+	case err := <-serveErr:
+		log.Println("Server stopped:", err)
+	}
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), httpConf.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Println("Failed to drain requests:", err)
+	}
+
+	workersDone := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(workersDone)
+	}()
+	select {
+	case <-workersDone:
+	case <-shutdownCtx.Done():
+		log.Println("Workers did not stop in time")
+	}
+
+	if err := a.pool.Close(); err != nil {
+		log.Println("Failed to close database pool:", err)
+	}
+	log.Println("Server stopped")
+}
+
+// tokenSecret returns the key access tokens are signed with. Without
+// TOKEN_SECRET a random key is used, which logs everybody out on restart.
+func tokenSecret(secret string) []byte {
+	if secret != "" {
+		return []byte(secret)
+	}
+
+	log.Println("TOKEN_SECRET is not set, using a random signing key")
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Fatal("Failed to generate token secret:", err)
+	}
+	return key
 }
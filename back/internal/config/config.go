@@ -0,0 +1,141 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// TransportSMTPS connects over TLS from the first byte, usually on 465.
+	TransportSMTPS = "smtps"
+	// TransportStartTLS connects in plain text and upgrades with STARTTLS, usually on 587.
+	TransportStartTLS = "starttls"
+	// TransportSMTP speaks unencrypted SMTP, meant for relays on a trusted network.
+	TransportSMTP = "smtp"
+	// TransportMaildir writes outgoing messages to a local maildir instead of sending them.
+	TransportMaildir = "maildir"
+)
+
+// What the IMAP import does with a message on the server once it is stored.
+const (
+	ImapKeep   = "keep"
+	ImapMove   = "move"
+	ImapDelete = "delete"
+)
+
+// Config holds every setting of the server. It is loaded once at startup by
+// Load and handed to the services and workers that need it.
+type Config struct {
+	// Domain is the primary hosted mail domain. It is created at startup
+	// when missing; further domains are managed by admins.
+	Domain         string
+	DatabaseDSN    string
+	TokenSecret    string
+	AttachmentsDir string
+
+	HTTP      HTTPConfig
+	Transport TransportConfig
+	IMAP      IMAPConfig
+	Purge     PurgeConfig
+	Schedule  ScheduleConfig
+}
+
+type HTTPConfig struct {
+	Addr              string
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	ShutdownTimeout   time.Duration
+	MaxHeaderBytes    int
+	MaxBodyBytes      int64
+}
+
+// TransportConfig selects how mail to external recipients leaves the
+// service: one of the SMTP modes, or a maildir for development.
+type TransportConfig struct {
+	Mode               string
+	Host               string
+	Port               string
+	User               string
+	Password           string
+	InsecureSkipVerify bool
+	MaildirPath        string
+}
+
+// IMAPConfig describes the mailbox external mail is imported from. An empty
+// Host disables the import.
+type IMAPConfig struct {
+	Host            string
+	User            string
+	Password        string
+	AfterImport     string
+	ProcessedFolder string
+	// QuarantineFolder receives messages with no local recipient. They are
+	// dropped when it is empty.
+	QuarantineFolder string
+	PollInterval     time.Duration
+}
+
+// PurgeConfig sets when deleted mails are removed for good: once every
+// participant has deleted a mail and Retention has passed since the last
+// of them did.
+type PurgeConfig struct {
+	Retention time.Duration
+	Interval  time.Duration
+}
+
+// ScheduleConfig sets how often scheduled mails are checked for being due,
+// and so how late after its send time a mail may go out.
+type ScheduleConfig struct {
+	Interval time.Duration
+}
+
+// Validate reports every invalid or missing setting at once.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.DatabaseDSN != "", "DB_CONF is required")
+	check(c.Domain != "" && !strings.ContainsAny(c.Domain, "@ "), "DOMAIN %q is not a valid domain", c.Domain)
+	check(c.AttachmentsDir != "", "ATTACHMENTS_DIR is required")
+
+	check(c.HTTP.Addr != "", "HTTP_ADDR is required")
+	check(c.HTTP.ReadHeaderTimeout > 0, "HTTP_READ_HEADER_TIMEOUT must be positive")
+	check(c.HTTP.ShutdownTimeout > 0, "HTTP_SHUTDOWN_TIMEOUT must be positive")
+	check(c.HTTP.MaxHeaderBytes > 0, "HTTP_MAX_HEADER_BYTES must be positive")
+	check(c.HTTP.MaxBodyBytes > 0, "HTTP_MAX_BODY_BYTES must be positive")
+
+	switch c.Transport.Mode {
+	case TransportSMTPS, TransportStartTLS, TransportSMTP:
+		check(c.Transport.Host != "", "SMTP_HOST is required for MAIL_TRANSPORT %q", c.Transport.Mode)
+	case TransportMaildir:
+		check(c.Transport.MaildirPath != "", "MAILDIR_PATH is required for MAIL_TRANSPORT %q", c.Transport.Mode)
+	default:
+		check(false, "MAIL_TRANSPORT %q is not one of smtps, starttls, smtp, maildir", c.Transport.Mode)
+	}
+
+	check(c.Purge.Retention > 0, "TRASH_RETENTION must be positive")
+	check(c.Purge.Interval > 0, "PURGE_INTERVAL must be positive")
+	check(c.Schedule.Interval > 0, "SCHEDULE_INTERVAL must be positive")
+
+	if c.IMAP.Host != "" {
+		check(c.IMAP.User != "", "IMAP_USER is required when IMAP_HOST is set")
+		check(c.IMAP.PollInterval > 0, "IMAP_POLL_INTERVAL must be positive")
+		switch c.IMAP.AfterImport {
+		case ImapKeep, ImapDelete:
+		case ImapMove:
+			check(c.IMAP.ProcessedFolder != "", "IMAP_PROCESSED_FOLDER is required for IMAP_AFTER_IMPORT %q", ImapMove)
+		default:
+			check(false, "IMAP_AFTER_IMPORT %q is not one of keep, move, delete", c.IMAP.AfterImport)
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,206 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Load reads the configuration from, in order of precedence, the process
+// environment, a .env file in the working directory, the YAML or TOML file at
+// path and the built-in defaults. The file uses the same keys as the
+// environment. Secrets can also be read from the file named by <KEY>_FILE.
+// An empty path falls back to CONFIG_FILE; no file is read if both are empty.
+func Load(path string) (*Config, error) {
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf(".env: %w", err)
+	}
+
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	file, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &loader{file: file}
+	mailPass := l.secret("MAIL_PASS")
+	conf := &Config{
+		Domain:         l.string("DOMAIN", "gomail.kurs"),
+		DatabaseDSN:    l.secret("DB_CONF"),
+		TokenSecret:    l.secret("TOKEN_SECRET"),
+		AttachmentsDir: l.string("ATTACHMENTS_DIR", "attachments"),
+		HTTP: HTTPConfig{
+			Addr:              l.string("HTTP_ADDR", ":8081"),
+			ReadHeaderTimeout: l.duration("HTTP_READ_HEADER_TIMEOUT", 10*time.Second),
+			ReadTimeout:       l.duration("HTTP_READ_TIMEOUT", time.Minute),
+			WriteTimeout:      l.duration("HTTP_WRITE_TIMEOUT", time.Minute),
+			IdleTimeout:       l.duration("HTTP_IDLE_TIMEOUT", 2*time.Minute),
+			ShutdownTimeout:   l.duration("HTTP_SHUTDOWN_TIMEOUT", 15*time.Second),
+			MaxHeaderBytes:    l.int("HTTP_MAX_HEADER_BYTES", http.DefaultMaxHeaderBytes),
+			MaxBodyBytes:      int64(l.int("HTTP_MAX_BODY_BYTES", 32<<20)),
+		},
+		Transport: TransportConfig{
+			Mode:               l.string("MAIL_TRANSPORT", TransportSMTPS),
+			Host:               l.string("SMTP_HOST", ""),
+			Port:               l.string("SMTP_PORT", ""),
+			User:               l.string("SMTP_USER", ""),
+			Password:           mailPass,
+			InsecureSkipVerify: l.bool("SMTP_INSECURE_SKIP_VERIFY", false),
+			MaildirPath:        l.string("MAILDIR_PATH", "maildir"),
+		},
+		IMAP: IMAPConfig{
+			Host:             l.string("IMAP_HOST", ""),
+			User:             l.string("IMAP_USER", ""),
+			Password:         mailPass,
+			AfterImport:      l.string("IMAP_AFTER_IMPORT", ImapKeep),
+			ProcessedFolder:  l.string("IMAP_PROCESSED_FOLDER", "Processed"),
+			QuarantineFolder: l.string("IMAP_QUARANTINE_FOLDER", ""),
+			PollInterval:     l.duration("IMAP_POLL_INTERVAL", 10*time.Second),
+		},
+		Purge: PurgeConfig{
+			Retention: l.duration("TRASH_RETENTION", 30*24*time.Hour),
+			Interval:  l.duration("PURGE_INTERVAL", time.Hour),
+		},
+		Schedule: ScheduleConfig{
+			Interval: l.duration("SCHEDULE_INTERVAL", 5*time.Second),
+		},
+	}
+
+	if len(l.errs) > 0 {
+		return nil, errors.Join(l.errs...)
+	}
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// readFile decodes a flat YAML or TOML file, chosen by extension, into
+// string values keyed like the environment.
+func readFile(path string) (map[string]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("%s: unsupported config format, use .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	values := make(map[string]string, len(raw))
+	for key, v := range raw {
+		switch v.(type) {
+		case map[string]interface{}, []interface{}:
+			return nil, fmt.Errorf("%s: %s must be a plain value", path, key)
+		}
+		values[strings.ToUpper(key)] = fmt.Sprint(v)
+	}
+	return values, nil
+}
+
+// loader looks keys up in the environment, then in the config file, and
+// collects every malformed value so they are reported together.
+type loader struct {
+	file map[string]string
+	errs []error
+}
+
+func (l *loader) lookup(key string) (string, bool) {
+	if v, ok := os.LookupEnv(key); ok {
+		return v, true
+	}
+	v, ok := l.file[key]
+	return v, ok
+}
+
+func (l *loader) string(key, def string) string {
+	if v, ok := l.lookup(key); ok {
+		return v
+	}
+	return def
+}
+
+// secret reads key directly or from the file named by key_FILE, but not
+// both.
+func (l *loader) secret(key string) string {
+	v, hasValue := l.lookup(key)
+	path, hasFile := l.lookup(key + "_FILE")
+	if !hasFile {
+		return v
+	}
+	if hasValue {
+		l.errs = append(l.errs, fmt.Errorf("%s and %s_FILE are both set", key, key))
+		return ""
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s_FILE: %w", key, err))
+		return ""
+	}
+	return strings.TrimRight(string(data), "\r\n")
+}
+
+func (l *loader) duration(key string, def time.Duration) time.Duration {
+	v, ok := l.lookup(key)
+	if !ok || v == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s: invalid duration %q", key, v))
+	}
+	return d
+}
+
+func (l *loader) int(key string, def int) int {
+	v, ok := l.lookup(key)
+	if !ok || v == "" {
+		return def
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s: invalid number %q", key, v))
+	}
+	return n
+}
+
+func (l *loader) bool(key string, def bool) bool {
+	v, ok := l.lookup(key)
+	if !ok || v == "" {
+		return def
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s: invalid boolean %q", key, v))
+	}
+	return b
+}
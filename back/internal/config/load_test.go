@@ -0,0 +1,90 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var extPattern = regexp.MustCompile(`^[a-zA-Z]{1,8}$`)
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func FuzzLoad_File(f *testing.F) {
+	f.Add("yaml", "DB_CONF: host=db\nMAIL_TRANSPORT: maildir\nHTTP_READ_TIMEOUT: 30s\n")
+	f.Add("toml", "DB_CONF = \"host=db\"\nMAIL_TRANSPORT = \"maildir\"\nHTTP_MAX_BODY_BYTES = 1024\n")
+	f.Add("yml", "DB_CONF: host=db\nSMTP_HOST: smtp.example.com\nIMAP_HOST: imap.example.com:993\n")
+	f.Add("json", "{}")
+	f.Add("yaml", "db: {dsn: x}\n")
+
+	f.Fuzz(func(t *testing.T, ext, content string) {
+		if !extPattern.MatchString(ext) {
+			t.Skip()
+		}
+
+		conf, err := Load(writeFile(t, "config."+ext, content))
+		if err != nil {
+			assert.Nil(t, conf)
+			return
+		}
+
+		assert.NoError(t, conf.Validate())
+		assert.NotEmpty(t, conf.DatabaseDSN)
+		assert.Positive(t, conf.HTTP.MaxBodyBytes)
+	})
+}
+
+func TestLoad_EnvOverridesFile(t *testing.T) {
+	path := writeFile(t, "config.yaml", "DB_CONF: from-file\nMAIL_TRANSPORT: maildir\nHTTP_ADDR: \":9000\"\n")
+	t.Setenv("HTTP_ADDR", ":9100")
+	t.Setenv("IMAP_POLL_INTERVAL", "1m")
+
+	conf, err := Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "from-file", conf.DatabaseDSN)
+	assert.Equal(t, ":9100", conf.HTTP.Addr)
+	assert.Equal(t, time.Minute, conf.IMAP.PollInterval)
+	assert.Equal(t, "gomail.kurs", conf.Domain)
+	assert.Equal(t, 30*24*time.Hour, conf.Purge.Retention)
+	assert.Equal(t, 5*time.Second, conf.Schedule.Interval)
+}
+
+func TestLoad_SecretFiles(t *testing.T) {
+	t.Setenv("DB_CONF", "host=db")
+	t.Setenv("MAIL_TRANSPORT", "maildir")
+	t.Setenv("MAIL_PASS_FILE", writeFile(t, "mail_pass", "s3cret\n"))
+
+	conf, err := Load("")
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cret", conf.Transport.Password)
+	assert.Equal(t, "s3cret", conf.IMAP.Password)
+
+	t.Setenv("MAIL_PASS", "plain")
+	_, err = Load("")
+	assert.ErrorContains(t, err, "MAIL_PASS and MAIL_PASS_FILE are both set")
+}
+
+func TestLoad_Validation(t *testing.T) {
+	t.Setenv("MAIL_TRANSPORT", "pigeon")
+	t.Setenv("HTTP_READ_TIMEOUT", "soon")
+
+	_, err := Load("")
+	assert.ErrorContains(t, err, "HTTP_READ_TIMEOUT")
+
+	t.Setenv("HTTP_READ_TIMEOUT", "")
+	t.Setenv("TRASH_RETENTION", "0s")
+	_, err = Load("")
+	assert.ErrorContains(t, err, "DB_CONF is required")
+	assert.ErrorContains(t, err, "TRASH_RETENTION must be positive")
+	assert.ErrorContains(t, err, "MAIL_TRANSPORT \"pigeon\"")
+}
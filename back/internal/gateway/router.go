@@ -4,16 +4,17 @@ import (
 	"backend/internal/model"
 	"backend/internal/service"
 	"backend/utils"
-	"log"
+	"net/http"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
-func InitRouter(services service.Service, basicMw *utils.BasicAuthMiddleware,
-	roleMw *utils.RoleMiddleware,
-) {
+func InitRouter(services service.Service, authMw *utils.TokenAuthMiddleware,
+	roleMw *utils.RoleMiddleware, maxBodyBytes int64,
+) http.Handler {
 	router := gin.Default()
+	router.Use(limitBody(maxBodyBytes))
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
@@ -27,28 +28,87 @@ func InitRouter(services service.Service, basicMw *utils.BasicAuthMiddleware,
 		api.POST("/register", services.AuthService.RegisterUser)
 		api.POST("/login", services.AuthService.Login)
 
-		mail := api.Group("/mail", basicMw.Middleware())
+		auth := api.Group("/auth")
+		{
+			auth.POST("/refresh", services.AuthService.Refresh)
+			auth.POST("/logout", services.AuthService.Logout)
+		}
+
+		mail := api.Group("/mail", authMw.Middleware())
 		{
 			mail.GET("/inbox", services.MailService.GetInboxMails)
 			mail.GET("/sent", services.MailService.GetSentMails)
+			mail.GET("/search", services.MailService.SearchMails)
+			mail.GET("/threads", services.MailService.GetThreads)
+			mail.GET("/threads/:id", services.MailService.GetThread)
 			mail.POST("/send", services.MailService.SendMail)
-			mail.POST("/trash", services.MailService.GetTrash)
+			mail.GET("/scheduled", services.MailService.GetScheduled)
+			mail.PUT("/scheduled/:id", services.MailService.RescheduleMail)
+			mail.DELETE("/scheduled/:id", services.MailService.CancelScheduled)
+			mail.GET("/settings", services.MailService.GetSettings)
+			mail.PUT("/settings", services.MailService.UpdateSettings)
+			mail.GET("/drafts", services.MailService.GetDrafts)
+			mail.POST("/drafts", services.MailService.CreateDraft)
+			mail.GET("/drafts/:id", services.MailService.GetDraft)
+			mail.PUT("/drafts/:id", services.MailService.UpdateDraft)
+			mail.DELETE("/drafts/:id", services.MailService.DeleteDraft)
+			mail.GET("/drafts/:id/revisions", services.MailService.GetDraftRevisions)
+			mail.GET("/drafts/:id/attachments/:aid", services.MailService.GetDraftAttachment)
+			mail.POST("/drafts/:id/send", services.MailService.SendDraft)
+			mail.GET("/folders", services.MailService.GetFolders)
+			mail.POST("/folders", services.MailService.CreateFolder)
+			mail.PUT("/folders/:id", services.MailService.UpdateFolder)
+			mail.DELETE("/folders/:id", services.MailService.DeleteFolder)
+			mail.GET("/folders/:id/mails", services.MailService.GetFolderMails)
+			mail.GET("/labels", services.MailService.GetLabels)
+			mail.POST("/labels", services.MailService.CreateLabel)
+			mail.PUT("/labels/:id", services.MailService.UpdateLabel)
+			mail.DELETE("/labels/:id", services.MailService.DeleteLabel)
+			mail.GET("/labels/:id/mails", services.MailService.GetLabelMails)
+			mail.POST("/bulk", services.MailService.BulkMails)
+			mail.GET("/:id", services.MailService.GetMail)
+			mail.POST("/:id/flags", services.MailService.SetFlags)
+			mail.POST("/:id/move", services.MailService.MoveMail)
+			mail.POST("/:id/labels", services.MailService.LabelMail)
+			mail.POST("/:id/reply", services.MailService.ReplyMail)
+			mail.POST("/:id/reply-all", services.MailService.ReplyAllMail)
+			mail.POST("/:id/forward", services.MailService.ForwardMail)
+			mail.POST("/:id/undo-send", services.MailService.UndoSend)
+			mail.GET("/:id/attachments/:aid", services.MailService.GetAttachment)
+			mail.GET("/archive", services.MailService.GetArchive)
+			mail.GET("/trash", services.MailService.GetTrash)
+			mail.DELETE("/trash", services.MailService.EmptyTrash)
+			// Older clients list the archive with POST /trash.
+			mail.POST("/trash", services.MailService.GetArchive)
+			mail.POST("/:id/restore", services.MailService.RestoreMail)
 			mail.POST("/:id/unarchive", services.MailService.UnArchiveMail)
 			mail.POST("/:id/archive", services.MailService.ArchiveMail)
 			mail.DELETE("/:id/delete", services.MailService.DeleteMail)
 		}
 
-		admin := api.Group("/admin", basicMw.Middleware(), roleMw.Middleware(model.RoleAdmin))
+		admin := api.Group("/admin", authMw.Middleware(), roleMw.Middleware(model.RoleAdmin))
 		{
 			admin.GET("/users", services.AdminService.GetAllUsers)
 			admin.DELETE("/users/:id", services.AdminService.DeleteUser)
 			admin.GET("/mails", services.AdminService.GetAllMails)
 			admin.DELETE("/mails/:id", services.AdminService.DeleteMail)
+			admin.GET("/domains", services.AdminService.GetDomains)
+			admin.POST("/domains", services.AdminService.CreateDomain)
+			admin.PUT("/domains/:id", services.AdminService.UpdateDomain)
+			admin.DELETE("/domains/:id", services.AdminService.DeleteDomain)
 		}
 	}
 
-	port := "8081"
+	return router
+}
 
-	log.Printf("Run server on port = %s", port)
-	router.Run(":" + port)
+// limitBody rejects request bodies larger than n bytes once a handler reads
+// past the limit.
+func limitBody(n int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Body != nil {
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, n)
+		}
+		c.Next()
+	}
 }
@@ -1,16 +1,49 @@
 package model
 
-import "gorm.io/gorm"
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Tables lists every table of the application, in the order they are
+// migrated.
+var Tables = []interface{}{
+	&User{},
+	&Domain{},
+	&Mail{},
+	&Mailbox{},
+	&Recipient{},
+	&Draft{},
+	&DraftRevision{},
+	&DraftAttachment{},
+	&Folder{},
+	&Label{},
+	&MailboxLabel{},
+	&Attachment{},
+	&Delivery{},
+	&ImapState{},
+	&RefreshToken{},
+}
 
 type MailDB interface {
 	Model(value interface{}) (tx MailDB)
 	Select(query interface{}, args ...interface{}) (tx MailDB)
 	Create(value interface{}) (tx MailDB)
 	Update(column string, value interface{}) (tx MailDB)
+	Updates(values interface{}) (tx MailDB)
 	Delete(value interface{}, conds ...interface{}) (tx MailDB)
 	Where(query interface{}, args ...interface{}) (tx MailDB)
 	Find(dest interface{}, conds ...interface{}) (tx MailDB)
 	First(dest interface{}, conds ...interface{}) (tx MailDB)
+	Joins(query string, args ...interface{}) (tx MailDB)
+	Order(value interface{}) (tx MailDB)
+	Group(name string) (tx MailDB)
+	Having(query interface{}, args ...interface{}) (tx MailDB)
+	Limit(limit int) (tx MailDB)
+	Preload(query string, args ...interface{}) (tx MailDB)
+	Clauses(conds ...clause.Expression) (tx MailDB)
+	Transaction(fc func(tx MailDB) error) error
+	RowsAffected() int64
 	Error() error
 }
 
@@ -38,6 +71,10 @@ func (m *mailDB) Update(column string, value interface{}) (tx MailDB) {
 	return &mailDB{m.DB.Update(column, value)}
 }
 
+func (m *mailDB) Updates(values interface{}) (tx MailDB) {
+	return &mailDB{m.DB.Updates(values)}
+}
+
 func (m *mailDB) Delete(value interface{}, conds ...interface{}) (tx MailDB) {
 	return &mailDB{m.DB.Delete(value, conds...)}
 }
@@ -54,6 +91,44 @@ func (m *mailDB) First(dest interface{}, conds ...interface{}) (tx MailDB) {
 	return &mailDB{m.DB.First(dest, conds...)}
 }
 
+func (m *mailDB) Joins(query string, args ...interface{}) (tx MailDB) {
+	return &mailDB{m.DB.Joins(query, args...)}
+}
+
+func (m *mailDB) Order(value interface{}) (tx MailDB) {
+	return &mailDB{m.DB.Order(value)}
+}
+
+func (m *mailDB) Group(name string) (tx MailDB) {
+	return &mailDB{m.DB.Group(name)}
+}
+
+func (m *mailDB) Having(query interface{}, args ...interface{}) (tx MailDB) {
+	return &mailDB{m.DB.Having(query, args...)}
+}
+
+func (m *mailDB) Limit(limit int) (tx MailDB) {
+	return &mailDB{m.DB.Limit(limit)}
+}
+
+func (m *mailDB) Preload(query string, args ...interface{}) (tx MailDB) {
+	return &mailDB{m.DB.Preload(query, args...)}
+}
+
+func (m *mailDB) Clauses(conds ...clause.Expression) (tx MailDB) {
+	return &mailDB{m.DB.Clauses(conds...)}
+}
+
+func (m *mailDB) Transaction(fc func(tx MailDB) error) error {
+	return m.DB.Transaction(func(tx *gorm.DB) error {
+		return fc(&mailDB{tx})
+	})
+}
+
+func (m *mailDB) RowsAffected() int64 {
+	return m.DB.RowsAffected
+}
+
 func (m *mailDB) Error() error {
 	return m.DB.Error
 }
@@ -0,0 +1,37 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Domain is a mail domain hosted by the service. Mail to its addresses is
+// delivered to local mailboxes; every other address is external.
+type Domain struct {
+	Id               uint   `gorm:"primaryKey"`
+	Name             string `gorm:"uniqueIndex;not null"`
+	RegistrationOpen bool   `gorm:"not null"`
+	// DefaultQuota is given to users registering under the domain, in
+	// bytes. Zero means unlimited.
+	DefaultQuota int64 `gorm:"not null;default:0"`
+	// CatchAll is the local address that receives mail sent to unknown
+	// users of the domain. Such mail is dropped when it is empty.
+	CatchAll  string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// EnsureDomains makes sure the domain called name is hosted, so a fresh
+// database accepts mail for the configured primary domain, and so is the
+// domain of every existing user, so their mail keeps being stored locally.
+// Domains added for users are closed to registration.
+func EnsureDomains(db *gorm.DB, name string) error {
+	if err := db.Where(Domain{Name: name}).FirstOrCreate(&Domain{Name: name, RegistrationOpen: true}).Error; err != nil {
+		return err
+	}
+	return db.Exec(`INSERT INTO domains (name, registration_open, default_quota, created_at, updated_at)
+		SELECT DISTINCT lower(substring(email from '@([^@]+)$')), false, 0, now(), now()
+		FROM users WHERE email LIKE '%_@_%'
+		ON CONFLICT (name) DO NOTHING`).Error
+}
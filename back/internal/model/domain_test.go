@@ -0,0 +1,36 @@
+//go:build integration
+
+package model_test
+
+import (
+	"backend/internal/model"
+	"backend/internal/testdb"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnsureDomains(t *testing.T) {
+	db := testdb.Open(t)
+	require.NoError(t, db.Create(&model.Domain{Name: "old.kurs", RegistrationOpen: true}).Error)
+	for _, email := range []string{"alice@gomail.kurs", "admin@Admin.Gomail.kurs", "bob@old.kurs"} {
+		require.NoError(t, db.Create(&model.User{Email: email, Password: "x"}).Error)
+	}
+
+	require.NoError(t, model.EnsureDomains(db, "gomail.kurs"))
+	// Running it again changes nothing.
+	require.NoError(t, model.EnsureDomains(db, "gomail.kurs"))
+
+	var domains []model.Domain
+	require.NoError(t, db.Order("name").Find(&domains).Error)
+	open := make(map[string]bool, len(domains))
+	for _, d := range domains {
+		open[d.Name] = d.RegistrationOpen
+	}
+	assert.Equal(t, map[string]bool{
+		"admin.gomail.kurs": false,
+		"gomail.kurs":       true,
+		"old.kurs":          true,
+	}, open)
+}
@@ -0,0 +1,57 @@
+package model
+
+import "time"
+
+type (
+	// Draft is a mail a user is still writing. Addresses are kept as typed
+	// and only validated when the draft is sent.
+	Draft struct {
+		Id         uint     `gorm:"primaryKey"`
+		UserId     uint     `gorm:"index;not null"`
+		To         []string `gorm:"type:text;serializer:json"`
+		Cc         []string `gorm:"type:text;serializer:json"`
+		Bcc        []string `gorm:"type:text;serializer:json"`
+		Subject    string
+		Body       string
+		HTMLBody   string
+		InReplyTo  string
+		References string `gorm:"type:text"`
+		// Version grows with every save, so an autosave based on an older
+		// version can be told apart from the current content.
+		Version   int `gorm:"not null;default:1"`
+		CreatedAt time.Time
+		UpdatedAt time.Time
+
+		Attachments []DraftAttachment `gorm:"foreignKey:DraftId"`
+	}
+
+	// DraftRevision is the content a draft had before one of its saves.
+	DraftRevision struct {
+		Id         uint     `gorm:"primaryKey"`
+		DraftId    uint     `gorm:"not null;uniqueIndex:idx_draft_revision"`
+		Version    int      `gorm:"not null;uniqueIndex:idx_draft_revision"`
+		To         []string `gorm:"type:text;serializer:json"`
+		Cc         []string `gorm:"type:text;serializer:json"`
+		Bcc        []string `gorm:"type:text;serializer:json"`
+		Subject    string
+		Body       string
+		HTMLBody   string
+		InReplyTo  string
+		References string `gorm:"type:text"`
+		CreatedAt  time.Time
+	}
+
+	// DraftAttachment is a file uploaded to a draft. It points into the
+	// attachment store like Attachment and becomes one when the draft is
+	// sent.
+	DraftAttachment struct {
+		Id          uint   `gorm:"primaryKey"`
+		DraftId     uint   `gorm:"index;not null"`
+		Filename    string `gorm:"not null"`
+		ContentType string `gorm:"not null"`
+		Size        int64  `gorm:"not null"`
+		Checksum    string `gorm:"type:char(64);not null"`
+		StorageKey  string `gorm:"not null"`
+		CreatedAt   time.Time
+	}
+)
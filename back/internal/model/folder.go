@@ -0,0 +1,74 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type (
+	// Folder is a folder a user created to file mails in. Folders nest
+	// through ParentId; top-level folders have none.
+	Folder struct {
+		Id        uint   `gorm:"primaryKey"`
+		UserId    uint   `gorm:"index;not null"`
+		ParentId  *uint  `gorm:"index"`
+		Name      string `gorm:"not null"`
+		CreatedAt time.Time
+		UpdatedAt time.Time
+	}
+
+	// Label tags mails of a user across folders. A mail can carry any
+	// number of labels.
+	Label struct {
+		Id        uint   `gorm:"primaryKey"`
+		UserId    uint   `gorm:"index;not null"`
+		Name      string `gorm:"not null"`
+		Color     string `gorm:"type:varchar(7);not null"`
+		CreatedAt time.Time
+		UpdatedAt time.Time
+	}
+
+	// MailboxLabel puts a label on a user's copy of a mail.
+	MailboxLabel struct {
+		MailboxId uint `gorm:"primaryKey"`
+		LabelId   uint `gorm:"primaryKey;index"`
+	}
+)
+
+// MigrateFolders makes folder names unique among the folders of a user
+// sharing a parent, ignoring case. The index also covers top-level folders,
+// whose parent is NULL. Label names are made unique per user the same way,
+// replacing the old index that told names apart by case. Duplicates created
+// before the indexes existed are renamed first, keeping the oldest folder
+// or label of each name as it is.
+func MigrateFolders(db *gorm.DB) error {
+	if err := db.Exec(`UPDATE folders SET name = name || ' (' || id || ')'
+		WHERE id IN (
+			SELECT id FROM (
+				SELECT id, row_number() OVER (PARTITION BY user_id, COALESCE(parent_id, 0), lower(name) ORDER BY id) AS n
+				FROM folders
+			) numbered WHERE n > 1
+		)`).Error; err != nil {
+		return err
+	}
+	if err := db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_folder_name
+		ON folders (user_id, COALESCE(parent_id, 0), lower(name))`).Error; err != nil {
+		return err
+	}
+
+	if err := db.Exec(`UPDATE labels SET name = name || ' (' || id || ')'
+		WHERE id IN (
+			SELECT id FROM (
+				SELECT id, row_number() OVER (PARTITION BY user_id, lower(name) ORDER BY id) AS n
+				FROM labels
+			) numbered WHERE n > 1
+		)`).Error; err != nil {
+		return err
+	}
+	if err := db.Exec(`DROP INDEX IF EXISTS idx_label_name`).Error; err != nil {
+		return err
+	}
+	return db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_label_lower_name
+		ON labels (user_id, lower(name))`).Error
+}
@@ -0,0 +1,105 @@
+//go:build integration
+
+package model_test
+
+import (
+	"backend/internal/model"
+	"backend/internal/testdb"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestMigrateFolders(t *testing.T) {
+	db := testdb.Open(t)
+	require.NoError(t, db.Exec("DROP INDEX idx_folder_name").Error)
+
+	work := model.Folder{UserId: 1, Name: "Work"}
+	require.NoError(t, db.Create(&work).Error)
+	duplicates := []model.Folder{
+		{UserId: 1, Name: "work"},
+		{UserId: 1, Name: "Reports", ParentId: &work.Id},
+		{UserId: 1, Name: "REPORTS", ParentId: &work.Id},
+		{UserId: 2, Name: "Work"},
+	}
+	require.NoError(t, db.Create(&duplicates).Error)
+
+	require.NoError(t, model.MigrateFolders(db))
+
+	var names []string
+	require.NoError(t, db.Model(&model.Folder{}).Order("id").Pluck("name", &names).Error)
+	assert.Equal(t, []string{
+		"Work",
+		fmt.Sprintf("work (%d)", duplicates[0].Id),
+		"Reports",
+		fmt.Sprintf("REPORTS (%d)", duplicates[2].Id),
+		"Work",
+	}, names)
+
+	tests := []struct {
+		name   string
+		folder model.Folder
+		err    error
+	}{
+		{"top-level name in another case", model.Folder{UserId: 1, Name: "WORK"}, gorm.ErrDuplicatedKey},
+		{"nested name in another case", model.Folder{UserId: 1, Name: "reports", ParentId: &work.Id}, gorm.ErrDuplicatedKey},
+		{"same name under another parent", model.Folder{UserId: 1, Name: "Work", ParentId: &work.Id}, nil},
+		{"same name of another user", model.Folder{UserId: 3, Name: "Work"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := db.Create(&tt.folder).Error
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestMigrateFolders_Labels(t *testing.T) {
+	db := testdb.Open(t)
+	// Databases migrated before told label names apart by case.
+	require.NoError(t, db.Exec("DROP INDEX idx_label_lower_name").Error)
+	require.NoError(t, db.Exec("CREATE UNIQUE INDEX idx_label_name ON labels (user_id, name)").Error)
+
+	labels := []model.Label{
+		{UserId: 1, Name: "Work", Color: "#808080"},
+		{UserId: 1, Name: "WORK", Color: "#808080"},
+		{UserId: 2, Name: "work", Color: "#808080"},
+	}
+	require.NoError(t, db.Create(&labels).Error)
+
+	require.NoError(t, model.MigrateFolders(db))
+
+	var names []string
+	require.NoError(t, db.Model(&model.Label{}).Order("id").Pluck("name", &names).Error)
+	assert.Equal(t, []string{"Work", fmt.Sprintf("WORK (%d)", labels[1].Id), "work"}, names)
+
+	var oldIndex bool
+	require.NoError(t, db.Raw("SELECT count(*) > 0 FROM pg_indexes WHERE schemaname = current_schema() AND indexname = 'idx_label_name'").Scan(&oldIndex).Error)
+	assert.False(t, oldIndex)
+
+	tests := []struct {
+		name  string
+		label model.Label
+		err   error
+	}{
+		{"name in another case", model.Label{UserId: 1, Name: "work", Color: "#808080"}, gorm.ErrDuplicatedKey},
+		{"same name of another user", model.Label{UserId: 3, Name: "Work", Color: "#808080"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := db.Create(&tt.label).Error
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,14 @@
+package model
+
+import "time"
+
+// ImapState remembers how far a remote mailbox has been imported. LastUid is
+// only meaningful while the server keeps reporting the same UidValidity.
+type ImapState struct {
+	Id          uint   `gorm:"primaryKey"`
+	Account     string `gorm:"not null;uniqueIndex:idx_imap_state_mailbox"`
+	Mailbox     string `gorm:"not null;uniqueIndex:idx_imap_state_mailbox"`
+	UidValidity uint32 `gorm:"not null"`
+	LastUid     uint32 `gorm:"not null;default:0"`
+	UpdatedAt   time.Time
+}
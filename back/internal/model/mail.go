@@ -1,24 +1,114 @@
 package model
 
 import (
+	"time"
+
 	"github.com/jackc/pgx/pgtype"
 	"github.com/jinzhu/gorm"
-	"github.com/lib/pq"
+)
+
+const (
+	MailboxSender    = "sender"
+	MailboxRecipient = "recipient"
+
+	FolderInbox   = "inbox"
+	FolderSent    = "sent"
+	FolderArchive = "archive"
+	// FolderTrash holds deleted mails the user can still restore.
+	FolderTrash = "trash"
+	// FolderDeleted hides a mail from the user for good. Mails are purged
+	// once every copy is in the trash or deleted for long enough.
+	FolderDeleted = "deleted"
+	// FolderUser marks a mail filed in one of the user's own folders,
+	// given by Mailbox.FolderId.
+	FolderUser = "user"
+	// FolderScheduled holds the sender's copy of a mail waiting for its
+	// send time. Recipients get their copies once it is sent.
+	FolderScheduled = "scheduled"
+
+	// FlagAnswered and FlagForwarded are set on a user's copy of a mail
+	// once a reply to it or a forward of it is sent.
+	FlagAnswered  = "answered"
+	FlagForwarded = "forwarded"
+
+	RecipientTo  = "to"
+	RecipientCc  = "cc"
+	RecipientBcc = "bcc"
 )
 
 type (
 	Mail struct {
 		gorm.Model
-		Sender    string       `gorm:"not null"`
+		MessageId string `gorm:"index"`
+		// InReplyTo and References hold the Message-IDs of the parent and
+		// of every ancestor, as in the RFC 5322 headers of the same name.
+		InReplyTo  string
+		References string `gorm:"type:text"`
+		// ThreadId groups a mail with the rest of its conversation.
+		ThreadId string `gorm:"type:varchar(24);index"`
+		Sender   string `gorm:"not null"`
+		// Receivers lists the visible To and Cc addresses. Bcc addresses
+		// only exist in Recipients.
 		Receivers pgtype.JSONB `gorm:"type:jsonb;default:'[]';not null"`
-		Subject string
-		Body    string
+		Subject   string
+		Body      string
+		HTMLBody  string
+		// SendAt is when a scheduled mail is due to be sent. It is cleared
+		// once the mail is sent.
+		SendAt *time.Time `gorm:"index"`
+		// SourceMailId is the mail a reply or forward was written from.
+		// SourceFlag, FlagAnswered or FlagForwarded, is set on the sender's
+		// copy of it once this mail is sent.
+		SourceMailId *uint
+		SourceFlag   string `gorm:"type:varchar(10)"`
+
+		Recipients  []Recipient  `gorm:"foreignKey:MailId"`
+		Attachments []Attachment `gorm:"foreignKey:MailId"`
+		// Labels and Flags are the labels and flags the requesting user
+		// has on the mail. They are filled in per request and not stored.
+		Labels []uint `gorm:"-"`
+		Flags  *Flags `gorm:"-"`
 	}
 
-	Trash struct {
+	// Recipient is one address of a mail with the header it was sent in.
+	Recipient struct {
+		Id      uint   `gorm:"primaryKey"`
+		MailId  uint   `gorm:"index;not null"`
+		Address string `gorm:"not null"`
+		Type    string `gorm:"type:varchar(3);not null"`
+	}
+
+	Attachment struct {
 		gorm.Model
-		UserId   uint          `gorm:"uniqueIndex;not null"`
-		Archived pq.Int64Array `gorm:"type:integer[]"`
-		Deleted  pq.Int64Array `gorm:"type:integer[]"`
+		MailId      uint   `gorm:"index;not null"`
+		Filename    string `gorm:"not null"`
+		ContentType string `gorm:"not null"`
+		Size        int64  `gorm:"not null"`
+		Checksum    string `gorm:"type:char(64);not null"`
+		StorageKey  string `gorm:"not null"`
+	}
+
+	// Mailbox is a single user's copy of a mail: one row for the sender and
+	// one row per local recipient, each moving between folders on its own.
+	Mailbox struct {
+		gorm.Model
+		MailId uint   `gorm:"not null;uniqueIndex:idx_mailbox_owner"`
+		UserId uint   `gorm:"not null;uniqueIndex:idx_mailbox_owner;index:idx_mailbox_folder"`
+		Role   string `gorm:"type:varchar(10);not null;uniqueIndex:idx_mailbox_owner"`
+		Folder string `gorm:"type:varchar(20);not null;index:idx_mailbox_folder"`
+		// FolderId is the user folder holding the mail while Folder is
+		// FolderUser.
+		FolderId *uint `gorm:"index"`
+		Flags    Flags `gorm:"embedded"`
+		// TrashedAt is when the mail was moved to the trash.
+		TrashedAt *time.Time
+	}
+
+	// Flags is the state of a mail for one user.
+	Flags struct {
+		Seen      bool `gorm:"not null;default:false"`
+		Flagged   bool `gorm:"not null;default:false"`
+		Answered  bool `gorm:"not null;default:false"`
+		Forwarded bool `gorm:"not null;default:false"`
 	}
 )
@@ -0,0 +1,97 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/jackc/pgx/pgtype"
+	"gorm.io/gorm"
+)
+
+// legacyReceivers decodes the receivers column of mails, which holds the
+// JSON encoding of pgtype.JSONB, base64 list and all, rather than the list.
+// DecodeReceivers does the same for a loaded mail.
+const legacyReceivers = "CASE jsonb_typeof(receivers)" +
+	" WHEN 'object' THEN convert_from(decode(receivers->>'Bytes', 'base64'), 'UTF8')::jsonb" +
+	" ELSE receivers END"
+
+// DecodeReceivers returns the addresses held by the receivers column of a
+// mail, either as the JSON encoding of pgtype.JSONB or as a plain list.
+func DecodeReceivers(receivers pgtype.JSONB) ([]string, error) {
+	raw := bytes.TrimSpace(receivers.Bytes)
+	if receivers.Status != pgtype.Present || len(raw) == 0 {
+		return nil, nil
+	}
+	if raw[0] == '{' {
+		var stored struct {
+			Bytes  []byte
+			Status pgtype.Status
+		}
+		if err := json.Unmarshal(raw, &stored); err != nil {
+			return nil, err
+		}
+		if stored.Status != pgtype.Present {
+			return nil, nil
+		}
+		raw = stored.Bytes
+	}
+
+	var addrs []string
+	if err := json.Unmarshal(raw, &addrs); err != nil {
+		return nil, err
+	}
+	return addrs, nil
+}
+
+// MigrateMailboxes gives mails stored before mailboxes existed a mailbox
+// for their local sender and for each local address they were sent to,
+// taking the folder from the former per-user trash table: archived mails
+// go to the archive and deleted ones are deleted. The trash table is
+// dropped once its contents are moved, so this only ever runs once.
+func MigrateMailboxes(db *gorm.DB) error {
+	if !db.Migrator().HasTable("trashes") {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`WITH legacy AS (
+				SELECT id, created_at, sender, `+legacyReceivers+` AS receivers FROM mails
+				WHERE NOT EXISTS (SELECT 1 FROM mailboxes WHERE mailboxes.mail_id = mails.id)
+			), owners AS (
+				SELECT legacy.id AS mail_id, legacy.created_at, users.id AS user_id, ?::text AS role, ?::text AS home
+				FROM legacy JOIN users ON users.email IN (`+addressOf("legacy.sender")+`)
+				UNION
+				SELECT legacy.id, legacy.created_at, users.id, ?::text, ?::text
+				FROM legacy
+					CROSS JOIN LATERAL jsonb_array_elements_text(
+						CASE WHEN jsonb_typeof(legacy.receivers) = 'array' THEN legacy.receivers ELSE '[]'::jsonb END
+					) AS receiver(address)
+					JOIN users ON users.email IN (`+addressOf("receiver.address")+`)
+			)
+			INSERT INTO mailboxes (created_at, updated_at, mail_id, user_id, role, folder, seen)
+			SELECT owners.created_at, now(), owners.mail_id, owners.user_id, owners.role,
+				CASE
+					WHEN owners.mail_id = ANY(trashes.deleted) THEN ?
+					WHEN owners.mail_id = ANY(trashes.archived) THEN ?
+					ELSE owners.home
+				END,
+				owners.role = ?
+			FROM owners LEFT JOIN trashes ON trashes.user_id = owners.user_id
+			ON CONFLICT DO NOTHING`,
+			MailboxSender, FolderSent, MailboxRecipient, FolderInbox,
+			FolderDeleted, FolderArchive, MailboxSender).Error; err != nil {
+			return err
+		}
+
+		return tx.Migrator().DropTable("trashes")
+	})
+}
+
+// addressOf lists the addresses a stored sender or receiver may name: the
+// whole value, the address in angle brackets, and a quoted display name,
+// which holds the local address when mail is relayed from outside.
+func addressOf(column string) string {
+	return "lower(btrim(" + column + ")), " +
+		"lower(substring(" + column + " from '<([^>]+)>')), " +
+		"lower(substring(" + column + " from '^\\s*\"([^\"]+)\"'))"
+}
@@ -0,0 +1,69 @@
+//go:build integration
+
+package model_test
+
+import (
+	"backend/internal/model"
+	"backend/internal/testdb"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateMailboxes(t *testing.T) {
+	db := testdb.Open(t)
+
+	alice := model.User{Email: "alice@gomail.kurs", Password: "x"}
+	bob := model.User{Email: "bob@gomail.kurs", Password: "x"}
+	require.NoError(t, db.Create(&alice).Error)
+	require.NoError(t, db.Create(&bob).Error)
+
+	// Receivers are stored the way the application always stored them,
+	// as the JSON encoding of pgtype.JSONB.
+	newMail := func(sender string, receivers ...string) uint {
+		mail := model.Mail{Sender: sender}
+		require.NoError(t, mail.Receivers.Set(receivers))
+		require.NoError(t, db.Create(&mail).Error)
+		return mail.ID
+	}
+	archived := newMail("alice@gomail.kurs", "bob@gomail.kurs")
+	deleted := newMail("Alice <Alice@gomail.kurs>", "Bob@gomail.kurs", "someone@example.com")
+	inbound := newMail("someone@example.com", "alice@gomail.kurs", `"bob@gomail.kurs" <relay@example.com>`)
+	migrated := newMail("alice@gomail.kurs", "bob@gomail.kurs")
+	require.NoError(t, db.Create(&model.Mailbox{MailId: migrated, UserId: bob.Id, Role: model.MailboxRecipient, Folder: model.FolderUser}).Error)
+
+	require.NoError(t, db.Exec(`CREATE TABLE trashes (
+		id bigserial PRIMARY KEY, created_at timestamptz, updated_at timestamptz, deleted_at timestamptz,
+		user_id bigint NOT NULL UNIQUE, archived integer[], deleted integer[])`).Error)
+	require.NoError(t, db.Exec(`INSERT INTO trashes (user_id, archived, deleted) VALUES (?, ARRAY[?]::integer[], ARRAY[?]::integer[])`,
+		bob.Id, archived, deleted).Error)
+
+	require.NoError(t, model.MigrateMailboxes(db))
+
+	type box struct {
+		MailId uint
+		UserId uint
+		Role   string
+		Folder string
+		Seen   bool
+	}
+	var boxes []box
+	require.NoError(t, db.Model(&model.Mailbox{}).Order("mail_id, user_id, role").Find(&boxes).Error)
+	assert.Equal(t, []box{
+		{archived, alice.Id, model.MailboxSender, model.FolderSent, true},
+		{archived, bob.Id, model.MailboxRecipient, model.FolderArchive, false},
+		{deleted, alice.Id, model.MailboxSender, model.FolderSent, true},
+		{deleted, bob.Id, model.MailboxRecipient, model.FolderDeleted, false},
+		{inbound, alice.Id, model.MailboxRecipient, model.FolderInbox, false},
+		{inbound, bob.Id, model.MailboxRecipient, model.FolderInbox, false},
+		{migrated, bob.Id, model.MailboxRecipient, model.FolderUser, false},
+	}, boxes)
+	assert.False(t, db.Migrator().HasTable("trashes"))
+
+	// Without the trash table there is nothing left to migrate.
+	require.NoError(t, model.MigrateMailboxes(db))
+	var count int64
+	require.NoError(t, db.Model(&model.Mailbox{}).Count(&count).Error)
+	assert.Equal(t, int64(len(boxes)), count)
+}
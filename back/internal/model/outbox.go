@@ -0,0 +1,25 @@
+package model
+
+import "time"
+
+const (
+	DeliveryQueued   = "queued"
+	DeliverySent     = "sent"
+	DeliveryDeferred = "deferred"
+	DeliveryFailed   = "failed"
+)
+
+// Delivery tracks sending one mail to one external recipient through the
+// outbound transport.
+type Delivery struct {
+	Id            uint      `gorm:"primaryKey"`
+	MailId        uint      `gorm:"index;not null"`
+	Recipient     string    `gorm:"not null"`
+	Status        string    `gorm:"type:varchar(10);not null;default:'queued';index:idx_delivery_due"`
+	Attempts      int       `gorm:"not null;default:0"`
+	NextAttemptAt time.Time `gorm:"not null;index:idx_delivery_due"`
+	LastError     string
+	SentAt        *time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
@@ -0,0 +1,44 @@
+package model_test
+
+import (
+	"backend/internal/model"
+	"encoding/json"
+	"testing"
+
+	"github.com/jackc/pgx/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeReceivers(t *testing.T) {
+	var stored pgtype.JSONB
+	require.NoError(t, stored.Set([]string{"bob@gomail.kurs", "carol@example.com"}))
+	object, err := json.Marshal(stored)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		column  pgtype.JSONB
+		want    []string
+		wantErr bool
+	}{
+		{"stored as pgtype.JSONB", pgtype.JSONB{Bytes: object, Status: pgtype.Present}, []string{"bob@gomail.kurs", "carol@example.com"}, false},
+		{"stored as a list", pgtype.JSONB{Bytes: []byte(`["bob@gomail.kurs"]`), Status: pgtype.Present}, []string{"bob@gomail.kurs"}, false},
+		{"null object", pgtype.JSONB{Bytes: []byte(`{"Bytes": null, "Status": 1}`), Status: pgtype.Present}, nil, false},
+		{"null column", pgtype.JSONB{Status: pgtype.Null}, nil, false},
+		{"invalid base64", pgtype.JSONB{Bytes: []byte(`{"Bytes": "%%", "Status": 2}`), Status: pgtype.Present}, nil, true},
+		{"not a list", pgtype.JSONB{Bytes: []byte(`"bob@gomail.kurs"`), Status: pgtype.Present}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := model.DecodeReceivers(tt.column)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
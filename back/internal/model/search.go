@@ -0,0 +1,19 @@
+package model
+
+import "gorm.io/gorm"
+
+// MigrateSearch adds the full-text search column and index on mails. The
+// column is generated by PostgreSQL, so it never has to be kept in sync by
+// the application and is not part of the Mail struct.
+func MigrateSearch(db *gorm.DB) error {
+	if err := db.Exec(`ALTER TABLE mails ADD COLUMN IF NOT EXISTS search_vector tsvector
+		GENERATED ALWAYS AS (
+			setweight(to_tsvector('simple', coalesce(subject, '')), 'A') ||
+			setweight(to_tsvector('simple', coalesce(body, '')), 'B') ||
+			setweight(to_tsvector('simple', coalesce(sender, '')), 'C')
+		) STORED`).Error; err != nil {
+		return err
+	}
+
+	return db.Exec(`CREATE INDEX IF NOT EXISTS idx_mails_search_vector ON mails USING GIN (search_vector)`).Error
+}
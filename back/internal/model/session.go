@@ -0,0 +1,14 @@
+package model
+
+import "time"
+
+// RefreshToken is a server-side record of an issued refresh token. Only the
+// SHA-256 hash of the token is stored, so a database leak cannot be replayed.
+type RefreshToken struct {
+	Id        uint      `gorm:"primaryKey"`
+	UserId    uint      `gorm:"index;not null"`
+	TokenHash string    `gorm:"uniqueIndex;not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	RevokedAt *time.Time
+	CreatedAt time.Time
+}
@@ -0,0 +1,28 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"gorm.io/gorm"
+)
+
+// ThreadID derives the thread of a conversation from the Message-ID of its
+// first mail. Replies that arrive before their parent land in the same
+// thread the parent will get.
+func ThreadID(rootMessageID string) string {
+	sum := sha256.Sum256([]byte(rootMessageID))
+	return hex.EncodeToString(sum[:])[:24]
+}
+
+// MigrateThreads gives mails stored before threading a Message-ID and a
+// thread of their own, computed the same way as ThreadID.
+func MigrateThreads(db *gorm.DB) error {
+	if err := db.Exec(`UPDATE mails SET message_id = '<legacy.' || id || '@gomail.local>'
+		WHERE message_id IS NULL OR message_id = ''`).Error; err != nil {
+		return err
+	}
+
+	return db.Exec(`UPDATE mails SET thread_id = substr(encode(sha256(convert_to(message_id, 'UTF8')), 'hex'), 1, 24)
+		WHERE thread_id IS NULL OR thread_id = ''`).Error
+}
@@ -10,4 +10,10 @@ type User struct {
 	Email    string `gorm:"uniqueIndex;not null"`
 	Password string `gorm:"not null"`
 	Role     string `gorm:"type:varchar(10);not null;default:'user'"`
+	// Quota is the storage limit of the user in bytes, taken from the
+	// domain's default at registration. Zero means unlimited.
+	Quota int64 `gorm:"not null;default:0"`
+	// UndoSendSeconds holds every mail the user sends back for that long,
+	// during which sending can be undone. Zero sends mail right away.
+	UndoSendSeconds int `gorm:"not null;default:0"`
 }
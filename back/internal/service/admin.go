@@ -2,9 +2,14 @@ package service
 
 import (
 	"backend/internal/model"
+	"backend/utils"
+	"errors"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 type (
@@ -13,6 +18,10 @@ type (
 		DeleteUser(c *gin.Context)
 		GetAllMails(c *gin.Context)
 		DeleteMail(c *gin.Context)
+		GetDomains(c *gin.Context)
+		CreateDomain(c *gin.Context)
+		UpdateDomain(c *gin.Context)
+		DeleteDomain(c *gin.Context)
 	}
 
 	adminService struct {
@@ -35,10 +44,30 @@ func (as *adminService) GetAllUsers(c *gin.Context) {
 	c.JSON(http.StatusOK, users)
 }
 
+// DeleteUser removes a user with everything they own. Their sessions are
+// revoked and their copies of mails, drafts, folders and labels removed;
+// mails no other user holds are deleted for good.
 func (as *adminService) DeleteUser(c *gin.Context) {
-	userID := c.Param("id")
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid userID"})
+		return
+	}
 
-	if err := as.db.Where("id = ?", userID).Delete(&model.User{}).Error(); err != nil {
+	err = as.db.Transaction(func(tx model.MailDB) error {
+		res := tx.Where("id = ?", userID).Delete(&model.User{})
+		if err := res.Error(); err != nil {
+			return err
+		}
+		if res.RowsAffected() == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return deleteUserData(tx, uint(userID))
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "User not found"})
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error deleting user"})
 		return
 	}
@@ -55,13 +84,76 @@ func (as *adminService) GetAllMails(c *gin.Context) {
 	c.JSON(http.StatusOK, mails)
 }
 
+// DeleteMail removes a mail for every participant, along with its
+// pending deliveries.
 func (as *adminService) DeleteMail(c *gin.Context) {
-	mailID := c.Param("id")
+	mailID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid mailID"})
+		return
+	}
 
-	if err := as.db.Where("id = ?", mailID).Delete(&model.Mail{}).Error(); err != nil {
+	if err := as.db.Transaction(func(tx model.MailDB) error {
+		return utils.DeleteMails(tx, []uint{uint(mailID)})
+	}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error deleting mail"})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{})
 }
+
+// deleteUserData revokes the sessions of userID and removes the rows they
+// own. Mails left without any mailbox would never be purged, so they are
+// deleted along.
+func deleteUserData(tx model.MailDB, userID uint) error {
+	if err := tx.Model(&model.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error(); err != nil {
+		return err
+	}
+
+	var boxes []model.Mailbox
+	if err := tx.Select("mail_id").Where("user_id = ?", userID).Find(&boxes).Error(); err != nil {
+		return err
+	}
+
+	for _, rows := range []struct {
+		query string
+		table interface{}
+	}{
+		{"mailbox_id IN (SELECT id FROM mailboxes WHERE user_id = ?)", &model.MailboxLabel{}},
+		{"user_id = ?", &model.Mailbox{}},
+		{"draft_id IN (SELECT id FROM drafts WHERE user_id = ?)", &model.DraftRevision{}},
+		{"draft_id IN (SELECT id FROM drafts WHERE user_id = ?)", &model.DraftAttachment{}},
+		{"user_id = ?", &model.Draft{}},
+		{"user_id = ?", &model.Label{}},
+		{"user_id = ?", &model.Folder{}},
+	} {
+		if err := tx.Where(rows.query, userID).Delete(rows.table).Error(); err != nil {
+			return err
+		}
+	}
+
+	if len(boxes) == 0 {
+		return nil
+	}
+	mailIDs := make([]uint, 0, len(boxes))
+	for _, box := range boxes {
+		mailIDs = append(mailIDs, box.MailId)
+	}
+	var orphans []model.Mail
+	if err := tx.Select("id").
+		Where("id IN ? AND NOT EXISTS (SELECT 1 FROM mailboxes WHERE mailboxes.mail_id = mails.id)", mailIDs).
+		Find(&orphans).Error(); err != nil {
+		return err
+	}
+	if len(orphans) == 0 {
+		return nil
+	}
+	ids := make([]uint, 0, len(orphans))
+	for _, mail := range orphans {
+		ids = append(ids, mail.ID)
+	}
+	return utils.DeleteMails(tx, ids)
+}
@@ -0,0 +1,79 @@
+//go:build integration
+
+package service
+
+import (
+	"backend/internal/model"
+	"backend/internal/testdb"
+	"backend/utils"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminService_DeleteUserData(t *testing.T) {
+	db := testdb.Open(t)
+	service := NewAdminService(model.NewMailDB(db))
+
+	alice := model.User{Email: "alice@gomail.kurs", Password: "x"}
+	bob := model.User{Email: "bob@gomail.kurs", Password: "x"}
+	require.NoError(t, db.Create(&alice).Error)
+	require.NoError(t, db.Create(&bob).Error)
+
+	shared := model.Mail{Sender: alice.Email, Subject: "shared"}
+	own := model.Mail{Sender: alice.Email, Subject: "own"}
+	require.NoError(t, db.Create(&shared).Error)
+	require.NoError(t, db.Create(&own).Error)
+	aliceBox := model.Mailbox{MailId: shared.ID, UserId: alice.Id, Role: model.MailboxSender, Folder: model.FolderSent}
+	require.NoError(t, db.Create(&aliceBox).Error)
+	require.NoError(t, db.Create(&model.Mailbox{MailId: shared.ID, UserId: bob.Id, Role: model.MailboxRecipient, Folder: model.FolderInbox}).Error)
+	require.NoError(t, db.Create(&model.Mailbox{MailId: own.ID, UserId: alice.Id, Role: model.MailboxSender, Folder: model.FolderSent}).Error)
+	require.NoError(t, db.Create(&model.Delivery{MailId: own.ID, Recipient: "carol@example.com", Status: model.DeliveryQueued, NextAttemptAt: time.Now()}).Error)
+
+	label := model.Label{UserId: alice.Id, Name: "Work"}
+	require.NoError(t, db.Create(&label).Error)
+	require.NoError(t, db.Create(&model.MailboxLabel{MailboxId: aliceBox.ID, LabelId: label.Id}).Error)
+	require.NoError(t, db.Create(&model.Folder{UserId: alice.Id, Name: "Projects"}).Error)
+	draft := model.Draft{UserId: alice.Id, Subject: "draft", Version: 2}
+	require.NoError(t, db.Create(&draft).Error)
+	require.NoError(t, db.Create(&model.DraftRevision{DraftId: draft.Id, Version: 1}).Error)
+
+	refreshToken, hash, err := utils.NewRefreshToken()
+	require.NoError(t, err)
+	require.NoError(t, db.Create(&model.RefreshToken{UserId: alice.Id, TokenHash: hash, ExpiresAt: time.Now().Add(time.Hour)}).Error)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Params = gin.Params{gin.Param{Key: "id", Value: strconv.Itoa(int(alice.Id))}}
+	service.DeleteUser(c)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	count := func(table interface{}, query string, args ...interface{}) int64 {
+		var n int64
+		require.NoError(t, db.Model(table).Where(query, args...).Count(&n).Error)
+		return n
+	}
+	assert.Zero(t, count(&model.Mailbox{}, "user_id = ?", alice.Id))
+	assert.Zero(t, count(&model.MailboxLabel{}, "label_id = ?", label.Id))
+	assert.Zero(t, count(&model.Label{}, "user_id = ?", alice.Id))
+	assert.Zero(t, count(&model.Folder{}, "user_id = ?", alice.Id))
+	assert.Zero(t, count(&model.Draft{}, "user_id = ?", alice.Id))
+	assert.Zero(t, count(&model.DraftRevision{}, "draft_id = ?", draft.Id))
+	assert.Zero(t, count(&model.RefreshToken{}, "user_id = ? AND revoked_at IS NULL", alice.Id))
+	// The mail bob holds stays, the one only alice held goes with its
+	// pending delivery.
+	assert.Equal(t, int64(1), count(&model.Mail{}, "id = ?", shared.ID))
+	assert.Equal(t, int64(1), count(&model.Mailbox{}, "user_id = ?", bob.Id))
+	assert.Zero(t, count(&model.Mail{}, "id = ?", own.ID))
+	assert.Zero(t, count(&model.Delivery{}, "mail_id = ?", own.ID))
+
+	auth := NewAuthService(model.NewMailDB(db), utils.NewTokenIssuer([]byte("secret"), utils.AccessTokenTTL))
+	w = serve(auth.Refresh, 0, 0, http.MethodPost, `{"refresh_token": "`+refreshToken+`"}`)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
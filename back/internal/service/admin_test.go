@@ -2,16 +2,15 @@ package service
 
 import (
 	"backend/internal/model"
-	"math/rand"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
-	"strconv"
 	"testing"
-	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm/clause"
 )
 
 type MockMailDB struct {
@@ -38,6 +37,10 @@ func (m *MockMailDB) Update(column string, value interface{}) (tx model.MailDB)
 	return m.Called(column, value).Get(0).(model.MailDB)
 }
 
+func (m *MockMailDB) Updates(values interface{}) (tx model.MailDB) {
+	return m.Called(values).Get(0).(model.MailDB)
+}
+
 func (m *MockMailDB) Delete(value interface{}, conds ...interface{}) (tx model.MailDB) {
 	callArgs := make([]interface{}, 0)
 	callArgs = append(callArgs, value)
@@ -66,67 +69,197 @@ func (m *MockMailDB) First(dest interface{}, conds ...interface{}) (tx model.Mai
 	return m.Called(callArgs...).Get(0).(model.MailDB)
 }
 
-func (m *MockMailDB) Error() error {
-	return m.Called().Error(0)
+func (m *MockMailDB) Joins(query string, args ...interface{}) (tx model.MailDB) {
+	callArgs := make([]interface{}, 0)
+	callArgs = append(callArgs, query)
+	callArgs = append(callArgs, args...)
+	return m.Called(callArgs...).Get(0).(model.MailDB)
 }
 
-func FuzzAdminService_DeleteUser(f *testing.F) {
-	f.Add("123")
-	f.Add("999")
-	f.Add("0")
-	f.Add("abc")
+func (m *MockMailDB) Order(value interface{}) (tx model.MailDB) {
+	return m.Called(value).Get(0).(model.MailDB)
+}
 
-	f.Fuzz(func(t *testing.T, userID string) {
-		mockDB := new(MockMailDB)
-		service := NewAdminService(mockDB)
+func (m *MockMailDB) Group(name string) (tx model.MailDB) {
+	return m.Called(name).Get(0).(model.MailDB)
+}
 
-		mockDB.On("Where", "id = ?", userID).Return(mockDB)
-		mockDB.On("Delete", &model.User{}).Return(mockDB)
+func (m *MockMailDB) Having(query interface{}, args ...interface{}) (tx model.MailDB) {
+	callArgs := make([]interface{}, 0)
+	callArgs = append(callArgs, query)
+	callArgs = append(callArgs, args...)
+	return m.Called(callArgs...).Get(0).(model.MailDB)
+}
 
-		if rand.Intn(2) == 0 {
-			mockDB.On("Error").Return(nil)
-		} else {
-			mockDB.On("Error").Return(assert.AnError)
-		}
+func (m *MockMailDB) Limit(limit int) (tx model.MailDB) {
+	return m.Called(limit).Get(0).(model.MailDB)
+}
+
+func (m *MockMailDB) Preload(query string, args ...interface{}) (tx model.MailDB) {
+	callArgs := make([]interface{}, 0)
+	callArgs = append(callArgs, query)
+	callArgs = append(callArgs, args...)
+	return m.Called(callArgs...).Get(0).(model.MailDB)
+}
 
-		w := httptest.NewRecorder()
-		c, _ := gin.CreateTestContext(w)
-		c.Params = gin.Params{gin.Param{Key: "id", Value: userID}}
+func (m *MockMailDB) Clauses(conds ...clause.Expression) (tx model.MailDB) {
+	callArgs := make([]interface{}, 0, len(conds))
+	for _, cond := range conds {
+		callArgs = append(callArgs, cond)
+	}
+	return m.Called(callArgs...).Get(0).(model.MailDB)
+}
 
-		service.DeleteUser(c)
+func (m *MockMailDB) Transaction(fc func(tx model.MailDB) error) error {
+	return fc(m)
+}
 
-		assert.True(t, w.Code == http.StatusOK || w.Code == http.StatusInternalServerError)
-		mockDB.AssertExpectations(t)
-	})
+func (m *MockMailDB) RowsAffected() int64 {
+	return m.Called().Get(0).(int64)
 }
 
-func FuzzAdminService_DeleteMail(f *testing.F) {
-	rand.Seed(time.Now().UnixNano())
-	f.Add("1")
-	f.Add("100000")
-	f.Add("invalid_id")
-	f.Add(strconv.Itoa(rand.Int()))
+func (m *MockMailDB) Error() error {
+	return m.Called().Error(0)
+}
 
-	f.Fuzz(func(t *testing.T, mailID string) {
-		mockDB := new(MockMailDB)
-		service := NewAdminService(mockDB)
+func TestAdminService_DeleteUser(t *testing.T) {
+	tests := []struct {
+		name     string
+		userID   string
+		affected int64
+		orphans  []uint
+		code     int
+	}{
+		{"with mails of their own", "3", 1, []uint{8}, http.StatusOK},
+		{"with shared mails only", "3", 1, nil, http.StatusOK},
+		{"not found", "3", 0, nil, http.StatusNotFound},
+		{"invalid id", "abc", 1, nil, http.StatusBadRequest},
+	}
 
-		mockDB.On("Where", "id = ?", mailID).Return(mockDB)
-		mockDB.On("Delete", &model.Mail{}).Return(mockDB)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(MockMailDB)
+			service := NewAdminService(mockDB)
 
-		if rand.Intn(2) == 0 {
+			var deleted []string
+			mockDB.On("Where", "id = ?", 3).Return(mockDB)
+			mockDB.On("RowsAffected").Return(tt.affected)
+			mockDB.On("Model", &model.RefreshToken{}).Return(mockDB)
+			mockDB.On("Where", "user_id = ? AND revoked_at IS NULL", uint(3)).Return(mockDB)
+			mockDB.On("Update", "revoked_at", mock.AnythingOfType("time.Time")).Return(mockDB)
+			mockDB.On("Select", "mail_id").Return(mockDB)
+			mockDB.On("Where", "user_id = ?", uint(3)).Return(mockDB)
+			mockDB.On("Find", mock.AnythingOfType("*[]model.Mailbox")).Return(mockDB).Run(func(args mock.Arguments) {
+				*args.Get(0).(*[]model.Mailbox) = []model.Mailbox{{MailId: 7}, {MailId: 8}}
+			})
+			mockDB.On("Where", "mailbox_id IN (SELECT id FROM mailboxes WHERE user_id = ?)", uint(3)).Return(mockDB)
+			mockDB.On("Where", "draft_id IN (SELECT id FROM drafts WHERE user_id = ?)", uint(3)).Return(mockDB)
+			mockDB.On("Select", "id").Return(mockDB)
+			mockDB.On("Where", "id IN ? AND NOT EXISTS (SELECT 1 FROM mailboxes WHERE mailboxes.mail_id = mails.id)", []uint{7, 8}).Return(mockDB)
+			mockDB.On("Find", mock.AnythingOfType("*[]model.Mail")).Return(mockDB).Run(func(args mock.Arguments) {
+				mails := make([]model.Mail, len(tt.orphans))
+				for i, id := range tt.orphans {
+					mails[i].ID = id
+				}
+				*args.Get(0).(*[]model.Mail) = mails
+			})
+			mockDB.On("Where", "mailbox_id IN (SELECT id FROM mailboxes WHERE mail_id IN ?)", []uint{8}).Return(mockDB)
+			mockDB.On("Where", "mail_id IN ?", []uint{8}).Return(mockDB)
+			mockDB.On("Where", "id IN ?", []uint{8}).Return(mockDB)
+			mockDB.On("Delete", mock.Anything).Return(mockDB).Run(func(args mock.Arguments) {
+				deleted = append(deleted, fmt.Sprintf("%T", args.Get(0)))
+			})
 			mockDB.On("Error").Return(nil)
-		} else {
-			mockDB.On("Error").Return(assert.AnError)
-		}
 
-		w := httptest.NewRecorder()
-		c, _ := gin.CreateTestContext(w)
-		c.Params = gin.Params{gin.Param{Key: "id", Value: mailID}}
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Params = gin.Params{gin.Param{Key: "id", Value: tt.userID}}
+
+			service.DeleteUser(c)
+
+			assert.Equal(t, tt.code, w.Code)
+			switch tt.code {
+			case http.StatusBadRequest:
+				assert.Empty(t, deleted)
+				return
+			case http.StatusNotFound:
+				assert.Equal(t, []string{"*model.User"}, deleted)
+				mockDB.AssertNotCalled(t, "Update", mock.Anything, mock.Anything)
+				return
+			}
+
+			mockDB.AssertCalled(t, "Update", "revoked_at", mock.AnythingOfType("time.Time"))
+			want := []string{
+				"*model.User",
+				"*model.MailboxLabel",
+				"*model.Mailbox",
+				"*model.DraftRevision",
+				"*model.DraftAttachment",
+				"*model.Draft",
+				"*model.Label",
+				"*model.Folder",
+			}
+			if tt.orphans != nil {
+				want = append(want,
+					"*model.MailboxLabel",
+					"*model.Mailbox",
+					"*model.Recipient",
+					"*model.Attachment",
+					"*model.Delivery",
+					"*model.Mail",
+				)
+			}
+			assert.Equal(t, want, deleted)
+		})
+	}
+}
+
+func TestAdminService_DeleteMail(t *testing.T) {
+	tests := []struct {
+		name   string
+		mailID string
+		err    error
+		code   int
+	}{
+		{"deleted", "4", nil, http.StatusOK},
+		{"database error", "4", assert.AnError, http.StatusInternalServerError},
+		{"invalid id", "invalid_id", nil, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(MockMailDB)
+			service := NewAdminService(mockDB)
+
+			var deleted []string
+			mockDB.On("Where", "mailbox_id IN (SELECT id FROM mailboxes WHERE mail_id IN ?)", []uint{4}).Return(mockDB)
+			mockDB.On("Where", "mail_id IN ?", []uint{4}).Return(mockDB)
+			mockDB.On("Where", "id IN ?", []uint{4}).Return(mockDB)
+			mockDB.On("Delete", mock.Anything).Return(mockDB).Run(func(args mock.Arguments) {
+				deleted = append(deleted, fmt.Sprintf("%T", args.Get(0)))
+			})
+			mockDB.On("Error").Return(tt.err)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Params = gin.Params{gin.Param{Key: "id", Value: tt.mailID}}
 
-		service.DeleteMail(c)
+			service.DeleteMail(c)
 
-		assert.True(t, w.Code == http.StatusOK || w.Code == http.StatusInternalServerError)
-		mockDB.AssertExpectations(t)
-	})
+			assert.Equal(t, tt.code, w.Code)
+			switch tt.code {
+			case http.StatusOK:
+				assert.Equal(t, []string{
+					"*model.MailboxLabel",
+					"*model.Mailbox",
+					"*model.Recipient",
+					"*model.Attachment",
+					"*model.Delivery",
+					"*model.Mail",
+				}, deleted)
+			case http.StatusBadRequest:
+				assert.Empty(t, deleted)
+			}
+		})
+	}
 }
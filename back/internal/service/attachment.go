@@ -0,0 +1,121 @@
+package service
+
+import (
+	"backend/internal/model"
+	"backend/utils"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxAttachmentsSize = 25 << 20
+
+var errAttachmentsTooLarge = errors.New("Attachments are too large")
+
+func (ms *mailService) GetAttachment(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	mailID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid mailID"})
+		return
+	}
+	attachmentID, err := strconv.Atoi(c.Param("aid"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid attachmentID"})
+		return
+	}
+
+	var participant bool
+	if err := ms.db.Model(&model.Mailbox{}).
+		Select("count(*) > 0").
+		Where("user_id = ? AND mail_id = ? AND folder IN ?", userID, mailID, visibleFolders).
+		Find(&participant).Error(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error checking mail access"})
+		return
+	}
+	if !participant {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Mail not found"})
+		return
+	}
+
+	var att model.Attachment
+	if err := ms.db.Where("id = ? AND mail_id = ?", attachmentID, mailID).First(&att).Error(); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Attachment not found"})
+		return
+	}
+
+	ms.serveAttachment(c, att.Filename, att.ContentType, att.StorageKey, att.Size)
+}
+
+// serveAttachment streams a stored file as a download named filename.
+func (ms *mailService) serveAttachment(c *gin.Context, filename, contentType, key string, size int64) {
+	file, err := ms.store.Open(key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error reading attachment"})
+		return
+	}
+	defer file.Close()
+
+	c.DataFromReader(http.StatusOK, size, contentType, file, map[string]string{
+		"Content-Disposition": mime.FormatMediaType("attachment", map[string]string{"filename": filename}),
+	})
+}
+
+// saveUploads stores the files of a multipart send request. Requests that
+// are not multipart simply have no attachments.
+func (ms *mailService) saveUploads(c *gin.Context) ([]model.Attachment, int, error) {
+	form, err := c.MultipartForm()
+	if err != nil {
+		return nil, http.StatusOK, nil
+	}
+
+	var total int64
+	for _, fh := range form.File["attachments"] {
+		total += fh.Size
+	}
+	if total > maxAttachmentsSize {
+		return nil, http.StatusRequestEntityTooLarge, errAttachmentsTooLarge
+	}
+	if total > 0 {
+		if status, err := ms.checkUploadQuota(c.MustGet("userID").(uint), total); err != nil {
+			return nil, status, err
+		}
+	}
+
+	attachments := make([]model.Attachment, 0, len(form.File["attachments"]))
+	for _, fh := range form.File["attachments"] {
+		file, err := fh.Open()
+		if err != nil {
+			return nil, http.StatusBadRequest, errors.New("Invalid attachment")
+		}
+
+		att, err := ms.store.Put(fh.Filename, fh.Header.Get("Content-Type"), file)
+		file.Close()
+		if err != nil {
+			return nil, http.StatusInternalServerError, errors.New("Error saving attachment")
+		}
+		attachments = append(attachments, att)
+	}
+
+	return attachments, http.StatusOK, nil
+}
+
+// checkUploadQuota makes sure size more bytes of attachments fit in the
+// quota of userID, before any of them is stored.
+func (ms *mailService) checkUploadQuota(userID uint, size int64) (int, error) {
+	var user model.User
+	if err := ms.db.Where("id = ?", userID).First(&user).Error(); err != nil {
+		return http.StatusUnauthorized, errors.New("Invalid credentials")
+	}
+	err := utils.CheckQuota(ms.db, user, size)
+	switch {
+	case errors.Is(err, utils.ErrQuotaExceeded):
+		return http.StatusRequestEntityTooLarge, err
+	case err != nil:
+		return http.StatusInternalServerError, errors.New("Error checking quota")
+	}
+	return http.StatusOK, nil
+}
@@ -0,0 +1,55 @@
+//go:build integration
+
+package service
+
+import (
+	"backend/internal/model"
+	"backend/internal/testdb"
+	"backend/utils"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMailService_GetAttachmentFolders(t *testing.T) {
+	tests := []struct {
+		folder string
+		code   int
+	}{
+		{model.FolderInbox, http.StatusOK},
+		{model.FolderArchive, http.StatusOK},
+		{model.FolderTrash, http.StatusNotFound},
+		{model.FolderDeleted, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.folder, func(t *testing.T) {
+			db := testdb.Open(t)
+			store, err := utils.NewAttachmentStore(t.TempDir())
+			require.NoError(t, err)
+			service := NewMailService(model.NewMailDB(db), store)
+
+			mailID := inboxMails(t, db, 1, 1)[0]
+			require.NoError(t, db.Model(&model.Mailbox{}).Where("mail_id = ?", mailID).Update("folder", tt.folder).Error)
+			att, err := store.Put("report.txt", "text/plain", strings.NewReader("quarterly numbers"))
+			require.NoError(t, err)
+			att.MailId = mailID
+			require.NoError(t, db.Create(&att).Error)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Set("userID", uint(1))
+			c.Params = gin.Params{{Key: "id", Value: strconv.Itoa(int(mailID))}, {Key: "aid", Value: strconv.Itoa(int(att.ID))}}
+
+			service.GetAttachment(c)
+
+			assert.Equal(t, tt.code, w.Code)
+		})
+	}
+}
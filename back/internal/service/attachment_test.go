@@ -0,0 +1,72 @@
+package service
+
+import (
+	"backend/internal/model"
+	"backend/utils"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func FuzzMailService_GetAttachment(f *testing.F) {
+	f.Add(uint(1), "1", "1", false)
+	f.Add(uint(1), "invalid_id", "1", true)
+	f.Add(uint(2), "5", "invalid_id", true)
+	f.Add(uint(3), "7", "9", true)
+
+	f.Fuzz(func(t *testing.T, userID uint, mailID, attachmentID string, participant bool) {
+		store, err := utils.NewAttachmentStore(t.TempDir())
+		assert.NoError(t, err)
+		att, err := store.Put("report.txt", "text/plain", strings.NewReader("quarterly numbers"))
+		assert.NoError(t, err)
+
+		mockDB := new(MockMailDB)
+		service := NewMailService(mockDB, store)
+
+		mid, mailErr := strconv.Atoi(mailID)
+		aid, attErr := strconv.Atoi(attachmentID)
+		valid := mailErr == nil && attErr == nil
+
+		if valid {
+			mockDB.On("Model", mock.AnythingOfType("*model.Mailbox")).Return(mockDB)
+			mockDB.On("Select", "count(*) > 0").Return(mockDB)
+			mockDB.On("Where", "user_id = ? AND mail_id = ? AND folder IN ?", userID, mid, visibleFolders).Return(mockDB)
+			mockDB.On("Find", mock.AnythingOfType("*bool")).Return(mockDB).Run(func(args mock.Arguments) {
+				*args.Get(0).(*bool) = participant
+			})
+			mockDB.On("Error").Return(nil)
+
+			if participant {
+				mockDB.On("Where", "id = ? AND mail_id = ?", aid, mid).Return(mockDB)
+				mockDB.On("First", mock.AnythingOfType("*model.Attachment")).Return(mockDB).Run(func(args mock.Arguments) {
+					*args.Get(0).(*model.Attachment) = att
+				})
+			}
+		}
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Set("userID", userID)
+		c.Params = gin.Params{{Key: "id", Value: mailID}, {Key: "aid", Value: attachmentID}}
+
+		service.GetAttachment(c)
+
+		switch {
+		case !valid:
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		case !participant:
+			assert.Equal(t, http.StatusNotFound, w.Code)
+		default:
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "quarterly numbers", w.Body.String())
+			assert.Contains(t, w.Header().Get("Content-Disposition"), "report.txt")
+		}
+		mockDB.AssertExpectations(t)
+	})
+}
@@ -2,26 +2,36 @@ package service
 
 import (
 	"backend/internal/model"
+	"backend/utils"
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
+var errInvalidRefreshToken = errors.New("invalid refresh token")
+
 type (
 	AuthService interface {
 		RegisterUser(c *gin.Context)
 		Login(c *gin.Context)
+		Refresh(c *gin.Context)
+		Logout(c *gin.Context)
 	}
 
 	authService struct {
-		db model.MailDB
+		db     model.MailDB
+		tokens *utils.TokenIssuer
 	}
 )
 
-func NewAuthService(db model.MailDB) AuthService {
+func NewAuthService(db model.MailDB, tokens *utils.TokenIssuer) AuthService {
 	return &authService{
-		db: db,
+		db:     db,
+		tokens: tokens,
 	}
 }
 
@@ -36,16 +46,33 @@ func (as *authService) RegisterUser(c *gin.Context) {
 		return
 	}
 
+	email, ok := utils.ParseAddress(input.Email)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid email address"})
+		return
+	}
+
+	var domain model.Domain
+	if err := as.db.Where("name = ?", utils.AddressDomain(email)).First(&domain).Error(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Email domain is not hosted here"})
+		return
+	}
+	if !domain.RegistrationOpen {
+		c.JSON(http.StatusForbidden, gin.H{"message": "Registration is closed for this domain"})
+		return
+	}
+
 	var exists bool
-	if as.db.Model(&model.User{}).Select("count(*) > 0").Where("email = ?", input.Email).Find(&exists); exists {
+	if as.db.Model(&model.User{}).Select("count(*) > 0").Where("email = ?", email).Find(&exists); exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"message": "User already exists with this email address"})
 		return
 	}
 
 	user := model.User{
-		Email:    input.Email,
+		Email:    email,
 		Password: input.Password,
 		Role:     model.RoleUser,
+		Quota:    domain.DefaultQuota,
 	}
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
@@ -59,10 +86,6 @@ func (as *authService) RegisterUser(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error creating user"})
 		return
 	}
-	if err := as.db.Create(&model.Trash{UserId: user.Id}).Error(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error creating trash"})
-		return
-	}
 
 	c.JSON(http.StatusCreated, gin.H{})
 }
@@ -78,8 +101,14 @@ func (as *authService) Login(c *gin.Context) {
 		return
 	}
 
+	email, ok := utils.ParseAddress(input.Email)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "Invalid email"})
+		return
+	}
+
 	var user model.User
-	if err := as.db.Where("email = ?", input.Email).First(&user).Error(); err != nil {
+	if err := as.db.Where("email = ?", email).First(&user).Error(); err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"message": "Invalid email"})
 		return
 	}
@@ -89,5 +118,122 @@ func (as *authService) Login(c *gin.Context) {
 		return
 	}
 
+	session, err := as.issueSession(as.db, user.Id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error creating session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, session)
+}
+
+func (as *authService) Refresh(c *gin.Context) {
+	var input struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid input"})
+		return
+	}
+
+	var session gin.H
+	err := as.db.Transaction(func(tx model.MailDB) error {
+		var token model.RefreshToken
+		if err := tx.Where("token_hash = ? AND revoked_at IS NULL AND expires_at > ?",
+			utils.HashRefreshToken(input.RefreshToken), time.Now()).First(&token).Error(); err != nil {
+			return errInvalidRefreshToken
+		}
+
+		// A deleted user gets no new session, whatever token they hold.
+		var user model.User
+		if err := tx.Where("id = ?", token.UserId).First(&user).Error(); errors.Is(err, gorm.ErrRecordNotFound) {
+			return errInvalidRefreshToken
+		} else if err != nil {
+			return err
+		}
+
+		// Rotation: the presented token is spent, and a concurrent refresh
+		// with the same token loses the race instead of minting a second pair.
+		revoke := tx.Model(&model.RefreshToken{}).
+			Where("id = ? AND revoked_at IS NULL", token.Id).
+			Update("revoked_at", time.Now())
+		if err := revoke.Error(); err != nil {
+			return err
+		}
+		if revoke.RowsAffected() == 0 {
+			return errInvalidRefreshToken
+		}
+
+		var err error
+		session, err = as.issueSession(tx, token.UserId)
+		return err
+	})
+	if err == errInvalidRefreshToken {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "Invalid refresh token"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error refreshing session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, session)
+}
+
+func (as *authService) Logout(c *gin.Context) {
+	var input struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+		All          bool   `json:"all"`
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid input"})
+		return
+	}
+
+	var token model.RefreshToken
+	if err := as.db.Where("token_hash = ? AND revoked_at IS NULL", utils.HashRefreshToken(input.RefreshToken)).First(&token).Error(); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "Invalid refresh token"})
+		return
+	}
+
+	query := as.db.Model(&model.RefreshToken{}).Where("id = ? AND revoked_at IS NULL", token.Id)
+	if input.All {
+		query = as.db.Model(&model.RefreshToken{}).Where("user_id = ? AND revoked_at IS NULL", token.UserId)
+	}
+	if err := query.Update("revoked_at", time.Now()).Error(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error revoking session"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{})
 }
+
+// issueSession signs a new access token for userID and stores a fresh
+// refresh token next to it.
+func (as *authService) issueSession(db model.MailDB, userID uint) (gin.H, error) {
+	accessToken, expiresAt, err := as.tokens.Issue(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, hash, err := utils.NewRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Create(&model.RefreshToken{
+		UserId:    userID,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(utils.RefreshTokenTTL),
+	}).Error(); err != nil {
+		return nil, err
+	}
+
+	return gin.H{
+		"access_token":  accessToken,
+		"token_type":    "Bearer",
+		"expires_at":    expiresAt,
+		"refresh_token": refreshToken,
+	}, nil
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"backend/internal/model"
+	"backend/utils"
 	"bytes"
 	"encoding/json"
 	"math/rand"
@@ -14,6 +15,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 func FuzzAuthService_RegisterUser(f *testing.F) {
@@ -27,11 +29,15 @@ func FuzzAuthService_RegisterUser(f *testing.F) {
 
 	f.Fuzz(func(t *testing.T, email, password string) {
 		mockDB := new(MockMailDB)
-		service := NewAuthService(mockDB)
+		service := NewAuthService(mockDB, utils.NewTokenIssuer([]byte("secret"), utils.AccessTokenTTL))
 
+		mockDB.On("Where", "name = ?", mock.Anything).Return(mockDB)
+		mockDB.On("First", mock.AnythingOfType("*model.Domain")).Return(mockDB).Run(func(args mock.Arguments) {
+			args.Get(0).(*model.Domain).RegistrationOpen = rand.Intn(4) != 0
+		})
 		mockDB.On("Model", mock.AnythingOfType("*model.User")).Return(mockDB)
 		mockDB.On("Select", mock.Anything, mock.Anything).Return(mockDB)
-		mockDB.On("Where", "email = ?", email).Return(mockDB)
+		mockDB.On("Where", "email = ?", mock.Anything).Return(mockDB)
 
 		if rand.Intn(2) == 0 {
 			mockDB.On("Find", mock.Anything, mock.Anything).Return(mockDB).Run(func(args mock.Arguments) {
@@ -47,7 +53,6 @@ func FuzzAuthService_RegisterUser(f *testing.F) {
 
 		if rand.Intn(2) == 0 {
 			mockDB.On("Create", mock.AnythingOfType("*model.User")).Return(mockDB)
-			mockDB.On("Create", mock.AnythingOfType("*model.Trash")).Return(mockDB)
 			mockDB.On("Error").Return(nil)
 		} else {
 			mockDB.On("Create", mock.AnythingOfType("*model.User")).Return(mockDB)
@@ -71,6 +76,7 @@ func FuzzAuthService_RegisterUser(f *testing.F) {
 			http.StatusCreated,
 			http.StatusBadRequest,
 			http.StatusUnauthorized,
+			http.StatusForbidden,
 			http.StatusInternalServerError,
 		}
 		assert.Contains(t, validCodes, w.Code, "unexpected status code")
@@ -87,9 +93,10 @@ func FuzzAuthService_Login(f *testing.F) {
 
 	f.Fuzz(func(t *testing.T, email, password string) {
 		mockDB := new(MockMailDB)
-		service := NewAuthService(mockDB)
+		service := NewAuthService(mockDB, utils.NewTokenIssuer([]byte("secret"), utils.AccessTokenTTL))
 
-		mockDB.On("Where", "email = ?", email).Return(mockDB)
+		address, valid := utils.ParseAddress(email)
+		mockDB.On("Where", "email = ?", address).Return(mockDB).Maybe()
 
 		if rand.Intn(2) == 0 {
 			hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("correct_password"), bcrypt.DefaultCost)
@@ -100,6 +107,7 @@ func FuzzAuthService_Login(f *testing.F) {
 					Password: string(hashedPassword),
 				}
 			})
+			mockDB.On("Create", mock.AnythingOfType("*model.RefreshToken")).Return(mockDB).Maybe()
 			mockDB.On("Error").Return(nil)
 		} else {
 			mockDB.On("First", mock.Anything, mock.Anything).Return(mockDB)
@@ -125,10 +133,126 @@ func FuzzAuthService_Login(f *testing.F) {
 			http.StatusUnauthorized,
 		}
 		assert.Contains(t, validCodes, w.Code, "unexpected status code")
+		if !valid {
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+			mockDB.AssertNotCalled(t, "Where", mock.Anything, mock.Anything)
+			return
+		}
 		mockDB.AssertExpectations(t)
 	})
 }
 
+func TestAuthService_LoginNormalizesEmail(t *testing.T) {
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+
+	tests := []struct {
+		name  string
+		email string
+	}{
+		{"as stored", "alice@gomail.kurs"},
+		{"upper case", "Alice@GoMail.KURS"},
+		{"surrounding spaces", "  alice@gomail.kurs \t"},
+		{"display name", "Alice <ALICE@gomail.kurs>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(MockMailDB)
+			service := NewAuthService(mockDB, utils.NewTokenIssuer([]byte("secret"), utils.AccessTokenTTL))
+
+			mockDB.On("Where", "email = ?", "alice@gomail.kurs").Return(mockDB).Once()
+			mockDB.On("First", mock.AnythingOfType("*model.User")).Return(mockDB).Run(func(args mock.Arguments) {
+				*args.Get(0).(*model.User) = model.User{Id: 3, Email: "alice@gomail.kurs", Password: string(hashedPassword)}
+			})
+			var token *model.RefreshToken
+			mockDB.On("Create", mock.AnythingOfType("*model.RefreshToken")).Return(mockDB).Run(func(args mock.Arguments) {
+				token = args.Get(0).(*model.RefreshToken)
+			})
+			mockDB.On("Error").Return(nil)
+
+			jsonData, _ := json.Marshal(map[string]string{"email": tt.email, "password": "secret"})
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(jsonData))
+			c.Request.Header.Set("Content-Type", "application/json")
+
+			service.Login(c)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			mockDB.AssertExpectations(t)
+			if assert.NotNil(t, token) {
+				assert.Equal(t, uint(3), token.UserId)
+			}
+		})
+	}
+}
+
+func TestAuthService_Refresh(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+		// tokenErr and userErr are the results of looking up the token
+		// and its user.
+		tokenErr error
+		userErr  error
+		code     int
+	}{
+		{"valid", "valid-refresh-token", nil, nil, http.StatusOK},
+		{"revoked or unknown", "revoked-refresh-token", gorm.ErrRecordNotFound, nil, http.StatusUnauthorized},
+		{"user deleted", "valid-refresh-token", nil, gorm.ErrRecordNotFound, http.StatusUnauthorized},
+		{"missing token", "", nil, nil, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(MockMailDB)
+			service := NewAuthService(mockDB, utils.NewTokenIssuer([]byte("secret"), utils.AccessTokenTTL))
+
+			var created *model.RefreshToken
+			mockDB.On("Where", "token_hash = ? AND revoked_at IS NULL AND expires_at > ?",
+				utils.HashRefreshToken(tt.token), mock.AnythingOfType("time.Time")).Return(mockDB)
+			mockDB.On("First", mock.AnythingOfType("*model.RefreshToken")).Return(mockDB).Run(func(args mock.Arguments) {
+				*args.Get(0).(*model.RefreshToken) = model.RefreshToken{Id: 1, UserId: 7}
+			})
+			mockDB.On("Error").Return(tt.tokenErr).Once()
+			mockDB.On("Where", "id = ?", uint(7)).Return(mockDB)
+			mockDB.On("First", mock.AnythingOfType("*model.User")).Return(mockDB)
+			mockDB.On("Error").Return(tt.userErr).Once()
+			mockDB.On("Model", &model.RefreshToken{}).Return(mockDB)
+			mockDB.On("Where", "id = ? AND revoked_at IS NULL", uint(1)).Return(mockDB)
+			mockDB.On("Update", "revoked_at", mock.AnythingOfType("time.Time")).Return(mockDB)
+			mockDB.On("RowsAffected").Return(int64(1))
+			mockDB.On("Create", mock.AnythingOfType("*model.RefreshToken")).Return(mockDB).Run(func(args mock.Arguments) {
+				created = args.Get(0).(*model.RefreshToken)
+			})
+			mockDB.On("Error").Return(nil)
+
+			jsonData, _ := json.Marshal(map[string]string{"refresh_token": tt.token})
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/auth/refresh", bytes.NewBuffer(jsonData))
+			c.Request.Header.Set("Content-Type", "application/json")
+
+			service.Refresh(c)
+
+			assert.Equal(t, tt.code, w.Code)
+			if tt.code != http.StatusOK {
+				assert.Nil(t, created)
+				return
+			}
+			var session map[string]interface{}
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &session))
+			assert.NotEmpty(t, session["access_token"])
+			assert.NotEmpty(t, session["refresh_token"])
+			if assert.NotNil(t, created) {
+				assert.Equal(t, uint(7), created.UserId)
+				assert.Equal(t, utils.HashRefreshToken(session["refresh_token"].(string)), created.TokenHash)
+			}
+		})
+	}
+}
+
 func generateRandomString(length int) string {
 	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 	b := make([]byte, length)
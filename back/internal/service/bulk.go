@@ -0,0 +1,263 @@
+package service
+
+import (
+	"backend/internal/model"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const maxBulkMails = 500
+
+const (
+	bulkArchive   = "archive"
+	bulkUnarchive = "unarchive"
+	bulkTrash     = "trash"
+	bulkRestore   = "restore"
+	bulkRead      = "read"
+	bulkUnread    = "unread"
+	bulkMove      = "move"
+	bulkLabel     = "label"
+	bulkUnlabel   = "unlabel"
+
+	bulkDone     = "ok"
+	bulkConflict = "conflict"
+	bulkNotFound = "not_found"
+)
+
+// mailboxAction changes the copies userID holds of one mail and reports
+// how many of them it changed. None changed means the user has no copy
+// the action applies to. Each action is a single conditional statement,
+// or locks the mailboxes it reads, so concurrent requests on the same
+// mail cannot undo each other's changes.
+type mailboxAction func(tx model.MailDB, userID, mailID uint) (int64, error)
+
+// bulkInput applies one action to many mails. FolderId is the target of
+// move, 0 meaning the inbox or sent folder; LabelId is the label put on or
+// taken off.
+type bulkInput struct {
+	Ids      []uint `json:"ids" binding:"required"`
+	Action   string `json:"action" binding:"required"`
+	FolderId uint   `json:"folder_id"`
+	LabelId  uint   `json:"label_id"`
+}
+
+type bulkResult struct {
+	Id     uint   `json:"id"`
+	Status string `json:"status"`
+}
+
+// BulkMails applies an action to a list of mails in one transaction. A
+// mail the action does not apply to is reported as a conflict, and one
+// the user does not hold as not found, leaving the others alone; a
+// database error rolls back every change.
+func (ms *mailService) BulkMails(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	var input bulkInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid input"})
+		return
+	}
+	if len(input.Ids) > maxBulkMails {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Too many mails"})
+		return
+	}
+
+	action, status, err := ms.bulkAction(userID, input)
+	if err != nil {
+		c.JSON(status, gin.H{"message": err.Error()})
+		return
+	}
+
+	results := make([]bulkResult, 0, len(input.Ids))
+	updated := 0
+	err = ms.db.Transaction(func(tx model.MailDB) error {
+		seen := make(map[uint]bool, len(input.Ids))
+		for _, id := range input.Ids {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+
+			n, err := action(tx, userID, id)
+			if err != nil {
+				return err
+			}
+			result := bulkResult{Id: id, Status: bulkDone}
+			if n == 0 {
+				held, err := holdsMail(tx, userID, id)
+				if err != nil {
+					return err
+				}
+				result.Status = bulkNotFound
+				if held {
+					result.Status = bulkConflict
+				}
+			} else {
+				updated++
+			}
+			results = append(results, result)
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error updating mails"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"results": results, "updated": updated})
+}
+
+// bulkAction resolves the action of input, checking that its folder or
+// label belongs to userID.
+func (ms *mailService) bulkAction(userID uint, input bulkInput) (mailboxAction, int, error) {
+	switch input.Action {
+	case bulkArchive:
+		return archiveMailbox, http.StatusOK, nil
+	case bulkUnarchive:
+		return unarchiveMailbox, http.StatusOK, nil
+	case bulkTrash:
+		return trashMailbox, http.StatusOK, nil
+	case bulkRestore:
+		return restoreMailbox, http.StatusOK, nil
+	case bulkRead, bulkUnread:
+		return seenMailbox(input.Action == bulkRead), http.StatusOK, nil
+	case bulkMove:
+		if input.FolderId != 0 {
+			var folder model.Folder
+			if err := ms.db.Where("id = ? AND user_id = ?", input.FolderId, userID).First(&folder).Error(); err != nil {
+				return nil, http.StatusNotFound, errFolderNotFound
+			}
+		}
+		return moveMailbox(input.FolderId), http.StatusOK, nil
+	case bulkLabel, bulkUnlabel:
+		var label model.Label
+		if err := ms.db.Where("id = ? AND user_id = ?", input.LabelId, userID).First(&label).Error(); err != nil {
+			return nil, http.StatusNotFound, errors.New("Label not found")
+		}
+		return labelMailbox(label.Id, input.Action == bulkLabel), http.StatusOK, nil
+	default:
+		return nil, http.StatusBadRequest, errors.New("Unknown action")
+	}
+}
+
+func archiveMailbox(tx model.MailDB, userID, mailID uint) (int64, error) {
+	return rowsChanged(tx.Model(&model.Mailbox{}).
+		Where("user_id = ? AND mail_id = ? AND folder IN ?", userID, mailID, []string{model.FolderInbox, model.FolderSent, model.FolderUser}).
+		Updates(map[string]interface{}{"folder": model.FolderArchive, "folder_id": nil}))
+}
+
+func unarchiveMailbox(tx model.MailDB, userID, mailID uint) (int64, error) {
+	return rowsChanged(tx.Model(&model.Mailbox{}).
+		Where("user_id = ? AND mail_id = ? AND folder = ?", userID, mailID, model.FolderArchive).
+		Updates(homeFolder()))
+}
+
+// trashMailbox moves a mail to the trash, or deletes it for good when it
+// already is there. A scheduled mail is cancelled instead, so it is left
+// alone.
+func trashMailbox(tx model.MailDB, userID, mailID uint) (int64, error) {
+	return rowsChanged(tx.Model(&model.Mailbox{}).
+		Where("user_id = ? AND mail_id = ? AND folder NOT IN ?", userID, mailID, []string{model.FolderDeleted, model.FolderScheduled}).
+		Updates(map[string]interface{}{
+			"folder":     gorm.Expr("CASE WHEN folder = ? THEN ? ELSE ? END", model.FolderTrash, model.FolderDeleted, model.FolderTrash),
+			"folder_id":  nil,
+			"trashed_at": gorm.Expr("CASE WHEN folder = ? THEN trashed_at ELSE ? END", model.FolderTrash, time.Now()),
+		}))
+}
+
+func restoreMailbox(tx model.MailDB, userID, mailID uint) (int64, error) {
+	updates := homeFolder()
+	updates["trashed_at"] = nil
+	return rowsChanged(tx.Model(&model.Mailbox{}).
+		Where("user_id = ? AND mail_id = ? AND folder = ?", userID, mailID, model.FolderTrash).
+		Updates(updates))
+}
+
+func seenMailbox(seen bool) mailboxAction {
+	return func(tx model.MailDB, userID, mailID uint) (int64, error) {
+		return rowsChanged(tx.Model(&model.Mailbox{}).
+			Where("user_id = ? AND mail_id = ? AND folder <> ?", userID, mailID, model.FolderDeleted).
+			Update("seen", seen))
+	}
+}
+
+// moveMailbox files a mail in the folder folderID, which must belong to
+// the user, or returns it home when folderID is 0.
+func moveMailbox(folderID uint) mailboxAction {
+	updates := homeFolder()
+	if folderID != 0 {
+		updates = map[string]interface{}{"folder": model.FolderUser, "folder_id": folderID}
+	}
+	return func(tx model.MailDB, userID, mailID uint) (int64, error) {
+		return rowsChanged(tx.Model(&model.Mailbox{}).
+			Where("user_id = ? AND mail_id = ? AND folder IN ?", userID, mailID, visibleFolders).
+			Updates(updates))
+	}
+}
+
+// labelMailbox puts the label labelID, which must belong to the user, on
+// a mail or takes it off. It must run in a transaction.
+func labelMailbox(labelID uint, add bool) mailboxAction {
+	return func(tx model.MailDB, userID, mailID uint) (int64, error) {
+		boxes, err := lockVisibleMailboxes(tx, userID, mailID)
+		if err != nil {
+			return 0, err
+		}
+		if len(boxes) == 0 {
+			return 0, nil
+		}
+
+		links := make([]model.MailboxLabel, 0, len(boxes))
+		boxIDs := make([]uint, 0, len(boxes))
+		for _, box := range boxes {
+			boxIDs = append(boxIDs, box.ID)
+			links = append(links, model.MailboxLabel{MailboxId: box.ID, LabelId: labelID})
+		}
+
+		if err := tx.Where("mailbox_id IN ? AND label_id = ?", boxIDs, labelID).
+			Delete(&model.MailboxLabel{}).Error(); err != nil {
+			return 0, err
+		}
+		if add {
+			if err := tx.Create(&links).Error(); err != nil {
+				return 0, err
+			}
+		}
+		return int64(len(boxes)), nil
+	}
+}
+
+// lockVisibleMailboxes returns the copies of mailID userID can see,
+// locked until tx ends so that label changes on them do not interleave.
+func lockVisibleMailboxes(tx model.MailDB, userID, mailID uint) ([]model.Mailbox, error) {
+	var boxes []model.Mailbox
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Select("id").
+		Where("user_id = ? AND mail_id = ? AND folder IN ?", userID, mailID, visibleFolders).
+		Find(&boxes).Error()
+	return boxes, err
+}
+
+// holdsMail reports whether userID holds a copy of mailID that is not
+// deleted for good.
+func holdsMail(db model.MailDB, userID, mailID uint) (bool, error) {
+	var held bool
+	err := db.Model(&model.Mailbox{}).
+		Select("count(*) > 0").
+		Where("user_id = ? AND mail_id = ? AND folder <> ?", userID, mailID, model.FolderDeleted).
+		Find(&held).Error()
+	return held, err
+}
+
+func rowsChanged(res model.MailDB) (int64, error) {
+	if err := res.Error(); err != nil {
+		return 0, err
+	}
+	return res.RowsAffected(), nil
+}
@@ -0,0 +1,276 @@
+package service
+
+import (
+	"backend/internal/model"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// bulkReply is the body BulkMails answers with.
+type bulkReply struct {
+	Results []bulkResult `json:"results"`
+	Updated int          `json:"updated"`
+}
+
+func postBulk(service MailService, input bulkInput) *httptest.ResponseRecorder {
+	jsonData, _ := json.Marshal(input)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Set("userID", uint(1))
+	c.Request = httptest.NewRequest(http.MethodPost, "/mail/bulk", bytes.NewBuffer(jsonData))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	service.BulkMails(c)
+	return w
+}
+
+func TestMailService_BulkMails(t *testing.T) {
+	home := homeFolder()
+	restored := homeFolder()
+	restored["trashed_at"] = nil
+
+	tests := []struct {
+		name     string
+		action   string
+		folderID uint
+		// query and folders are the condition picking the copies to change.
+		query   string
+		folders interface{}
+		// written is the change made to each mail: the updates, or the
+		// seen flag.
+		written interface{}
+	}{
+		{
+			name:    "archive",
+			action:  bulkArchive,
+			query:   "user_id = ? AND mail_id = ? AND folder IN ?",
+			folders: []string{model.FolderInbox, model.FolderSent, model.FolderUser},
+			written: map[string]interface{}{"folder": model.FolderArchive, "folder_id": nil},
+		},
+		{
+			name:    "unarchive",
+			action:  bulkUnarchive,
+			query:   "user_id = ? AND mail_id = ? AND folder = ?",
+			folders: model.FolderArchive,
+			written: home,
+		},
+		{
+			name:    "restore",
+			action:  bulkRestore,
+			query:   "user_id = ? AND mail_id = ? AND folder = ?",
+			folders: model.FolderTrash,
+			written: restored,
+		},
+		{
+			name:    "move home",
+			action:  bulkMove,
+			query:   "user_id = ? AND mail_id = ? AND folder IN ?",
+			folders: visibleFolders,
+			written: home,
+		},
+		{
+			name:     "move to a folder",
+			action:   bulkMove,
+			folderID: 3,
+			query:    "user_id = ? AND mail_id = ? AND folder IN ?",
+			folders:  visibleFolders,
+			written:  map[string]interface{}{"folder": model.FolderUser, "folder_id": uint(3)},
+		},
+		{
+			name:    "read",
+			action:  bulkRead,
+			query:   "user_id = ? AND mail_id = ? AND folder <> ?",
+			folders: model.FolderDeleted,
+			written: true,
+		},
+		{
+			name:    "unread",
+			action:  bulkUnread,
+			query:   "user_id = ? AND mail_id = ? AND folder <> ?",
+			folders: model.FolderDeleted,
+			written: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(MockMailDB)
+			service := NewMailService(mockDB, nil)
+
+			// Mail 4 is changed, mail 5 is held but the action does not
+			// apply to it, and mail 6 is not held at all.
+			var written []interface{}
+			mockDB.On("Where", "id = ? AND user_id = ?", uint(3), uint(1)).Return(mockDB)
+			mockDB.On("First", mock.AnythingOfType("*model.Folder")).Return(mockDB)
+			mockDB.On("Model", &model.Mailbox{}).Return(mockDB)
+			for _, id := range []uint{4, 5, 6} {
+				mockDB.On("Where", tt.query, uint(1), id, tt.folders).Return(mockDB)
+			}
+			mockDB.On("Updates", mock.AnythingOfType("map[string]interface {}")).Return(mockDB).Run(func(args mock.Arguments) {
+				written = append(written, args.Get(0))
+			})
+			mockDB.On("Update", "seen", mock.AnythingOfType("bool")).Return(mockDB).Run(func(args mock.Arguments) {
+				written = append(written, args.Get(1))
+			})
+			mockDB.On("RowsAffected").Return(int64(1)).Once()
+			mockDB.On("RowsAffected").Return(int64(0))
+			mockDB.On("Select", "count(*) > 0").Return(mockDB)
+			mockDB.On("Where", "user_id = ? AND mail_id = ? AND folder <> ?", uint(1), uint(5), model.FolderDeleted).Return(mockDB)
+			mockDB.On("Where", "user_id = ? AND mail_id = ? AND folder <> ?", uint(1), uint(6), model.FolderDeleted).Return(mockDB)
+			mockDB.On("Find", mock.AnythingOfType("*bool")).Return(mockDB).Run(func(args mock.Arguments) {
+				*args.Get(0).(*bool) = true
+			}).Once()
+			mockDB.On("Find", mock.AnythingOfType("*bool")).Return(mockDB)
+			mockDB.On("Error").Return(nil)
+
+			w := postBulk(service, bulkInput{Ids: []uint{4, 5, 4, 6}, Action: tt.action, FolderId: tt.folderID})
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			var reply bulkReply
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &reply))
+			assert.Equal(t, []bulkResult{
+				{Id: 4, Status: bulkDone},
+				{Id: 5, Status: bulkConflict},
+				{Id: 6, Status: bulkNotFound},
+			}, reply.Results)
+			assert.Equal(t, 1, reply.Updated)
+			assert.Equal(t, []interface{}{tt.written, tt.written, tt.written}, written)
+		})
+	}
+}
+
+func TestMailService_BulkMails_Rejected(t *testing.T) {
+	tests := []struct {
+		name  string
+		input bulkInput
+		err   error
+		code  int
+	}{
+		{"unknown action", bulkInput{Ids: []uint{4}, Action: "explode"}, nil, http.StatusBadRequest},
+		{"too many mails", bulkInput{Ids: make([]uint, maxBulkMails+1), Action: bulkRead}, nil, http.StatusBadRequest},
+		{"folder of another user", bulkInput{Ids: []uint{4}, Action: bulkMove, FolderId: 3}, gorm.ErrRecordNotFound, http.StatusNotFound},
+		{"database error", bulkInput{Ids: []uint{4}, Action: bulkArchive}, assert.AnError, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(MockMailDB)
+			service := NewMailService(mockDB, nil)
+
+			mockDB.On("Where", "id = ? AND user_id = ?", uint(3), uint(1)).Return(mockDB)
+			mockDB.On("First", mock.AnythingOfType("*model.Folder")).Return(mockDB)
+			mockDB.On("Model", &model.Mailbox{}).Return(mockDB)
+			mockDB.On("Where", "user_id = ? AND mail_id = ? AND folder IN ?", uint(1), uint(4), []string{model.FolderInbox, model.FolderSent, model.FolderUser}).Return(mockDB)
+			mockDB.On("Updates", mock.AnythingOfType("map[string]interface {}")).Return(mockDB)
+			mockDB.On("Error").Return(tt.err)
+
+			w := postBulk(service, tt.input)
+
+			assert.Equal(t, tt.code, w.Code)
+			if tt.err == nil {
+				mockDB.AssertNotCalled(t, "Model", mock.Anything)
+			}
+		})
+	}
+}
+
+func TestMailService_BulkMails_Label(t *testing.T) {
+	tests := []struct {
+		name   string
+		action string
+		boxIDs []uint
+		status string
+		// created is the links put on, nil when none are.
+		created []model.MailboxLabel
+	}{
+		{
+			name:    "label both copies",
+			action:  bulkLabel,
+			boxIDs:  []uint{1, 2},
+			status:  bulkDone,
+			created: []model.MailboxLabel{{MailboxId: 1, LabelId: 9}, {MailboxId: 2, LabelId: 9}},
+		},
+		{
+			name:   "unlabel",
+			action: bulkUnlabel,
+			boxIDs: []uint{1},
+			status: bulkDone,
+		},
+		{
+			name:   "no visible copy",
+			action: bulkLabel,
+			status: bulkNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(MockMailDB)
+			service := NewMailService(mockDB, nil)
+
+			boxes := make([]model.Mailbox, len(tt.boxIDs))
+			for i, id := range tt.boxIDs {
+				boxes[i].ID = id
+			}
+
+			var created []model.MailboxLabel
+			mockDB.On("Where", "id = ? AND user_id = ?", uint(9), uint(1)).Return(mockDB)
+			mockDB.On("First", mock.AnythingOfType("*model.Label")).Return(mockDB).Run(func(args mock.Arguments) {
+				args.Get(0).(*model.Label).Id = 9
+			})
+			mockDB.On("Clauses", clause.Locking{Strength: "UPDATE"}).Return(mockDB)
+			mockDB.On("Select", "id").Return(mockDB)
+			mockDB.On("Where", "user_id = ? AND mail_id = ? AND folder IN ?", uint(1), uint(4), visibleFolders).Return(mockDB)
+			mockDB.On("Find", mock.AnythingOfType("*[]model.Mailbox")).Return(mockDB).Run(func(args mock.Arguments) {
+				*args.Get(0).(*[]model.Mailbox) = boxes
+			})
+			mockDB.On("Where", "mailbox_id IN ? AND label_id = ?", tt.boxIDs, uint(9)).Return(mockDB)
+			mockDB.On("Delete", &model.MailboxLabel{}).Return(mockDB)
+			mockDB.On("Create", mock.AnythingOfType("*[]model.MailboxLabel")).Return(mockDB).Run(func(args mock.Arguments) {
+				created = *args.Get(0).(*[]model.MailboxLabel)
+			})
+			mockDB.On("Model", &model.Mailbox{}).Return(mockDB)
+			mockDB.On("Select", "count(*) > 0").Return(mockDB)
+			mockDB.On("Where", "user_id = ? AND mail_id = ? AND folder <> ?", uint(1), uint(4), model.FolderDeleted).Return(mockDB)
+			mockDB.On("Find", mock.AnythingOfType("*bool")).Return(mockDB)
+			mockDB.On("Error").Return(nil)
+
+			w := postBulk(service, bulkInput{Ids: []uint{4}, Action: tt.action, LabelId: 9})
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			var reply bulkReply
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &reply))
+			assert.Equal(t, []bulkResult{{Id: 4, Status: tt.status}}, reply.Results)
+			assert.Equal(t, tt.created, created)
+			if len(tt.boxIDs) == 0 {
+				mockDB.AssertNotCalled(t, "Delete", mock.Anything)
+			} else {
+				mockDB.AssertCalled(t, "Delete", &model.MailboxLabel{})
+			}
+		})
+	}
+}
+
+func TestMailService_BulkMails_ForeignLabel(t *testing.T) {
+	mockDB := new(MockMailDB)
+	service := NewMailService(mockDB, nil)
+
+	mockDB.On("Where", "id = ? AND user_id = ?", uint(9), uint(1)).Return(mockDB)
+	mockDB.On("First", mock.AnythingOfType("*model.Label")).Return(mockDB)
+	mockDB.On("Error").Return(gorm.ErrRecordNotFound)
+
+	w := postBulk(service, bulkInput{Ids: []uint{4}, Action: bulkLabel, LabelId: 9})
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	mockDB.AssertNotCalled(t, "Clauses", mock.Anything)
+}
@@ -0,0 +1,259 @@
+//go:build integration
+
+package service
+
+import (
+	"backend/internal/model"
+	"backend/internal/testdb"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// inboxMails stores count mails sent to userID, each in its inbox, and
+// returns their ids.
+func inboxMails(t *testing.T, db *gorm.DB, userID uint, count int) []uint {
+	t.Helper()
+	ids := make([]uint, 0, count)
+	for i := 0; i < count; i++ {
+		mail := model.Mail{Sender: "sender@example.com", Subject: "Hello"}
+		require.NoError(t, db.Create(&mail).Error)
+		require.NoError(t, db.Create(&model.Mailbox{
+			MailId: mail.ID,
+			UserId: userID,
+			Role:   model.MailboxRecipient,
+			Folder: model.FolderInbox,
+		}).Error)
+		ids = append(ids, mail.ID)
+	}
+	return ids
+}
+
+func mailboxFolder(t *testing.T, db *gorm.DB, userID, mailID uint) string {
+	t.Helper()
+	var box model.Mailbox
+	require.NoError(t, db.Where("user_id = ? AND mail_id = ?", userID, mailID).First(&box).Error)
+	return box.Folder
+}
+
+// runConcurrently calls each of handlers for mailID in its own goroutine,
+// all started together, and returns the status codes in the same order.
+func runConcurrently(userID, mailID uint, handlers ...gin.HandlerFunc) []int {
+	codes := make([]int, len(handlers))
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i, handler := range handlers {
+		wg.Add(1)
+		go func(i int, handler gin.HandlerFunc) {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Set("userID", userID)
+			c.Params = gin.Params{gin.Param{Key: "id", Value: strconv.Itoa(int(mailID))}}
+			<-start
+			handler(c)
+			codes[i] = w.Code
+		}(i, handler)
+	}
+	close(start)
+	wg.Wait()
+	return codes
+}
+
+func TestMailService_ConcurrentArchive(t *testing.T) {
+	tests := []struct {
+		name                 string
+		archives, unarchives int
+	}{
+		{"archives only", 8, 0},
+		{"balanced", 8, 8},
+		{"mostly archives", 20, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := testdb.Open(t)
+			service := NewMailService(model.NewMailDB(db), nil)
+			mailID := inboxMails(t, db, 1, 1)[0]
+
+			var handlers []gin.HandlerFunc
+			for i := 0; i < tt.archives; i++ {
+				handlers = append(handlers, service.ArchiveMail)
+			}
+			for i := 0; i < tt.unarchives; i++ {
+				handlers = append(handlers, service.UnArchiveMail)
+			}
+			codes := runConcurrently(1, mailID, handlers...)
+
+			// Every success is one real transition, so archives and
+			// unarchives that succeeded alternate from the inbox.
+			archived, unarchived := 0, 0
+			for i, code := range codes {
+				require.Contains(t, []int{http.StatusOK, http.StatusConflict}, code)
+				if code != http.StatusOK {
+					continue
+				}
+				if i < tt.archives {
+					archived++
+				} else {
+					unarchived++
+				}
+			}
+			switch folder := mailboxFolder(t, db, 1, mailID); folder {
+			case model.FolderArchive:
+				assert.Equal(t, unarchived+1, archived)
+			case model.FolderInbox:
+				assert.Equal(t, unarchived, archived)
+			default:
+				t.Fatalf("mail ended up in %q", folder)
+			}
+		})
+	}
+}
+
+func TestMailService_ConcurrentDelete(t *testing.T) {
+	tests := []struct {
+		name   string
+		folder string
+		// ok is the number of deletes that succeed, other the status every
+		// other one gets, and result the folder the mail ends up in.
+		ok     int
+		other  int
+		result string
+	}{
+		// The first delete moves the mail to the trash and the second
+		// deletes it for good; every other one finds nothing left.
+		{"inbox mail", model.FolderInbox, 2, http.StatusNotFound, model.FolderDeleted},
+		// A scheduled mail is still held but cannot be deleted.
+		{"scheduled mail", model.FolderScheduled, 0, http.StatusConflict, model.FolderScheduled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := testdb.Open(t)
+			service := NewMailService(model.NewMailDB(db), nil)
+			mailID := inboxMails(t, db, 1, 1)[0]
+			require.NoError(t, db.Model(&model.Mailbox{}).Where("mail_id = ?", mailID).Update("folder", tt.folder).Error)
+
+			handlers := make([]gin.HandlerFunc, 16)
+			for i := range handlers {
+				handlers[i] = service.DeleteMail
+			}
+			codes := runConcurrently(1, mailID, handlers...)
+
+			ok := 0
+			for _, code := range codes {
+				require.Contains(t, []int{http.StatusOK, tt.other}, code)
+				if code == http.StatusOK {
+					ok++
+				}
+			}
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.result, mailboxFolder(t, db, 1, mailID))
+		})
+	}
+}
+
+func TestMailService_ConcurrentBulk(t *testing.T) {
+	db := testdb.Open(t)
+	service := NewMailService(model.NewMailDB(db), nil)
+	ids := inboxMails(t, db, 1, 10)
+
+	body, _ := json.Marshal(bulkInput{Ids: ids, Action: bulkArchive})
+
+	var mu sync.Mutex
+	archived := make(map[uint]int)
+	var results []bulkResult
+	handlers := []gin.HandlerFunc{func(c *gin.Context) {
+		w := httptest.NewRecorder()
+		bc, _ := gin.CreateTestContext(w)
+		bc.Set("userID", uint(1))
+		bc.Request = httptest.NewRequest(http.MethodPost, "/mail/bulk", bytes.NewBuffer(body))
+		bc.Request.Header.Set("Content-Type", "application/json")
+		service.BulkMails(bc)
+
+		var reply struct {
+			Results []bulkResult `json:"results"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &reply))
+		results = reply.Results
+		c.AbortWithStatus(w.Code)
+	}}
+	for _, id := range ids {
+		id := id
+		handlers = append(handlers, func(c *gin.Context) {
+			c.Params = gin.Params{gin.Param{Key: "id", Value: strconv.Itoa(int(id))}}
+			service.ArchiveMail(c)
+			if c.Writer.Status() == http.StatusOK {
+				mu.Lock()
+				archived[id]++
+				mu.Unlock()
+			}
+		})
+	}
+	codes := runConcurrently(1, 0, handlers...)
+	require.Equal(t, http.StatusOK, codes[0])
+
+	for _, result := range results {
+		require.Contains(t, []string{bulkDone, bulkConflict}, result.Status)
+		if result.Status == bulkDone {
+			archived[result.Id]++
+		}
+	}
+	// Each mail is archived exactly once, by the bulk request or by the
+	// single request racing it.
+	for _, id := range ids {
+		assert.Equal(t, 1, archived[id], "mail %d", id)
+		assert.Equal(t, model.FolderArchive, mailboxFolder(t, db, 1, id))
+	}
+}
+
+func TestMailService_ConcurrentLabel(t *testing.T) {
+	db := testdb.Open(t)
+	service := NewMailService(model.NewMailDB(db), nil)
+	mailID := inboxMails(t, db, 1, 1)[0]
+	label := model.Label{UserId: 1, Name: "Work", Color: "#ff0000"}
+	require.NoError(t, db.Create(&label).Error)
+
+	body, _ := json.Marshal(labelMailInput{Add: []uint{label.Id}})
+	handlers := make([]gin.HandlerFunc, 8)
+	for i := range handlers {
+		handlers[i] = func(c *gin.Context) {
+			c.Request = httptest.NewRequest(http.MethodPut, "/mail/id/labels", bytes.NewBuffer(body))
+			c.Request.Header.Set("Content-Type", "application/json")
+			service.LabelMail(c)
+		}
+	}
+	codes := runConcurrently(1, mailID, handlers...)
+
+	// The mailbox is locked while its labels change, so the requests
+	// replace each other's link instead of inserting it twice.
+	for _, code := range codes {
+		assert.Equal(t, http.StatusOK, code)
+	}
+	var links []model.MailboxLabel
+	require.NoError(t, db.Where("label_id = ?", label.Id).Find(&links).Error)
+	assert.Len(t, links, 1)
+}
+
+func TestMailService_ConcurrentOthersMail(t *testing.T) {
+	db := testdb.Open(t)
+	service := NewMailService(model.NewMailDB(db), nil)
+	mailID := inboxMails(t, db, 1, 1)[0]
+
+	codes := runConcurrently(2, mailID, service.ArchiveMail, service.UnArchiveMail, service.DeleteMail, service.RestoreMail)
+
+	for _, code := range codes {
+		assert.Equal(t, http.StatusNotFound, code)
+	}
+	assert.Equal(t, model.FolderInbox, mailboxFolder(t, db, 1, mailID))
+}
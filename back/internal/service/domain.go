@@ -0,0 +1,172 @@
+package service
+
+import (
+	"backend/internal/model"
+	"backend/utils"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var domainNamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?(\.[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?)+$`)
+
+// domainSettings are the fields of a domain an admin may change. Absent
+// fields keep their current value.
+type domainSettings struct {
+	RegistrationOpen *bool   `json:"registration_open"`
+	DefaultQuota     *int64  `json:"default_quota"`
+	CatchAll         *string `json:"catch_all"`
+}
+
+func (as *adminService) GetDomains(c *gin.Context) {
+	var domains []model.Domain
+	if err := as.db.Order("name").Find(&domains).Error(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error fetching domains"})
+		return
+	}
+	c.JSON(http.StatusOK, domains)
+}
+
+func (as *adminService) CreateDomain(c *gin.Context) {
+	var input struct {
+		Name string `json:"name" binding:"required"`
+		domainSettings
+	}
+
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid input"})
+		return
+	}
+
+	domain := model.Domain{
+		Name:             strings.ToLower(strings.TrimSpace(input.Name)),
+		RegistrationOpen: true,
+	}
+	if !domainNamePattern.MatchString(domain.Name) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid domain name"})
+		return
+	}
+	if status, err := as.applyDomainSettings(&domain, input.domainSettings); err != nil {
+		c.JSON(status, gin.H{"message": err.Error()})
+		return
+	}
+
+	var exists bool
+	if err := as.db.Model(&model.Domain{}).Select("count(*) > 0").Where("name = ?", domain.Name).Find(&exists).Error(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error checking domain"})
+		return
+	}
+	if exists {
+		c.JSON(http.StatusConflict, gin.H{"message": "Domain already exists"})
+		return
+	}
+
+	if err := as.db.Create(&domain).Error(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error creating domain"})
+		return
+	}
+	c.JSON(http.StatusCreated, domain)
+}
+
+func (as *adminService) UpdateDomain(c *gin.Context) {
+	var input domainSettings
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid input"})
+		return
+	}
+
+	var domain model.Domain
+	if err := as.db.Where("id = ?", c.Param("id")).First(&domain).Error(); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Domain not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Error fetching domain"})
+		}
+		return
+	}
+
+	if status, err := as.applyDomainSettings(&domain, input); err != nil {
+		c.JSON(status, gin.H{"message": err.Error()})
+		return
+	}
+
+	if err := as.db.Model(&model.Domain{}).Where("id = ?", domain.Id).Updates(map[string]interface{}{
+		"registration_open": domain.RegistrationOpen,
+		"default_quota":     domain.DefaultQuota,
+		"catch_all":         domain.CatchAll,
+	}).Error(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error updating domain"})
+		return
+	}
+	c.JSON(http.StatusOK, domain)
+}
+
+func (as *adminService) DeleteDomain(c *gin.Context) {
+	var domain model.Domain
+	if err := as.db.Where("id = ?", c.Param("id")).First(&domain).Error(); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Domain not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "Error fetching domain"})
+		}
+		return
+	}
+
+	var hasUsers bool
+	if err := as.db.Model(&model.User{}).Select("count(*) > 0").Where("email LIKE ?", "%@"+domain.Name).Find(&hasUsers).Error(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error checking domain users"})
+		return
+	}
+	if hasUsers {
+		c.JSON(http.StatusConflict, gin.H{"message": "Domain still has users"})
+		return
+	}
+
+	if err := as.db.Where("id = ?", domain.Id).Delete(&model.Domain{}).Error(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error deleting domain"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// applyDomainSettings validates settings and copies them onto domain. A
+// catch-all target has to be an existing local user.
+func (as *adminService) applyDomainSettings(domain *model.Domain, settings domainSettings) (int, error) {
+	if settings.RegistrationOpen != nil {
+		domain.RegistrationOpen = *settings.RegistrationOpen
+	}
+
+	if settings.DefaultQuota != nil {
+		if *settings.DefaultQuota < 0 {
+			return http.StatusBadRequest, errors.New("Default quota can't be negative")
+		}
+		domain.DefaultQuota = *settings.DefaultQuota
+	}
+
+	if settings.CatchAll != nil {
+		domain.CatchAll = ""
+		if strings.TrimSpace(*settings.CatchAll) == "" {
+			return http.StatusOK, nil
+		}
+
+		target, ok := utils.ParseAddress(*settings.CatchAll)
+		if !ok {
+			return http.StatusBadRequest, errors.New("Invalid catch-all address")
+		}
+
+		var exists bool
+		if err := as.db.Model(&model.User{}).Select("count(*) > 0").Where("email = ?", target).Find(&exists).Error(); err != nil {
+			return http.StatusInternalServerError, errors.New("Error checking catch-all address")
+		}
+		if !exists {
+			return http.StatusBadRequest, errors.New("Catch-all address is not a local user")
+		}
+		domain.CatchAll = target
+	}
+
+	return http.StatusOK, nil
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"backend/internal/model"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func FuzzAdminService_CreateDomain(f *testing.F) {
+	f.Add("example.org", int64(0), "", false)
+	f.Add("Mail.Example.ORG", int64(1<<30), "postmaster@example.org", true)
+	f.Add("localhost", int64(0), "", false)
+	f.Add("bad_domain.org", int64(-1), "not an address", false)
+	f.Add("", int64(0), "", true)
+
+	f.Fuzz(func(t *testing.T, name string, quota int64, catchAll string, exists bool) {
+		mockDB := new(MockMailDB)
+		service := NewAdminService(mockDB)
+
+		mockDB.On("Model", mock.Anything).Return(mockDB)
+		mockDB.On("Select", "count(*) > 0").Return(mockDB)
+		mockDB.On("Where", mock.Anything, mock.Anything).Return(mockDB)
+		mockDB.On("Find", mock.AnythingOfType("*bool")).Return(mockDB).Run(func(args mock.Arguments) {
+			*args.Get(0).(*bool) = exists
+		})
+		mockDB.On("Create", mock.AnythingOfType("*model.Domain")).Return(mockDB)
+		mockDB.On("Error").Return(nil)
+
+		jsonData, _ := json.Marshal(map[string]interface{}{
+			"name":          name,
+			"default_quota": quota,
+			"catch_all":     catchAll,
+		})
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/admin/domains", bytes.NewBuffer(jsonData))
+		c.Request.Header.Set("Content-Type", "application/json")
+
+		service.CreateDomain(c)
+
+		validCodes := []int{
+			http.StatusCreated,
+			http.StatusBadRequest,
+			http.StatusConflict,
+		}
+		assert.Contains(t, validCodes, w.Code, "unexpected status code")
+
+		if w.Code != http.StatusCreated {
+			mockDB.AssertNotCalled(t, "Create", mock.AnythingOfType("*model.Domain"))
+			return
+		}
+
+		var domain model.Domain
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &domain))
+		assert.Regexp(t, domainNamePattern, domain.Name)
+		assert.GreaterOrEqual(t, domain.DefaultQuota, int64(0))
+		assert.True(t, domain.RegistrationOpen)
+	})
+}
+
+func FuzzAdminService_DeleteDomain(f *testing.F) {
+	f.Add("1", false)
+	f.Add("2", true)
+
+	f.Fuzz(func(t *testing.T, domainID string, hasUsers bool) {
+		mockDB := new(MockMailDB)
+		service := NewAdminService(mockDB)
+
+		mockDB.On("Where", "id = ?", mock.Anything).Return(mockDB)
+		mockDB.On("First", mock.AnythingOfType("*model.Domain")).Return(mockDB).Run(func(args mock.Arguments) {
+			args.Get(0).(*model.Domain).Name = "example.org"
+		})
+		mockDB.On("Model", mock.AnythingOfType("*model.User")).Return(mockDB)
+		mockDB.On("Select", "count(*) > 0").Return(mockDB)
+		mockDB.On("Where", "email LIKE ?", "%@example.org").Return(mockDB)
+		mockDB.On("Find", mock.AnythingOfType("*bool")).Return(mockDB).Run(func(args mock.Arguments) {
+			*args.Get(0).(*bool) = hasUsers
+		})
+		mockDB.On("Delete", &model.Domain{}).Return(mockDB)
+		mockDB.On("Error").Return(nil)
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Params = gin.Params{gin.Param{Key: "id", Value: domainID}}
+
+		service.DeleteDomain(c)
+
+		if hasUsers {
+			assert.Equal(t, http.StatusConflict, w.Code)
+			mockDB.AssertNotCalled(t, "Delete", &model.Domain{})
+		} else {
+			assert.Equal(t, http.StatusOK, w.Code)
+			mockDB.AssertCalled(t, "Delete", &model.Domain{})
+		}
+	})
+}
@@ -0,0 +1,452 @@
+package service
+
+import (
+	"backend/internal/model"
+	"backend/utils"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// draftRevisionsKept is how many earlier versions of a draft are kept for
+// recovery. Older ones are dropped as new versions are saved.
+const draftRevisionsKept = 10
+
+var (
+	errDraftConflict = errors.New("Draft was changed by another save")
+
+	// draftColumns are the columns a draft save writes.
+	draftColumns = []string{"to", "cc", "bcc", "subject", "body", "html_body", "in_reply_to", "references", "version", "updated_at"}
+)
+
+// draftInput is a partial update of a draft. Fields left out keep their
+// value, so an editor can autosave just what changed.
+type draftInput struct {
+	To       *[]string `json:"to" form:"to"`
+	Cc       *[]string `json:"cc" form:"cc"`
+	Bcc      *[]string `json:"bcc" form:"bcc"`
+	Subject  *string   `json:"subject" form:"subject"`
+	Body     *string   `json:"body" form:"body"`
+	HTMLBody *string   `json:"html_body" form:"html_body"`
+	// InReplyTo and References thread the draft under the mail it replies
+	// to once it is sent.
+	InReplyTo  *string `json:"in_reply_to" form:"in_reply_to"`
+	References *string `json:"references" form:"references"`
+	// Version is the draft version the client edited. A save based on an
+	// older version is rejected rather than overwriting newer content.
+	Version           *int   `json:"version" form:"version"`
+	RemoveAttachments []uint `json:"remove_attachments" form:"remove_attachments"`
+}
+
+// apply copies the given fields of in into draft and reports whether any
+// of them changed its content.
+func (in draftInput) apply(draft *model.Draft) bool {
+	changed := false
+	setList := func(dst *[]string, src *[]string) {
+		if src != nil && !slices.Equal(*dst, *src) {
+			*dst = append([]string{}, *src...)
+			changed = true
+		}
+	}
+	setText := func(dst *string, src *string) {
+		if src != nil && *dst != *src {
+			*dst = *src
+			changed = true
+		}
+	}
+
+	setList(&draft.To, in.To)
+	setList(&draft.Cc, in.Cc)
+	setList(&draft.Bcc, in.Bcc)
+	setText(&draft.Subject, in.Subject)
+	setText(&draft.Body, in.Body)
+	if in.HTMLBody != nil {
+		html := utils.SanitizeHTML(*in.HTMLBody)
+		setText(&draft.HTMLBody, &html)
+	}
+	setText(&draft.InReplyTo, in.InReplyTo)
+	setText(&draft.References, in.References)
+	return changed
+}
+
+func (ms *mailService) GetDrafts(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	var drafts []model.Draft
+	if err := ms.db.Preload("Attachments").
+		Where("user_id = ?", userID).
+		Order("updated_at DESC").
+		Find(&drafts).Error(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error fetching drafts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"drafts": drafts})
+}
+
+func (ms *mailService) GetDraft(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	draftID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid draftID"})
+		return
+	}
+
+	draft, err := ms.userDraft(userID, uint(draftID))
+	if err != nil {
+		replyDraftError(c, err, "Error fetching draft")
+		return
+	}
+
+	c.JSON(http.StatusOK, draft)
+}
+
+// GetDraftRevisions lists the earlier versions of a draft, newest first.
+// A client recovers one by saving its content, reply headers included, as
+// the draft again.
+func (ms *mailService) GetDraftRevisions(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	draftID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid draftID"})
+		return
+	}
+
+	if _, err := ms.userDraft(userID, uint(draftID)); err != nil {
+		replyDraftError(c, err, "Error fetching draft")
+		return
+	}
+
+	var revisions []model.DraftRevision
+	if err := ms.db.Where("draft_id = ?", draftID).Order("version DESC").Find(&revisions).Error(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error fetching revisions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"revisions": revisions})
+}
+
+func (ms *mailService) GetDraftAttachment(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	draftID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid draftID"})
+		return
+	}
+	attachmentID, err := strconv.Atoi(c.Param("aid"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid attachmentID"})
+		return
+	}
+
+	draft, err := ms.userDraft(userID, uint(draftID))
+	if err != nil {
+		replyDraftError(c, err, "Error fetching draft")
+		return
+	}
+
+	for _, att := range draft.Attachments {
+		if att.Id == uint(attachmentID) {
+			ms.serveAttachment(c, att.Filename, att.ContentType, att.StorageKey, att.Size)
+			return
+		}
+	}
+	c.JSON(http.StatusNotFound, gin.H{"message": "Attachment not found"})
+}
+
+func (ms *mailService) CreateDraft(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	var input draftInput
+	if err := c.ShouldBind(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid input"})
+		return
+	}
+
+	uploads, status, err := ms.saveUploads(c)
+	if err != nil {
+		c.JSON(status, gin.H{"message": err.Error()})
+		return
+	}
+
+	draft := model.Draft{
+		UserId:      userID,
+		To:          []string{},
+		Cc:          []string{},
+		Bcc:         []string{},
+		Version:     1,
+		Attachments: draftAttachments(uploads),
+	}
+	input.apply(&draft)
+
+	if err := ms.db.Create(&draft).Error(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error saving draft"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, draft)
+}
+
+// UpdateDraft saves the given fields of a draft. Every save that changes
+// the draft bumps its version and keeps the previous content as a
+// revision; a save that changes nothing leaves the draft as it is.
+// Attachments are not part of revisions.
+func (ms *mailService) UpdateDraft(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	draftID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid draftID"})
+		return
+	}
+
+	var input draftInput
+	if err := c.ShouldBind(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid input"})
+		return
+	}
+
+	uploads, status, err := ms.saveUploads(c)
+	if err != nil {
+		c.JSON(status, gin.H{"message": err.Error()})
+		return
+	}
+
+	var draft model.Draft
+	err = ms.db.Transaction(func(tx model.MailDB) error {
+		if err := tx.Preload("Attachments").Where("id = ? AND user_id = ?", draftID, userID).First(&draft).Error(); err != nil {
+			return err
+		}
+		if input.Version != nil && *input.Version != draft.Version {
+			return errDraftConflict
+		}
+
+		previous := draft
+		changed := input.apply(&draft)
+
+		var kept []model.DraftAttachment
+		var total int64
+		for _, att := range draft.Attachments {
+			if !slices.Contains(input.RemoveAttachments, att.Id) {
+				kept = append(kept, att)
+				total += att.Size
+			}
+		}
+		for _, att := range uploads {
+			total += att.Size
+		}
+		if total > maxAttachmentsSize {
+			return errAttachmentsTooLarge
+		}
+		removed := len(kept) < len(draft.Attachments)
+
+		if !changed && !removed && len(uploads) == 0 {
+			return nil
+		}
+
+		draft.Version++
+		draft.UpdatedAt = time.Now()
+		res := tx.Model(&model.Draft{}).
+			Select(draftColumns).
+			Where("id = ? AND version = ?", draft.Id, previous.Version).
+			Updates(&draft)
+		if err := res.Error(); err != nil {
+			return err
+		}
+		if res.RowsAffected() != 1 {
+			return errDraftConflict
+		}
+
+		if err := tx.Create(&model.DraftRevision{
+			DraftId:    draft.Id,
+			Version:    previous.Version,
+			To:         previous.To,
+			Cc:         previous.Cc,
+			Bcc:        previous.Bcc,
+			Subject:    previous.Subject,
+			Body:       previous.Body,
+			HTMLBody:   previous.HTMLBody,
+			InReplyTo:  previous.InReplyTo,
+			References: previous.References,
+		}).Error(); err != nil {
+			return err
+		}
+		if err := tx.Where("draft_id = ? AND version < ?", draft.Id, draft.Version-draftRevisionsKept).
+			Delete(&model.DraftRevision{}).Error(); err != nil {
+			return err
+		}
+
+		if removed {
+			if err := tx.Where("draft_id = ? AND id IN ?", draft.Id, input.RemoveAttachments).
+				Delete(&model.DraftAttachment{}).Error(); err != nil {
+				return err
+			}
+		}
+		added := draftAttachments(uploads)
+		for i := range added {
+			added[i].DraftId = draft.Id
+		}
+		if len(added) > 0 {
+			if err := tx.Create(&added).Error(); err != nil {
+				return err
+			}
+		}
+		draft.Attachments = append(kept, added...)
+		return nil
+	})
+	if err != nil {
+		replyDraftError(c, err, "Error saving draft")
+		return
+	}
+
+	c.JSON(http.StatusOK, draft)
+}
+
+func (ms *mailService) DeleteDraft(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	draftID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid draftID"})
+		return
+	}
+
+	err = ms.db.Transaction(func(tx model.MailDB) error {
+		res := tx.Where("id = ? AND user_id = ?", draftID, userID).Delete(&model.Draft{})
+		if err := res.Error(); err != nil {
+			return err
+		}
+		if res.RowsAffected() == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return deleteDraftContent(tx, uint(draftID))
+	})
+	if err != nil {
+		replyDraftError(c, err, "Error deleting draft")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// SendDraft sends a draft through the same path as a new mail and removes
+// it. The draft is removed only if it is still the version that was sent,
+// so a send racing with a save or another send fails instead of sending
+// stale content twice.
+func (ms *mailService) SendDraft(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	draftID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid draftID"})
+		return
+	}
+
+	var user model.User
+	if err := ms.db.Where("id = ?", userID).First(&user).Error(); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "Invalid credentials"})
+		return
+	}
+
+	draft, err := ms.userDraft(userID, uint(draftID))
+	if err != nil {
+		replyDraftError(c, err, "Error fetching draft")
+		return
+	}
+
+	out, ok := composeInput{
+		To:       draft.To,
+		Cc:       draft.Cc,
+		Bcc:      draft.Bcc,
+		Subject:  draft.Subject,
+		Body:     draft.Body,
+		HTMLBody: draft.HTMLBody,
+	}.outgoing()
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid receiver address"})
+		return
+	}
+	if len(out.To)+len(out.Cc)+len(out.Bcc) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "No receivers"})
+		return
+	}
+	out.InReplyTo = draft.InReplyTo
+	out.References = draft.References
+	for _, att := range draft.Attachments {
+		out.Attachments = append(out.Attachments, model.Attachment{
+			Filename:    att.Filename,
+			ContentType: att.ContentType,
+			Size:        att.Size,
+			Checksum:    att.Checksum,
+			StorageKey:  att.StorageKey,
+		})
+	}
+
+	var mail model.Mail
+	err = ms.db.Transaction(func(tx model.MailDB) error {
+		res := tx.Where("id = ? AND version = ?", draft.Id, draft.Version).Delete(&model.Draft{})
+		if err := res.Error(); err != nil {
+			return err
+		}
+		if res.RowsAffected() != 1 {
+			return errDraftConflict
+		}
+		if err := deleteDraftContent(tx, draft.Id); err != nil {
+			return err
+		}
+
+		mail, err = ms.sendMail(tx, user, out)
+		return err
+	})
+	if err != nil {
+		replyDraftError(c, err, "Error sending mail")
+		return
+	}
+
+	c.JSON(http.StatusCreated, sentMail(mail))
+}
+
+// userDraft loads a draft of userID with its attachments.
+func (ms *mailService) userDraft(userID, draftID uint) (model.Draft, error) {
+	var draft model.Draft
+	err := ms.db.Preload("Attachments").Where("id = ? AND user_id = ?", draftID, userID).First(&draft).Error()
+	return draft, err
+}
+
+// deleteDraftContent removes the revisions and attachments of a deleted
+// draft. The stored files stay, as sent mails may share them.
+func deleteDraftContent(tx model.MailDB, draftID uint) error {
+	if err := tx.Where("draft_id = ?", draftID).Delete(&model.DraftRevision{}).Error(); err != nil {
+		return err
+	}
+	return tx.Where("draft_id = ?", draftID).Delete(&model.DraftAttachment{}).Error()
+}
+
+func draftAttachments(uploads []model.Attachment) []model.DraftAttachment {
+	atts := make([]model.DraftAttachment, 0, len(uploads))
+	for _, att := range uploads {
+		atts = append(atts, model.DraftAttachment{
+			Filename:    att.Filename,
+			ContentType: att.ContentType,
+			Size:        att.Size,
+			Checksum:    att.Checksum,
+			StorageKey:  att.StorageKey,
+		})
+	}
+	return atts
+}
+
+func replyDraftError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"message": "Draft not found"})
+	case errors.Is(err, errDraftConflict):
+		c.JSON(http.StatusConflict, gin.H{"message": err.Error()})
+	case errors.Is(err, errAttachmentsTooLarge), errors.Is(err, utils.ErrQuotaExceeded):
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"message": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"message": message})
+	}
+}
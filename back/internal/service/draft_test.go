@@ -0,0 +1,241 @@
+package service
+
+import (
+	"backend/internal/model"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestMailService_UpdateDraft(t *testing.T) {
+	tests := []struct {
+		name     string
+		payload  map[string]interface{}
+		affected int64
+		code     int
+		// saved is the content the save writes, nil when it writes none.
+		saved *model.Draft
+	}{
+		{
+			name:     "changed fields",
+			payload:  map[string]interface{}{"subject": "Re: Plans", "version": 3},
+			affected: 1,
+			code:     http.StatusOK,
+			saved:    &model.Draft{Subject: "Re: Plans", Body: "text", InReplyTo: "<root@example.com>", References: "<root@example.com>", Version: 4},
+		},
+		{
+			name:     "without a version",
+			payload:  map[string]interface{}{"body": "more text"},
+			affected: 1,
+			code:     http.StatusOK,
+			saved:    &model.Draft{Subject: "old", Body: "more text", InReplyTo: "<root@example.com>", References: "<root@example.com>", Version: 4},
+		},
+		{
+			name:     "reply headers",
+			payload:  map[string]interface{}{"in_reply_to": "<parent@example.com>", "references": "<root@example.com> <parent@example.com>"},
+			affected: 1,
+			code:     http.StatusOK,
+			saved:    &model.Draft{Subject: "old", Body: "text", InReplyTo: "<parent@example.com>", References: "<root@example.com> <parent@example.com>", Version: 4},
+		},
+		{
+			name:     "sanitized html",
+			payload:  map[string]interface{}{"html_body": `<p onclick="steal()">Hi</p><script>alert(1)</script>`},
+			affected: 1,
+			code:     http.StatusOK,
+			saved:    &model.Draft{Subject: "old", Body: "text", HTMLBody: "<p>Hi</p>", InReplyTo: "<root@example.com>", References: "<root@example.com>", Version: 4},
+		},
+		{
+			name:    "stale version",
+			payload: map[string]interface{}{"subject": "Re: Plans", "version": 2},
+			code:    http.StatusConflict,
+		},
+		{
+			name:    "nothing changed",
+			payload: map[string]interface{}{"subject": "old", "body": "text"},
+			code:    http.StatusOK,
+		},
+		{
+			name:     "raced by another save",
+			payload:  map[string]interface{}{"subject": "Re: Plans", "version": 3},
+			affected: 0,
+			code:     http.StatusConflict,
+			saved:    &model.Draft{Subject: "Re: Plans", Body: "text", InReplyTo: "<root@example.com>", References: "<root@example.com>", Version: 4},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(MockMailDB)
+			service := NewMailService(mockDB, nil)
+
+			var updated *model.Draft
+			var revision *model.DraftRevision
+			mockDB.On("Preload", "Attachments").Return(mockDB)
+			mockDB.On("Where", "id = ? AND user_id = ?", 9, uint(1)).Return(mockDB)
+			mockDB.On("First", mock.AnythingOfType("*model.Draft")).Return(mockDB).Run(func(args mock.Arguments) {
+				*args.Get(0).(*model.Draft) = model.Draft{Id: 9, UserId: 1, Subject: "old", Body: "text", InReplyTo: "<root@example.com>", References: "<root@example.com>", Version: 3}
+			})
+			mockDB.On("Model", &model.Draft{}).Return(mockDB)
+			mockDB.On("Select", draftColumns).Return(mockDB)
+			mockDB.On("Where", "id = ? AND version = ?", uint(9), 3).Return(mockDB)
+			mockDB.On("Updates", mock.AnythingOfType("*model.Draft")).Return(mockDB).Run(func(args mock.Arguments) {
+				updated = args.Get(0).(*model.Draft)
+			})
+			mockDB.On("RowsAffected").Return(tt.affected)
+			mockDB.On("Create", mock.AnythingOfType("*model.DraftRevision")).Return(mockDB).Run(func(args mock.Arguments) {
+				revision = args.Get(0).(*model.DraftRevision)
+			})
+			mockDB.On("Where", "draft_id = ? AND version < ?", uint(9), 4-draftRevisionsKept).Return(mockDB)
+			mockDB.On("Delete", &model.DraftRevision{}).Return(mockDB)
+			mockDB.On("Error").Return(nil)
+
+			jsonData, _ := json.Marshal(tt.payload)
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Set("userID", uint(1))
+			c.Params = gin.Params{gin.Param{Key: "id", Value: "9"}}
+			c.Request = httptest.NewRequest(http.MethodPut, "/mail/drafts/9", bytes.NewBuffer(jsonData))
+			c.Request.Header.Set("Content-Type", "application/json")
+
+			service.UpdateDraft(c)
+
+			assert.Equal(t, tt.code, w.Code)
+			if tt.saved == nil {
+				mockDB.AssertNotCalled(t, "Updates", mock.Anything)
+				mockDB.AssertNotCalled(t, "Create", mock.Anything)
+				return
+			}
+
+			assert.Equal(t, tt.saved.Subject, updated.Subject)
+			assert.Equal(t, tt.saved.Body, updated.Body)
+			assert.Equal(t, tt.saved.HTMLBody, updated.HTMLBody)
+			assert.Equal(t, tt.saved.InReplyTo, updated.InReplyTo)
+			assert.Equal(t, tt.saved.References, updated.References)
+			assert.Equal(t, tt.saved.Version, updated.Version)
+			if tt.code != http.StatusOK {
+				assert.Nil(t, revision)
+				return
+			}
+			if assert.NotNil(t, revision) {
+				assert.Equal(t, uint(9), revision.DraftId)
+				assert.Equal(t, 3, revision.Version)
+				assert.Equal(t, "old", revision.Subject)
+				assert.Equal(t, "text", revision.Body)
+				assert.Equal(t, "<root@example.com>", revision.InReplyTo)
+				assert.Equal(t, "<root@example.com>", revision.References)
+			}
+		})
+	}
+}
+
+func TestMailService_SendDraft(t *testing.T) {
+	tests := []struct {
+		name     string
+		to       []string
+		affected int64
+		code     int
+	}{
+		{"sent", []string{"Alice <alice@example.com>"}, 1, http.StatusCreated},
+		{"raced by another send", []string{"alice@example.com"}, 0, http.StatusConflict},
+		{"no receivers", nil, 1, http.StatusBadRequest},
+		{"invalid receiver", []string{"not an address"}, 1, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(MockMailDB)
+			service := NewMailService(mockDB, nil)
+
+			draft := model.Draft{
+				Id:         4,
+				UserId:     1,
+				To:         tt.to,
+				Subject:    "Draft",
+				Body:       "text",
+				InReplyTo:  "<parent@example.com>",
+				References: "<root@example.com> <parent@example.com>",
+				Version:    7,
+				Attachments: []model.DraftAttachment{
+					{Id: 1, DraftId: 4, Filename: "a.txt", ContentType: "text/plain", Size: 3, Checksum: "sum", StorageKey: "key"},
+				},
+			}
+
+			var created *model.Mail
+			var deliveries []model.Delivery
+			var deleted []interface{}
+			mockDB.On("Where", "id = ?", uint(1)).Return(mockDB)
+			mockDB.On("First", mock.AnythingOfType("*model.User")).Return(mockDB).Run(func(args mock.Arguments) {
+				*args.Get(0).(*model.User) = model.User{Id: 1, Email: "test1@" + testDomain}
+			})
+			mockDB.On("Preload", "Attachments").Return(mockDB)
+			mockDB.On("Where", "id = ? AND user_id = ?", uint(4), uint(1)).Return(mockDB)
+			mockDB.On("First", mock.AnythingOfType("*model.Draft")).Return(mockDB).Run(func(args mock.Arguments) {
+				*args.Get(0).(*model.Draft) = draft
+			})
+			mockDB.On("Where", "id = ? AND version = ?", uint(4), 7).Return(mockDB)
+			mockDB.On("RowsAffected").Return(tt.affected)
+			mockDB.On("Where", "draft_id = ?", uint(4)).Return(mockDB)
+			mockDB.On("Delete", mock.Anything).Return(mockDB).Run(func(args mock.Arguments) {
+				deleted = append(deleted, args.Get(0))
+			})
+			mockDB.On("Select", "thread_id").Return(mockDB)
+			mockDB.On("Where", "message_id IN ? AND thread_id <> ''", []string{"<root@example.com>", "<parent@example.com>", "<parent@example.com>"}).Return(mockDB)
+			mockDB.On("Limit", 1).Return(mockDB)
+			mockDB.On("Find", mock.AnythingOfType("*[]model.Mail")).Return(mockDB)
+			mockDB.On("Where", "name IN ?", []string{"example.com"}).Return(mockDB)
+			mockDB.On("Find", mock.AnythingOfType("*[]model.Domain")).Return(mockDB)
+			mockDB.On("Create", mock.AnythingOfType("*model.Mail")).Return(mockDB).Run(func(args mock.Arguments) {
+				created = args.Get(0).(*model.Mail)
+			})
+			mockDB.On("Create", mock.AnythingOfType("*[]model.Mailbox")).Return(mockDB)
+			mockDB.On("Create", mock.AnythingOfType("*[]model.Delivery")).Return(mockDB).Run(func(args mock.Arguments) {
+				deliveries = *args.Get(0).(*[]model.Delivery)
+			})
+			mockDB.On("Error").Return(nil)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Set("userID", uint(1))
+			c.Params = gin.Params{gin.Param{Key: "id", Value: "4"}}
+			c.Request = httptest.NewRequest(http.MethodPost, "/mail/drafts/4/send", nil)
+
+			service.SendDraft(c)
+
+			assert.Equal(t, tt.code, w.Code)
+			switch tt.code {
+			case http.StatusBadRequest:
+				assert.Empty(t, deleted)
+				assert.Nil(t, created)
+				return
+			case http.StatusConflict:
+				assert.Equal(t, []interface{}{&model.Draft{}}, deleted)
+				assert.Nil(t, created)
+				return
+			}
+
+			assert.Equal(t, []interface{}{&model.Draft{}, &model.DraftRevision{}, &model.DraftAttachment{}}, deleted)
+			assert.Equal(t, "test1@"+testDomain, created.Sender)
+			assert.Equal(t, "Draft", created.Subject)
+			assert.Equal(t, "text", created.Body)
+			assert.Equal(t, "<parent@example.com>", created.InReplyTo)
+			assert.Equal(t, "<root@example.com> <parent@example.com>", created.References)
+			assert.Equal(t, model.ThreadID("<root@example.com>"), created.ThreadId)
+			assert.Equal(t, []model.Recipient{{Address: "alice@example.com", Type: model.RecipientTo}}, created.Recipients)
+			if assert.Len(t, created.Attachments, 1) {
+				assert.Equal(t, "a.txt", created.Attachments[0].Filename)
+				assert.Equal(t, "key", created.Attachments[0].StorageKey)
+				assert.Equal(t, "sum", created.Attachments[0].Checksum)
+			}
+			if assert.Len(t, deliveries, 1) {
+				assert.Equal(t, "alice@example.com", deliveries[0].Recipient)
+				assert.Equal(t, model.DeliveryQueued, deliveries[0].Status)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package service
+
+import (
+	"backend/internal/model"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// flagsInput sets or clears the given flags; flags left out keep their
+// value.
+type flagsInput struct {
+	Seen      *bool `json:"seen"`
+	Flagged   *bool `json:"flagged"`
+	Answered  *bool `json:"answered"`
+	Forwarded *bool `json:"forwarded"`
+}
+
+func (in flagsInput) updates() map[string]interface{} {
+	updates := make(map[string]interface{})
+	for column, value := range map[string]*bool{
+		"seen":      in.Seen,
+		"flagged":   in.Flagged,
+		"answered":  in.Answered,
+		"forwarded": in.Forwarded,
+	} {
+		if value != nil {
+			updates[column] = *value
+		}
+	}
+	return updates
+}
+
+// GetMail returns a single mail the user can see and marks it as seen.
+func (ms *mailService) GetMail(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	mailID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid mailID"})
+		return
+	}
+
+	mail, isSender, err := ms.visibleMail(userID, uint(mailID))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Mail not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error fetching mail"})
+		return
+	}
+	if !isSender {
+		mail.Recipients = withoutBcc(mail.Recipients)
+	}
+
+	if err := ms.db.Model(&model.Mailbox{}).
+		Where("user_id = ? AND mail_id = ? AND seen = ?", userID, mailID, false).
+		Update("seen", true).Error(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error marking mail as seen"})
+		return
+	}
+
+	mails := []model.Mail{mail}
+	if err := ms.attachUserState(userID, mails); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error fetching mail"})
+		return
+	}
+
+	c.JSON(http.StatusOK, mails[0])
+}
+
+// SetFlags sets and clears flags on the user's copy of a mail.
+func (ms *mailService) SetFlags(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	mailID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid mailID"})
+		return
+	}
+
+	var input flagsInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid input"})
+		return
+	}
+	updates := input.updates()
+	if len(updates) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "No flags given"})
+		return
+	}
+
+	res := ms.db.Model(&model.Mailbox{}).
+		Where("user_id = ? AND mail_id = ?", userID, mailID).
+		Updates(updates)
+	if err := res.Error(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error updating flags"})
+		return
+	}
+	if res.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Mail not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// unreadCount counts the mails in the inbox of userID not yet seen.
+func (ms *mailService) unreadCount(userID uint) (int64, error) {
+	var count int64
+	err := ms.db.Model(&model.Mailbox{}).
+		Select("count(*)").
+		Where("user_id = ? AND folder = ? AND seen = ?", userID, model.FolderInbox, false).
+		Find(&count).Error()
+	return count, err
+}
+
+// attachUserState fills in the flags and labels userID has on mails.
+func (ms *mailService) attachUserState(userID uint, mails []model.Mail) error {
+	if err := ms.attachFlags(userID, mails); err != nil {
+		return err
+	}
+	return ms.attachLabels(userID, mails)
+}
+
+// attachFlags fills in the flags userID has on each of mails. A user
+// holding two copies of a mail, having sent it to themselves, sees a
+// flag when either copy has it.
+func (ms *mailService) attachFlags(userID uint, mails []model.Mail) error {
+	if len(mails) == 0 {
+		return nil
+	}
+	ids := make([]uint, 0, len(mails))
+	for _, mail := range mails {
+		ids = append(ids, mail.ID)
+	}
+
+	var boxes []model.Mailbox
+	if err := ms.db.Select("mail_id", "seen", "flagged", "answered", "forwarded").
+		Where("user_id = ? AND mail_id IN ?", userID, ids).
+		Find(&boxes).Error(); err != nil {
+		return err
+	}
+
+	byMail := make(map[uint]*model.Flags, len(mails))
+	for _, box := range boxes {
+		flags, ok := byMail[box.MailId]
+		if !ok {
+			flags = &model.Flags{}
+			byMail[box.MailId] = flags
+		}
+		flags.Seen = flags.Seen || box.Flags.Seen
+		flags.Flagged = flags.Flagged || box.Flags.Flagged
+		flags.Answered = flags.Answered || box.Flags.Answered
+		flags.Forwarded = flags.Forwarded || box.Flags.Forwarded
+	}
+	for i := range mails {
+		mails[i].Flags = byMail[mails[i].ID]
+	}
+	return nil
+}
+
+// withoutBcc drops the Bcc recipients from recs.
+func withoutBcc(recs []model.Recipient) []model.Recipient {
+	visible := recs[:0]
+	for _, rec := range recs {
+		if rec.Type != model.RecipientBcc {
+			visible = append(visible, rec)
+		}
+	}
+	return visible
+}
@@ -0,0 +1,189 @@
+package service
+
+import (
+	"backend/internal/model"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestMailService_SetFlags(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		affected int64
+		code     int
+		// updates is the change written, nil when none is.
+		updates map[string]interface{}
+	}{
+		{
+			name:     "set seen",
+			body:     `{"seen": true}`,
+			affected: 1,
+			code:     http.StatusOK,
+			updates:  map[string]interface{}{"seen": true},
+		},
+		{
+			name:     "set flagged and clear seen",
+			body:     `{"flagged": true, "seen": false}`,
+			affected: 2,
+			code:     http.StatusOK,
+			updates:  map[string]interface{}{"flagged": true, "seen": false},
+		},
+		{
+			name:     "set answered and forwarded",
+			body:     `{"answered": true, "forwarded": true}`,
+			affected: 1,
+			code:     http.StatusOK,
+			updates:  map[string]interface{}{"answered": true, "forwarded": true},
+		},
+		{
+			name:     "mail not found",
+			body:     `{"answered": true}`,
+			affected: 0,
+			code:     http.StatusNotFound,
+			updates:  map[string]interface{}{"answered": true},
+		},
+		{
+			name: "unknown flag only",
+			body: `{"starred": true}`,
+			code: http.StatusBadRequest,
+		},
+		{
+			name: "no flags",
+			body: `{}`,
+			code: http.StatusBadRequest,
+		},
+		{
+			name: "flag not a bool",
+			body: `{"seen": "yes"}`,
+			code: http.StatusBadRequest,
+		},
+		{
+			name: "invalid json",
+			body: `not json`,
+			code: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(MockMailDB)
+			service := NewMailService(mockDB, nil)
+
+			var updates map[string]interface{}
+			mockDB.On("Model", &model.Mailbox{}).Return(mockDB)
+			mockDB.On("Where", "user_id = ? AND mail_id = ?", uint(1), 3).Return(mockDB)
+			mockDB.On("Updates", mock.AnythingOfType("map[string]interface {}")).Return(mockDB).Run(func(args mock.Arguments) {
+				updates = args.Get(0).(map[string]interface{})
+			})
+			mockDB.On("RowsAffected").Return(tt.affected)
+			mockDB.On("Error").Return(nil)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Set("userID", uint(1))
+			c.Params = gin.Params{gin.Param{Key: "id", Value: "3"}}
+			c.Request = httptest.NewRequest(http.MethodPost, "/mail/3/flags", bytes.NewBufferString(tt.body))
+			c.Request.Header.Set("Content-Type", "application/json")
+
+			service.SetFlags(c)
+
+			assert.Equal(t, tt.code, w.Code)
+			assert.Equal(t, tt.updates, updates)
+		})
+	}
+}
+
+func TestMailService_GetMail(t *testing.T) {
+	tests := []struct {
+		name       string
+		role       string
+		recipients []model.Recipient
+	}{
+		{
+			name: "sender sees bcc",
+			role: model.MailboxSender,
+			recipients: []model.Recipient{
+				{Address: "to@example.com", Type: model.RecipientTo},
+				{Address: "hidden@example.com", Type: model.RecipientBcc},
+			},
+		},
+		{
+			name: "recipient does not see bcc",
+			role: model.MailboxRecipient,
+			recipients: []model.Recipient{
+				{Address: "to@example.com", Type: model.RecipientTo},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(MockMailDB)
+			service := NewMailService(mockDB, nil)
+
+			mockDB.On("Where", "user_id = ? AND mail_id = ? AND folder IN ?", uint(1), uint(5), visibleFolders).Return(mockDB)
+			mockDB.On("Find", mock.AnythingOfType("*[]model.Mailbox")).Return(mockDB).Run(func(args mock.Arguments) {
+				*args.Get(0).(*[]model.Mailbox) = []model.Mailbox{{MailId: 5, Role: tt.role}}
+			}).Once()
+			mockDB.On("Preload", "Recipients").Return(mockDB)
+			mockDB.On("Preload", "Attachments").Return(mockDB)
+			mockDB.On("Where", "id = ?", uint(5)).Return(mockDB)
+			mockDB.On("First", mock.AnythingOfType("*model.Mail")).Return(mockDB).Run(func(args mock.Arguments) {
+				mail := args.Get(0).(*model.Mail)
+				mail.ID = 5
+				mail.Subject = "Plans"
+				mail.Recipients = []model.Recipient{
+					{Address: "to@example.com", Type: model.RecipientTo},
+					{Address: "hidden@example.com", Type: model.RecipientBcc},
+				}
+			})
+			mockDB.On("Model", &model.Mailbox{}).Return(mockDB)
+			mockDB.On("Where", "user_id = ? AND mail_id = ? AND seen = ?", uint(1), 5, false).Return(mockDB)
+			mockDB.On("Update", "seen", true).Return(mockDB)
+			mockDB.On("Select", "mail_id", "seen", "flagged", "answered", "forwarded").Return(mockDB)
+			mockDB.On("Where", "user_id = ? AND mail_id IN ?", uint(1), []uint{5}).Return(mockDB)
+			mockDB.On("Find", mock.AnythingOfType("*[]model.Mailbox")).Return(mockDB).Run(func(args mock.Arguments) {
+				*args.Get(0).(*[]model.Mailbox) = []model.Mailbox{
+					{MailId: 5, Flags: model.Flags{Seen: true}},
+					{MailId: 5, Flags: model.Flags{Flagged: true}},
+				}
+			}).Once()
+			mockDB.On("Model", &model.MailboxLabel{}).Return(mockDB)
+			mockDB.On("Select", "DISTINCT mailboxes.mail_id, mailbox_labels.label_id").Return(mockDB)
+			mockDB.On("Joins", "JOIN mailboxes ON mailboxes.id = mailbox_labels.mailbox_id").Return(mockDB)
+			mockDB.On("Where", "mailboxes.user_id = ? AND mailboxes.mail_id IN ?", uint(1), []uint{5}).Return(mockDB)
+			mockDB.On("Order", "mailbox_labels.label_id").Return(mockDB)
+			mockDB.On("Find", mock.Anything).Return(mockDB).Run(func(args mock.Arguments) {
+				// The label rows are an unnamed struct, so fill them through JSON.
+				rows, _ := json.Marshal([]map[string]uint{{"MailId": 5, "LabelId": 7}})
+				json.Unmarshal(rows, args.Get(0))
+			}).Once()
+			mockDB.On("Error").Return(nil)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Set("userID", uint(1))
+			c.Params = gin.Params{gin.Param{Key: "id", Value: "5"}}
+
+			service.GetMail(c)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			mockDB.AssertCalled(t, "Update", "seen", true)
+
+			var mail model.Mail
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &mail))
+			assert.Equal(t, "Plans", mail.Subject)
+			assert.Equal(t, &model.Flags{Seen: true, Flagged: true}, mail.Flags)
+			assert.Equal(t, []uint{7}, mail.Labels)
+			assert.Equal(t, tt.recipients, mail.Recipients)
+		})
+	}
+}
@@ -0,0 +1,313 @@
+package service
+
+import (
+	"backend/internal/model"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const maxFolderNameLength = 64
+
+var (
+	errInvalidFolderName = errors.New("Invalid folder name")
+	errFolderNotFound    = errors.New("Folder not found")
+	errFolderExists      = errors.New("Folder already exists")
+	errFolderCycle       = errors.New("Folder cannot be moved into itself")
+)
+
+// folderInput creates a folder or renames and moves one. A parent_id of 0
+// puts the folder at the top level; leaving it out on update keeps the
+// folder where it is.
+type folderInput struct {
+	Name     *string `json:"name"`
+	ParentId *uint   `json:"parent_id"`
+}
+
+// moveInput files a mail in a user folder, or returns it to the inbox or
+// sent folder it came from when folder_id is 0 or missing.
+type moveInput struct {
+	FolderId uint `json:"folder_id"`
+}
+
+func (ms *mailService) GetFolders(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	var folders []model.Folder
+	if err := ms.db.Where("user_id = ?", userID).Order("name").Find(&folders).Error(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error fetching folders"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"folders": folders})
+}
+
+func (ms *mailService) CreateFolder(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	var input folderInput
+	if err := c.ShouldBindJSON(&input); err != nil || input.Name == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid input"})
+		return
+	}
+
+	var folders []model.Folder
+	if err := ms.db.Where("user_id = ?", userID).Find(&folders).Error(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error fetching folders"})
+		return
+	}
+
+	folder := model.Folder{UserId: userID}
+	if err := placeFolder(folders, &folder, input); err != nil {
+		replyFolderError(c, err, "Error creating folder")
+		return
+	}
+
+	if err := ms.db.Create(&folder).Error(); err != nil {
+		replyFolderError(c, err, "Error creating folder")
+		return
+	}
+
+	c.JSON(http.StatusCreated, folder)
+}
+
+func (ms *mailService) UpdateFolder(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	folderID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid folderID"})
+		return
+	}
+
+	var input folderInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid input"})
+		return
+	}
+
+	var folders []model.Folder
+	if err := ms.db.Where("user_id = ?", userID).Find(&folders).Error(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error fetching folders"})
+		return
+	}
+
+	var folder *model.Folder
+	for i := range folders {
+		if folders[i].Id == uint(folderID) {
+			folder = &folders[i]
+		}
+	}
+	if folder == nil {
+		replyFolderError(c, errFolderNotFound, "")
+		return
+	}
+
+	if err := placeFolder(folders, folder, input); err != nil {
+		replyFolderError(c, err, "Error updating folder")
+		return
+	}
+
+	if err := ms.db.Model(&model.Folder{}).
+		Where("id = ? AND user_id = ?", folder.Id, userID).
+		Updates(map[string]interface{}{"name": folder.Name, "parent_id": folder.ParentId}).Error(); err != nil {
+		replyFolderError(c, err, "Error updating folder")
+		return
+	}
+
+	c.JSON(http.StatusOK, folder)
+}
+
+// DeleteFolder removes an empty-of-subfolders folder. The mails filed in
+// it go back to the inbox or sent folder they came from.
+func (ms *mailService) DeleteFolder(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	folderID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid folderID"})
+		return
+	}
+
+	var hasChildren bool
+	if err := ms.db.Model(&model.Folder{}).
+		Select("count(*) > 0").
+		Where("user_id = ? AND parent_id = ?", userID, folderID).
+		Find(&hasChildren).Error(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error deleting folder"})
+		return
+	}
+	if hasChildren {
+		c.JSON(http.StatusConflict, gin.H{"message": "Folder has subfolders"})
+		return
+	}
+
+	err = ms.db.Transaction(func(tx model.MailDB) error {
+		res := tx.Where("id = ? AND user_id = ?", folderID, userID).Delete(&model.Folder{})
+		if err := res.Error(); err != nil {
+			return err
+		}
+		if res.RowsAffected() == 0 {
+			return errFolderNotFound
+		}
+		return tx.Model(&model.Mailbox{}).
+			Where("user_id = ? AND folder_id = ?", userID, folderID).
+			Updates(homeFolder()).Error()
+	})
+	if err != nil {
+		replyFolderError(c, err, "Error deleting folder")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+func (ms *mailService) GetFolderMails(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	folderID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid folderID"})
+		return
+	}
+
+	var page mailPage
+	if err := c.ShouldBindQuery(&page); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid query"})
+		return
+	}
+	if err := page.normalize(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	var folder model.Folder
+	if err := ms.db.Where("id = ? AND user_id = ?", folderID, userID).First(&folder).Error(); err != nil {
+		replyFolderError(c, errFolderNotFound, "")
+		return
+	}
+
+	query := ms.db.Preload("Attachments").Preload("Recipients").
+		Where("EXISTS (SELECT 1 FROM mailboxes WHERE mailboxes.mail_id = mails.id AND mailboxes.user_id = ? AND mailboxes.folder = ? AND mailboxes.folder_id = ?)",
+			userID, model.FolderUser, folderID)
+	ms.listMails(c, userID, page, query)
+}
+
+// MoveMail files a mail the user can see in one of their folders, or takes
+// it out of one.
+func (ms *mailService) MoveMail(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	mailID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid mailID"})
+		return
+	}
+
+	var input moveInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid input"})
+		return
+	}
+
+	if input.FolderId != 0 {
+		var folder model.Folder
+		if err := ms.db.Where("id = ? AND user_id = ?", input.FolderId, userID).First(&folder).Error(); err != nil {
+			replyFolderError(c, errFolderNotFound, "")
+			return
+		}
+	}
+
+	moved, err := moveMailbox(input.FolderId)(ms.db, userID, uint(mailID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error moving mail"})
+		return
+	}
+	if moved == 0 {
+		ms.replyUnchanged(c, userID, uint(mailID), "Mail is in the trash")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// homeFolder is the update returning a mailbox to the folder it arrives
+// in: sent for the sender's copy, inbox for everyone else.
+func homeFolder() map[string]interface{} {
+	return map[string]interface{}{
+		"folder":    gorm.Expr("CASE WHEN role = ? THEN ? ELSE ? END", model.MailboxSender, model.FolderSent, model.FolderInbox),
+		"folder_id": nil,
+	}
+}
+
+// placeFolder applies input to folder, one of folders or a new one. It
+// checks that the name is valid and unique among its siblings and that
+// the new parent exists and is not the folder itself or below it.
+func placeFolder(folders []model.Folder, folder *model.Folder, input folderInput) error {
+	if input.Name != nil {
+		name := strings.TrimSpace(*input.Name)
+		if !validFolderName(name) {
+			return errInvalidFolderName
+		}
+		folder.Name = name
+	}
+
+	if input.ParentId != nil {
+		folder.ParentId = nil
+		if *input.ParentId != 0 {
+			parent := *input.ParentId
+			folder.ParentId = &parent
+		}
+	}
+
+	byID := make(map[uint]model.Folder, len(folders))
+	for _, f := range folders {
+		byID[f.Id] = f
+	}
+
+	for parent := folder.ParentId; parent != nil; {
+		if folder.Id != 0 && *parent == folder.Id {
+			return errFolderCycle
+		}
+		f, ok := byID[*parent]
+		if !ok {
+			return errFolderNotFound
+		}
+		parent = f.ParentId
+	}
+
+	for _, f := range folders {
+		if f.Id != folder.Id && sameParent(f.ParentId, folder.ParentId) && strings.EqualFold(f.Name, folder.Name) {
+			return errFolderExists
+		}
+	}
+	return nil
+}
+
+func validFolderName(name string) bool {
+	return name != "" && utf8.RuneCountInString(name) <= maxFolderNameLength && !strings.Contains(name, "/")
+}
+
+func sameParent(a, b *uint) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func replyFolderError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, errFolderNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"message": err.Error()})
+	case errors.Is(err, errFolderExists):
+		c.JSON(http.StatusConflict, gin.H{"message": err.Error()})
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		// Another request created the same name since it was checked.
+		c.JSON(http.StatusConflict, gin.H{"message": errFolderExists.Error()})
+	case errors.Is(err, errInvalidFolderName), errors.Is(err, errFolderCycle):
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"message": message})
+	}
+}
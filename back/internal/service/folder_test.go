@@ -0,0 +1,248 @@
+package service
+
+import (
+	"backend/internal/model"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+)
+
+func uintPtr(v uint) *uint {
+	return &v
+}
+
+func TestPlaceFolder(t *testing.T) {
+	str := func(s string) *string { return &s }
+
+	tests := []struct {
+		name       string
+		folderID   uint
+		input      folderInput
+		err        error
+		wantName   string
+		wantParent *uint
+	}{
+		{"new top-level", 0, folderInput{Name: str("Projects"), ParentId: uintPtr(0)}, nil, "Projects", nil},
+		{"name trimmed", 0, folderInput{Name: str("  Projects ")}, nil, "Projects", nil},
+		{"new nested", 0, folderInput{Name: str("Beta"), ParentId: uintPtr(2)}, nil, "Beta", uintPtr(2)},
+		{"sibling name in another case", 0, folderInput{Name: str("work")}, errFolderExists, "", nil},
+		{"same name under another parent", 0, folderInput{Name: str("Clients")}, nil, "Clients", nil},
+		{"renamed to its own name", 2, folderInput{Name: str("CLIENTS")}, nil, "CLIENTS", uintPtr(1)},
+		{"renamed keeps its parent", 3, folderInput{Name: str("Acme Inc")}, nil, "Acme Inc", uintPtr(2)},
+		{"moved to the top", 3, folderInput{ParentId: uintPtr(0)}, nil, "Acme", nil},
+		{"moved into itself", 2, folderInput{ParentId: uintPtr(2)}, errFolderCycle, "", nil},
+		{"moved below itself", 1, folderInput{ParentId: uintPtr(3)}, errFolderCycle, "", nil},
+		{"unknown parent", 0, folderInput{Name: str("Acme"), ParentId: uintPtr(9)}, errFolderNotFound, "", nil},
+		{"slash in name", 0, folderInput{Name: str("a/b")}, errInvalidFolderName, "", nil},
+		{"blank name", 0, folderInput{Name: str("  ")}, errInvalidFolderName, "", nil},
+		{"name too long", 0, folderInput{Name: str(strings.Repeat("x", maxFolderNameLength+1))}, errInvalidFolderName, "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Work
+			// └── Clients
+			//     └── Acme
+			folders := []model.Folder{
+				{Id: 1, Name: "Work"},
+				{Id: 2, Name: "Clients", ParentId: uintPtr(1)},
+				{Id: 3, Name: "Acme", ParentId: uintPtr(2)},
+			}
+			folder := &model.Folder{}
+			for i := range folders {
+				if folders[i].Id == tt.folderID {
+					folder = &folders[i]
+				}
+			}
+
+			err := placeFolder(folders, folder, tt.input)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantName, folder.Name)
+			assert.Equal(t, tt.wantParent, folder.ParentId)
+		})
+	}
+}
+
+func TestMailService_CreateFolder(t *testing.T) {
+	tests := []struct {
+		name      string
+		payload   string
+		createErr error
+		code      int
+		// created is the folder stored, nil when none is.
+		created *model.Folder
+	}{
+		{"top-level", `{"name": " Projects "}`, nil, http.StatusCreated, &model.Folder{UserId: 1, Name: "Projects"}},
+		{"nested", `{"name": "Acme", "parent_id": 1}`, nil, http.StatusCreated, &model.Folder{UserId: 1, Name: "Acme", ParentId: uintPtr(1)}},
+		{"name taken in another case", `{"name": "WORK"}`, nil, http.StatusConflict, nil},
+		{"name taken by a concurrent create", `{"name": "Projects"}`, gorm.ErrDuplicatedKey, http.StatusConflict, &model.Folder{UserId: 1, Name: "Projects"}},
+		{"unknown parent", `{"name": "Acme", "parent_id": 7}`, nil, http.StatusNotFound, nil},
+		{"invalid name", `{"name": "a/b"}`, nil, http.StatusBadRequest, nil},
+		{"missing name", `{}`, nil, http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(MockMailDB)
+			service := NewMailService(mockDB, nil)
+
+			var created *model.Folder
+			mockDB.On("Where", "user_id = ?", uint(1)).Return(mockDB)
+			mockDB.On("Find", mock.AnythingOfType("*[]model.Folder")).Return(mockDB).Run(func(args mock.Arguments) {
+				*args.Get(0).(*[]model.Folder) = []model.Folder{{Id: 1, UserId: 1, Name: "Work"}}
+			})
+			mockDB.On("Create", mock.AnythingOfType("*model.Folder")).Return(mockDB).Run(func(args mock.Arguments) {
+				created = args.Get(0).(*model.Folder)
+			})
+			mockDB.On("Error").Return(nil).Once()
+			mockDB.On("Error").Return(tt.createErr)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Set("userID", uint(1))
+			c.Request = httptest.NewRequest(http.MethodPost, "/mail/folders", bytes.NewBufferString(tt.payload))
+			c.Request.Header.Set("Content-Type", "application/json")
+
+			service.CreateFolder(c)
+
+			assert.Equal(t, tt.code, w.Code)
+			if tt.created == nil {
+				mockDB.AssertNotCalled(t, "Create", mock.Anything)
+				return
+			}
+			if assert.NotNil(t, created) {
+				assert.Equal(t, *tt.created, *created)
+			}
+		})
+	}
+}
+
+func TestMailService_MoveMail(t *testing.T) {
+	tests := []struct {
+		name      string
+		folderID  uint
+		folderErr error
+		affected  int64
+		kept      bool
+		code      int
+		// updates is the change made to the mailbox, nil when none is.
+		updates map[string]interface{}
+	}{
+		{"into a folder", 5, nil, 1, false, http.StatusOK, map[string]interface{}{"folder": model.FolderUser, "folder_id": uint(5)}},
+		{"back home", 0, nil, 1, false, http.StatusOK, homeFolder()},
+		{"into another user's folder", 5, gorm.ErrRecordNotFound, 1, false, http.StatusNotFound, nil},
+		{"mail in the trash", 5, nil, 0, true, http.StatusConflict, map[string]interface{}{"folder": model.FolderUser, "folder_id": uint(5)}},
+		{"unknown mail", 0, nil, 0, false, http.StatusNotFound, homeFolder()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(MockMailDB)
+			service := NewMailService(mockDB, nil)
+
+			var updates map[string]interface{}
+			mockDB.On("Where", "id = ? AND user_id = ?", tt.folderID, uint(1)).Return(mockDB)
+			mockDB.On("First", mock.AnythingOfType("*model.Folder")).Return(mockDB)
+			mockDB.On("Model", mock.AnythingOfType("*model.Mailbox")).Return(mockDB)
+			mockDB.On("Where", "user_id = ? AND mail_id = ? AND folder IN ?", uint(1), uint(7), visibleFolders).Return(mockDB)
+			mockDB.On("Updates", mock.AnythingOfType("map[string]interface {}")).Return(mockDB).Run(func(args mock.Arguments) {
+				updates = args.Get(0).(map[string]interface{})
+			})
+			mockDB.On("RowsAffected").Return(tt.affected)
+			mockDB.On("Select", "count(*) > 0").Return(mockDB)
+			mockDB.On("Where", "user_id = ? AND mail_id = ? AND folder <> ?", uint(1), uint(7), model.FolderDeleted).Return(mockDB)
+			mockDB.On("Find", mock.AnythingOfType("*bool")).Return(mockDB).Run(func(args mock.Arguments) {
+				*args.Get(0).(*bool) = tt.kept
+			})
+			if tt.folderID != 0 {
+				mockDB.On("Error").Return(tt.folderErr).Once()
+			}
+			mockDB.On("Error").Return(nil)
+
+			jsonData, _ := json.Marshal(map[string]uint{"folder_id": tt.folderID})
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Set("userID", uint(1))
+			c.Params = gin.Params{gin.Param{Key: "id", Value: "7"}}
+			c.Request = httptest.NewRequest(http.MethodPost, "/mail/7/move", bytes.NewBuffer(jsonData))
+			c.Request.Header.Set("Content-Type", "application/json")
+
+			service.MoveMail(c)
+
+			assert.Equal(t, tt.code, w.Code)
+			assert.Equal(t, tt.updates, updates)
+		})
+	}
+}
+
+func TestMailService_CreateLabel(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		exists  bool
+		// createErr is what storing the label fails with.
+		createErr error
+		code      int
+		// created is the label stored, nil when none is.
+		created *model.Label
+	}{
+		{"with a color", `{"name": " Important ", "color": "#FF0000"}`, false, nil, http.StatusCreated, &model.Label{UserId: 1, Name: "Important", Color: "#ff0000"}},
+		{"default color", `{"name": "Later"}`, false, nil, http.StatusCreated, &model.Label{UserId: 1, Name: "Later", Color: defaultLabelColor}},
+		{"name taken", `{"name": "Important", "color": "#00ff00"}`, true, nil, http.StatusConflict, nil},
+		{"name taken meanwhile", `{"name": "important"}`, false, gorm.ErrDuplicatedKey, http.StatusConflict, &model.Label{UserId: 1, Name: "important", Color: defaultLabelColor}},
+		{"database error", `{"name": "Later"}`, false, assert.AnError, http.StatusInternalServerError, &model.Label{UserId: 1, Name: "Later", Color: defaultLabelColor}},
+		{"blank name", `{"name": "  "}`, false, nil, http.StatusBadRequest, nil},
+		{"missing name", `{"color": "#000000"}`, false, nil, http.StatusBadRequest, nil},
+		{"name too long", `{"name": "` + strings.Repeat("x", maxLabelNameLength+1) + `"}`, false, nil, http.StatusBadRequest, nil},
+		{"color by name", `{"name": "Bad", "color": "red"}`, false, nil, http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(MockMailDB)
+			service := NewMailService(mockDB, nil)
+
+			var created *model.Label
+			mockDB.On("Model", mock.AnythingOfType("*model.Label")).Return(mockDB)
+			mockDB.On("Select", "count(*) > 0").Return(mockDB)
+			mockDB.On("Where", "user_id = ? AND id <> ? AND lower(name) = lower(?)", uint(1), uint(0), mock.AnythingOfType("string")).Return(mockDB)
+			mockDB.On("Find", mock.AnythingOfType("*bool")).Return(mockDB).Run(func(args mock.Arguments) {
+				*args.Get(0).(*bool) = tt.exists
+			})
+			mockDB.On("Create", mock.AnythingOfType("*model.Label")).Return(mockDB).Run(func(args mock.Arguments) {
+				created = args.Get(0).(*model.Label)
+			})
+			mockDB.On("Error").Return(nil).Once()
+			mockDB.On("Error").Return(tt.createErr)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Set("userID", uint(1))
+			c.Request = httptest.NewRequest(http.MethodPost, "/mail/labels", bytes.NewBufferString(tt.payload))
+			c.Request.Header.Set("Content-Type", "application/json")
+
+			service.CreateLabel(c)
+
+			assert.Equal(t, tt.code, w.Code)
+			if tt.created == nil {
+				assert.Nil(t, created)
+				return
+			}
+			if assert.NotNil(t, created) {
+				assert.Equal(t, *tt.created, *created)
+			}
+		})
+	}
+}
@@ -0,0 +1,361 @@
+package service
+
+import (
+	"backend/internal/model"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	maxLabelNameLength = 64
+	defaultLabelColor  = "#808080"
+)
+
+var labelColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+var errLabelExists = errors.New("Label already exists")
+
+// labelInput creates a label or changes the given fields of one.
+type labelInput struct {
+	Name  *string `json:"name"`
+	Color *string `json:"color"`
+}
+
+// labelMailInput adds labels to and removes labels from a mail.
+type labelMailInput struct {
+	Add    []uint `json:"add"`
+	Remove []uint `json:"remove"`
+}
+
+// apply copies the given fields of in into label. It reports false if one
+// of them is invalid.
+func (in labelInput) apply(label *model.Label) bool {
+	if in.Name != nil {
+		name := strings.TrimSpace(*in.Name)
+		if name == "" || utf8.RuneCountInString(name) > maxLabelNameLength {
+			return false
+		}
+		label.Name = name
+	}
+	if in.Color != nil {
+		if !labelColorPattern.MatchString(*in.Color) {
+			return false
+		}
+		label.Color = strings.ToLower(*in.Color)
+	}
+	return true
+}
+
+func (ms *mailService) GetLabels(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	var labels []model.Label
+	if err := ms.db.Where("user_id = ?", userID).Order("name").Find(&labels).Error(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error fetching labels"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"labels": labels})
+}
+
+func (ms *mailService) CreateLabel(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	var input labelInput
+	if err := c.ShouldBindJSON(&input); err != nil || input.Name == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid input"})
+		return
+	}
+
+	label := model.Label{UserId: userID, Color: defaultLabelColor}
+	if !input.apply(&label) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid label name or color"})
+		return
+	}
+
+	if status, err := ms.checkLabelName(label); err != nil {
+		c.JSON(status, gin.H{"message": err.Error()})
+		return
+	}
+
+	if err := ms.db.Create(&label).Error(); errors.Is(err, gorm.ErrDuplicatedKey) {
+		// Another request created the same name since it was checked.
+		c.JSON(http.StatusConflict, gin.H{"message": errLabelExists.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error creating label"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, label)
+}
+
+func (ms *mailService) UpdateLabel(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	labelID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid labelID"})
+		return
+	}
+
+	var input labelInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid input"})
+		return
+	}
+
+	var label model.Label
+	if err := ms.db.Where("id = ? AND user_id = ?", labelID, userID).First(&label).Error(); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Label not found"})
+		return
+	}
+
+	if !input.apply(&label) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid label name or color"})
+		return
+	}
+
+	if status, err := ms.checkLabelName(label); err != nil {
+		c.JSON(status, gin.H{"message": err.Error()})
+		return
+	}
+
+	if err := ms.db.Model(&model.Label{}).
+		Where("id = ?", label.Id).
+		Updates(map[string]interface{}{"name": label.Name, "color": label.Color}).Error(); errors.Is(err, gorm.ErrDuplicatedKey) {
+		c.JSON(http.StatusConflict, gin.H{"message": errLabelExists.Error()})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error updating label"})
+		return
+	}
+
+	c.JSON(http.StatusOK, label)
+}
+
+// DeleteLabel removes a label and takes it off every mail carrying it.
+func (ms *mailService) DeleteLabel(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	labelID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid labelID"})
+		return
+	}
+
+	err = ms.db.Transaction(func(tx model.MailDB) error {
+		res := tx.Where("id = ? AND user_id = ?", labelID, userID).Delete(&model.Label{})
+		if err := res.Error(); err != nil {
+			return err
+		}
+		if res.RowsAffected() == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Where("label_id = ?", labelID).Delete(&model.MailboxLabel{}).Error()
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Label not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error deleting label"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+func (ms *mailService) GetLabelMails(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	labelID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid labelID"})
+		return
+	}
+
+	var page mailPage
+	if err := c.ShouldBindQuery(&page); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid query"})
+		return
+	}
+	if err := page.normalize(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	var label model.Label
+	if err := ms.db.Where("id = ? AND user_id = ?", labelID, userID).First(&label).Error(); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Label not found"})
+		return
+	}
+
+	query := ms.db.Preload("Attachments").Preload("Recipients").
+		Where("EXISTS (SELECT 1 FROM mailboxes JOIN mailbox_labels ON mailbox_labels.mailbox_id = mailboxes.id"+
+			" WHERE mailboxes.mail_id = mails.id AND mailboxes.user_id = ? AND mailboxes.folder IN ? AND mailbox_labels.label_id = ?)",
+			userID, visibleFolders, labelID)
+	ms.listMails(c, userID, page, query)
+}
+
+// LabelMail adds and removes labels on the user's copy of a mail.
+func (ms *mailService) LabelMail(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	mailID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid mailID"})
+		return
+	}
+
+	var input labelMailInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid input"})
+		return
+	}
+	changed := append(append([]uint{}, input.Add...), input.Remove...)
+	if len(changed) == 0 {
+		c.JSON(http.StatusOK, gin.H{})
+		return
+	}
+
+	var labels []model.Label
+	if err := ms.db.Where("user_id = ? AND id IN ?", userID, changed).Find(&labels).Error(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error fetching labels"})
+		return
+	}
+	owned := make(map[uint]bool, len(labels))
+	for _, label := range labels {
+		owned[label.Id] = true
+	}
+	for _, id := range changed {
+		if !owned[id] {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Label not found"})
+			return
+		}
+	}
+
+	err = ms.db.Transaction(func(tx model.MailDB) error {
+		boxes, err := lockVisibleMailboxes(tx, userID, uint(mailID))
+		if err != nil {
+			return err
+		}
+		if len(boxes) == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		boxIDs := make([]uint, 0, len(boxes))
+		for _, box := range boxes {
+			boxIDs = append(boxIDs, box.ID)
+		}
+
+		if err := tx.Where("mailbox_id IN ? AND label_id IN ?", boxIDs, changed).
+			Delete(&model.MailboxLabel{}).Error(); err != nil {
+			return err
+		}
+		if len(input.Add) == 0 {
+			return nil
+		}
+
+		removed := make(map[uint]bool, len(input.Remove))
+		for _, id := range input.Remove {
+			removed[id] = true
+		}
+		var links []model.MailboxLabel
+		added := make(map[uint]bool, len(input.Add))
+		for _, id := range input.Add {
+			if removed[id] || added[id] {
+				continue
+			}
+			added[id] = true
+			for _, boxID := range boxIDs {
+				links = append(links, model.MailboxLabel{MailboxId: boxID, LabelId: id})
+			}
+		}
+		if len(links) == 0 {
+			return nil
+		}
+		return tx.Create(&links).Error()
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Mail not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error labelling mail"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// checkLabelName rejects a label whose name another label of the same
+// user already has.
+func (ms *mailService) checkLabelName(label model.Label) (int, error) {
+	var exists bool
+	if err := ms.db.Model(&model.Label{}).
+		Select("count(*) > 0").
+		Where("user_id = ? AND id <> ? AND lower(name) = lower(?)", label.UserId, label.Id, label.Name).
+		Find(&exists).Error(); err != nil {
+		return http.StatusInternalServerError, errors.New("Error checking label")
+	}
+	if exists {
+		return http.StatusConflict, errLabelExists
+	}
+	return http.StatusOK, nil
+}
+
+// listMails replies with one page of query, a mails query that may span
+// folders, hiding Bcc recipients the user is not allowed to see.
+func (ms *mailService) listMails(c *gin.Context, userID uint, page mailPage, query model.MailDB) {
+	var mails []model.Mail
+	if err := page.apply(query).Find(&mails).Error(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error fetching mails"})
+		return
+	}
+	mails, next := page.trim(mails)
+
+	if err := ms.hideBcc(userID, mails); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error fetching mails"})
+		return
+	}
+	if err := ms.attachUserState(userID, mails); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error fetching mails"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"mails": mails, "next_cursor": next})
+}
+
+// attachLabels fills in the labels userID put on each of mails.
+func (ms *mailService) attachLabels(userID uint, mails []model.Mail) error {
+	if len(mails) == 0 {
+		return nil
+	}
+	ids := make([]uint, 0, len(mails))
+	for _, mail := range mails {
+		ids = append(ids, mail.ID)
+	}
+
+	var rows []struct {
+		MailId  uint
+		LabelId uint
+	}
+	if err := ms.db.Model(&model.MailboxLabel{}).
+		Select("DISTINCT mailboxes.mail_id, mailbox_labels.label_id").
+		Joins("JOIN mailboxes ON mailboxes.id = mailbox_labels.mailbox_id").
+		Where("mailboxes.user_id = ? AND mailboxes.mail_id IN ?", userID, ids).
+		Order("mailbox_labels.label_id").
+		Find(&rows).Error(); err != nil {
+		return err
+	}
+
+	byMail := make(map[uint][]uint, len(mails))
+	for _, row := range rows {
+		byMail[row.MailId] = append(byMail[row.MailId], row.LabelId)
+	}
+	for i := range mails {
+		mails[i].Labels = byMail[mails[i].ID]
+	}
+	return nil
+}
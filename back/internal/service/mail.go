@@ -3,163 +3,200 @@ package service
 import (
 	"backend/internal/model"
 	"backend/utils"
-	"encoding/base64"
 	"encoding/json"
-	"fmt"
-	"log"
+	"errors"
 	"net/http"
-	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/kyroy/go-slices/int64s"
-)
-
-const (
-	domain = "gomail.kurs"
 )
 
 type (
 	MailService interface {
 		GetInboxMails(c *gin.Context)
 		GetSentMails(c *gin.Context)
+		SearchMails(c *gin.Context)
+		GetMail(c *gin.Context)
+		SetFlags(c *gin.Context)
+		GetThreads(c *gin.Context)
+		GetThread(c *gin.Context)
 		SendMail(c *gin.Context)
+		ReplyMail(c *gin.Context)
+		ReplyAllMail(c *gin.Context)
+		ForwardMail(c *gin.Context)
+		GetDrafts(c *gin.Context)
+		GetDraft(c *gin.Context)
+		GetDraftRevisions(c *gin.Context)
+		GetDraftAttachment(c *gin.Context)
+		CreateDraft(c *gin.Context)
+		UpdateDraft(c *gin.Context)
+		DeleteDraft(c *gin.Context)
+		SendDraft(c *gin.Context)
+		GetFolders(c *gin.Context)
+		CreateFolder(c *gin.Context)
+		UpdateFolder(c *gin.Context)
+		DeleteFolder(c *gin.Context)
+		GetFolderMails(c *gin.Context)
+		MoveMail(c *gin.Context)
+		GetLabels(c *gin.Context)
+		CreateLabel(c *gin.Context)
+		UpdateLabel(c *gin.Context)
+		DeleteLabel(c *gin.Context)
+		GetLabelMails(c *gin.Context)
+		LabelMail(c *gin.Context)
+		BulkMails(c *gin.Context)
+		GetScheduled(c *gin.Context)
+		RescheduleMail(c *gin.Context)
+		CancelScheduled(c *gin.Context)
+		UndoSend(c *gin.Context)
+		GetSettings(c *gin.Context)
+		UpdateSettings(c *gin.Context)
+		GetAttachment(c *gin.Context)
+		GetArchive(c *gin.Context)
 		GetTrash(c *gin.Context)
+		RestoreMail(c *gin.Context)
+		EmptyTrash(c *gin.Context)
 		UnArchiveMail(c *gin.Context)
 		ArchiveMail(c *gin.Context)
 		DeleteMail(c *gin.Context)
 	}
 
 	mailService struct {
-		db model.MailDB
+		db    model.MailDB
+		store *utils.AttachmentStore
 	}
 )
 
-func NewMailService(db model.MailDB) MailService {
+func NewMailService(db model.MailDB, store *utils.AttachmentStore) MailService {
 	return &mailService{
-		db: db,
+		db:    db,
+		store: store,
 	}
 }
 
 func (ms *mailService) GetInboxMails(c *gin.Context) {
 	userID := c.MustGet("userID").(uint)
 
-	var user model.User
-	if err := ms.db.Where("id = ?", userID).First(&user).Error(); err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"message": "Invalid email"})
+	var page mailPage
+	if err := c.ShouldBindQuery(&page); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid query"})
+		return
+	}
+	if err := page.normalize(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
 		return
 	}
 
 	var mails []model.Mail
-	if err := ms.db.Find(&mails).Error(); err != nil {
+	if err := page.apply(ms.folderMails(userID, model.FolderInbox)).Find(&mails).Error(); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error fetching mails"})
 		return
 	}
 
-	newMails := make([]model.Mail, 0, len(mails))
-	for _, mail := range mails {
-		if check, err := ms.checkEmailStat(userID, mail.ID); err != nil || !check {
-			continue
-		}
-
-		var receivers map[string]interface{}
-		if err := json.Unmarshal(mail.Receivers.Bytes, &receivers); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"message": "Error decoding receivers 1"})
-			return
-		}
-
-		decodedBytes, err := base64.StdEncoding.DecodeString(receivers["Bytes"].(string))
-		if err != nil {
-			log.Println("Error decoding Base64:", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"message": "Error decoding Base64"})
-			return
-		}
-
-		var recs1 []string
-		var recs2 string
-		if err := json.Unmarshal(decodedBytes, &recs1); err != nil {
-			if err := json.Unmarshal(decodedBytes, &recs2); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"message": "Error decoding receivers 2"})
-				return
-			}
-		}
+	mails, next := page.trim(mails)
+	if err := ms.attachUserState(userID, mails); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error fetching mails"})
+		return
+	}
 
-		if slices.Contains(recs1, user.Email) || strings.Contains(recs2, user.Email) {
-			newMails = append(newMails, mail)
-		}
+	unread, err := ms.unreadCount(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error counting unread mails"})
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"mails": newMails})
+	c.JSON(http.StatusOK, gin.H{"mails": mails, "next_cursor": next, "unread": unread})
 }
 
 func (ms *mailService) GetSentMails(c *gin.Context) {
 	userID := c.MustGet("userID").(uint)
 
-	var user model.User
-	if err := ms.db.Where("id = ?", userID).First(&user).Error(); err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"message": "Invalid credentials"})
+	var page mailPage
+	if err := c.ShouldBindQuery(&page); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid query"})
+		return
+	}
+	if err := page.normalize(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
 		return
 	}
 
 	var mails []model.Mail
-	if err := ms.db.Where("sender = ?", user.Email).Find(&mails).Error(); err != nil {
+	if err := page.apply(ms.folderMails(userID, model.FolderSent)).Find(&mails).Error(); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error fetching sent mails"})
 		return
 	}
+	mails, next := page.trim(mails)
+	if err := ms.attachUserState(userID, mails); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error fetching sent mails"})
+		return
+	}
+
+	deliveries, err := ms.mailDeliveries(mails)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error fetching delivery status"})
+		return
+	}
 
 	responseMails := make([]map[string]interface{}, 0, len(mails))
 	for _, mail := range mails {
-		if check, err := ms.checkEmailStat(userID, mail.ID); err != nil || !check {
-			continue
-		}
-
-		var receivers map[string]interface{}
-		if err := json.Unmarshal(mail.Receivers.Bytes, &receivers); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"message": fmt.Sprintf("Error decoding receivers1: %v", err)})
-			return
-		}
-
-		decodedBytes, err := base64.StdEncoding.DecodeString(receivers["Bytes"].(string))
-		if err != nil {
-			log.Println("Error decoding Base64:", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"message": "Error decoding Base64"})
-			return
-		}
-
-		var recs []string
-		if err := json.Unmarshal(decodedBytes, &recs); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"message": fmt.Sprintf("Error decoding receivers2: %v", err)})
-			return
-		}
+		to := toAddresses(mail)
+		cc := utils.RecipientAddresses(mail.Recipients, model.RecipientCc)
+		receivers, _ := json.Marshal(append(append([]string{}, to...), cc...))
 
 		responseMails = append(responseMails, map[string]interface{}{
-			"ID":        mail.ID,
-			"Sender":    mail.Sender,
-			"Receivers": string(decodedBytes),
-			"Subject":   mail.Subject,
-			"Body":      mail.Body,
-			"CreatedAt": mail.CreatedAt,
+			"ID":          mail.ID,
+			"Sender":      mail.Sender,
+			"Receivers":   string(receivers),
+			"To":          to,
+			"Cc":          cc,
+			"Bcc":         utils.RecipientAddresses(mail.Recipients, model.RecipientBcc),
+			"Subject":     mail.Subject,
+			"Body":        mail.Body,
+			"HTMLBody":    mail.HTMLBody,
+			"Attachments": mail.Attachments,
+			"Deliveries":  deliveries[mail.ID],
+			"Labels":      mail.Labels,
+			"Flags":       mail.Flags,
+			"CreatedAt":   mail.CreatedAt,
 		})
 	}
 
-	c.JSON(http.StatusOK, gin.H{"mails": responseMails})
+	c.JSON(http.StatusOK, gin.H{"mails": responseMails, "next_cursor": next})
 }
 
 func (ms *mailService) SendMail(c *gin.Context) {
 	userID := c.MustGet("userID").(uint)
 
-	var mailData struct {
-		Receivers []string `json:"receivers"`
-		Subject   string   `json:"subject"`
-		Body      string   `json:"body"`
+	var mailData sendInput
+	if err := c.ShouldBind(&mailData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid input"})
+		return
+	}
+	if mailData.SendAt != nil && !mailData.SendAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "send_at must be in the future"})
+		return
 	}
 
-	if err := c.ShouldBindJSON(&mailData); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid input"})
+	out, ok := mailData.outgoing()
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid receiver address"})
+		return
+	}
+	if len(out.To)+len(out.Cc)+len(out.Bcc) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "No receivers"})
+		return
+	}
+
+	attachments, status, err := ms.saveUploads(c)
+	if err != nil {
+		c.JSON(status, gin.H{"message": err.Error()})
 		return
 	}
+	out.Attachments = attachments
+	out.SendAt = mailData.SendAt
 
 	var user model.User
 	if err := ms.db.Where("id = ?", userID).First(&user).Error(); err != nil {
@@ -167,98 +204,216 @@ func (ms *mailService) SendMail(c *gin.Context) {
 		return
 	}
 
-	mail := model.Mail{
-		Sender:  user.Email,
-		Subject: mailData.Subject,
-		Body:    mailData.Body,
+	mail, err := ms.sendMail(ms.db, user, out)
+	if err != nil {
+		replySendError(c, err)
+		return
 	}
 
-	var filtered []string
-	for _, rec := range mailData.Receivers {
-		if !strings.Contains(rec, domain) {
-			filtered = append(filtered, rec)
-		}
-	}
+	c.JSON(http.StatusCreated, sentMail(mail))
+}
 
-	if err := utils.SendMailSMTP(mail, filtered); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"message": fmt.Sprintf("Error sending email through SMTP: %v", err)})
+// replySendError replies to a mail that could not be sent. A full mailbox
+// is for the user to sort out; anything else is an error of ours.
+func replySendError(c *gin.Context, err error) {
+	if errors.Is(err, utils.ErrQuotaExceeded) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"message": err.Error()})
 		return
 	}
-	mail.Receivers.Set(mailData.Receivers)
+	c.JSON(http.StatusInternalServerError, gin.H{"message": "Error sending mail"})
+}
 
-	if err := ms.db.Create(&mail).Error(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error sending mail"})
-		return
+// sentMail is the reply of the endpoints that send mail. A mail held back
+// until later comes with the time it goes out, before which it can still
+// be cancelled.
+func sentMail(mail model.Mail) gin.H {
+	if mail.SendAt != nil {
+		return gin.H{"id": mail.ID, "send_at": mail.SendAt}
 	}
+	return gin.H{"id": mail.ID}
+}
 
-	c.JSON(http.StatusCreated, gin.H{})
+// sendInput is the payload of SendMail, which can also schedule the mail.
+type sendInput struct {
+	composeInput
+	// SendAt schedules the mail instead of sending it right away. It is
+	// given with its time zone, as in RFC 3339.
+	SendAt *time.Time `json:"send_at" form:"send_at"`
 }
 
-func (ms *mailService) GetTrash(c *gin.Context) {
-	userID := c.MustGet("userID").(uint)
+// composeInput is the payload of the endpoints that send mail.
+type composeInput struct {
+	To  []string `json:"to" form:"to"`
+	Cc  []string `json:"cc" form:"cc"`
+	Bcc []string `json:"bcc" form:"bcc"`
+	// Receivers is the former name of To, still accepted from older clients.
+	Receivers []string `json:"receivers" form:"receivers"`
+	Subject   string   `json:"subject" form:"subject"`
+	Body      string   `json:"body" form:"body"`
+	HTMLBody  string   `json:"html_body" form:"html_body"`
+}
 
-	type resp struct {
-		ID        int
-		Subject   string
-		Body      string
-		CreatedAt time.Time
+// outgoingMail is a mail about to be sent, addressed with bare addresses.
+type outgoingMail struct {
+	To, Cc, Bcc []string
+	Subject     string
+	Body        string
+	HTMLBody    string
+	InReplyTo   string
+	References  string
+	Attachments []model.Attachment
+	// SendAt, when set, schedules the mail rather than sending it now.
+	SendAt *time.Time
+	// SourceMailId and SourceFlag mark a reply or forward, whose source
+	// mail gets the flag once this one is sent.
+	SourceMailId *uint
+	SourceFlag   string
+}
+
+// outgoing parses the addresses of in. It reports false if any is invalid.
+func (in composeInput) outgoing() (outgoingMail, bool) {
+	to, okTo := normalizeAddresses(append(in.To, in.Receivers...))
+	cc, okCc := normalizeAddresses(in.Cc)
+	bcc, okBcc := normalizeAddresses(in.Bcc)
+
+	return outgoingMail{
+		To:       to,
+		Cc:       cc,
+		Bcc:      bcc,
+		Subject:  in.Subject,
+		Body:     in.Body,
+		HTMLBody: in.HTMLBody,
+	}, okTo && okCc && okBcc
+}
+
+// sendMail stores out as a mail from user, delivers it to the local
+// recipients and queues it for the external ones. It runs on db, so a
+// caller can make it part of a larger transaction.
+func (ms *mailService) sendMail(db model.MailDB, user model.User, out outgoingMail) (model.Mail, error) {
+	recipients := utils.NewRecipients(model.RecipientTo, out.To)
+	recipients = append(recipients, utils.NewRecipients(model.RecipientCc, out.Cc)...)
+	recipients = append(recipients, utils.NewRecipients(model.RecipientBcc, out.Bcc)...)
+
+	mail := model.Mail{
+		MessageId:    utils.NewMessageID(utils.AddressDomain(user.Email)),
+		InReplyTo:    out.InReplyTo,
+		References:   out.References,
+		Sender:       user.Email,
+		Subject:      out.Subject,
+		Body:         out.Body,
+		Recipients:   recipients,
+		Attachments:  out.Attachments,
+		SourceMailId: out.SourceMailId,
+		SourceFlag:   out.SourceFlag,
+	}
+	if out.HTMLBody != "" {
+		mail.HTMLBody = utils.SanitizeHTML(out.HTMLBody)
+		if mail.Body == "" {
+			mail.Body = utils.HTMLToText(out.HTMLBody)
+		}
 	}
-	var resps []resp
 
-	var tr model.Trash
-	if err := ms.db.Where("user_id = ?", userID).First(&tr).Error(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "Archived mails not found"})
-		return
+	mail.Receivers.Set(append(append([]string{}, out.To...), out.Cc...))
+
+	// The sender keeps a copy, which has to fit in their quota.
+	if err := utils.CheckQuota(db, user, utils.MailSize(&mail)); err != nil {
+		return mail, err
 	}
 
-	for _, v := range tr.Archived {
-		var mail model.Mail
-		if err := ms.db.Where("id = ?", v).Find(&mail).Error(); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"message": "Error get mail for trash"})
-			return
-		}
+	// A mail sent right away is still held back for the user's undo
+	// window, the same way as a scheduled one, but shows in the sent
+	// folder rather than among the scheduled mails.
+	mail.SendAt = out.SendAt
+	folder := model.FolderScheduled
+	if mail.SendAt == nil && user.UndoSendSeconds > 0 {
+		sendAt := time.Now().Add(time.Duration(user.UndoSendSeconds) * time.Second)
+		mail.SendAt = &sendAt
+		folder = model.FolderSent
+	}
 
-		resps = append(resps, resp{ID: int(mail.ID), Subject: mail.Subject, Body: mail.Body, CreatedAt: mail.CreatedAt})
+	// Recipients of a scheduled mail are resolved when it is sent, as
+	// their accounts may change until then.
+	if mail.SendAt != nil {
+		err := utils.ScheduleMail(db, &mail, user.Id, folder)
+		return mail, err
 	}
 
-	c.JSON(http.StatusOK, gin.H{"mails": resps})
+	all := append(append(append([]string{}, out.To...), out.Cc...), out.Bcc...)
+	local, external, err := utils.ResolveRecipients(db, all)
+	if err != nil {
+		return mail, err
+	}
+
+	if err := utils.StoreMail(db, &mail, user.Id, local, external); err != nil {
+		return mail, err
+	}
+	return mail, nil
 }
 
-func (ms *mailService) UnArchiveMail(c *gin.Context) {
+func (ms *mailService) GetArchive(c *gin.Context) {
+	ms.listFolder(c, model.FolderArchive)
+}
+
+func (ms *mailService) GetTrash(c *gin.Context) {
+	ms.listFolder(c, model.FolderTrash)
+}
+
+// listFolder replies with a page of the mails the user keeps in folder,
+// which may hold both sent and received mails.
+func (ms *mailService) listFolder(c *gin.Context, folder string) {
 	userID := c.MustGet("userID").(uint)
-	mailID, _ := strconv.Atoi(c.Param("id"))
 
-	var tr model.Trash
-	if err := ms.db.Where("user_id = ?", userID).First(&tr).Error(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "Archived mails not found"})
+	var page mailPage
+	if err := c.ShouldBindQuery(&page); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid query"})
 		return
 	}
+	if err := page.normalize(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	query := ms.db.Preload("Attachments").Preload("Recipients").
+		Where(visibleMailsQuery, userID, []string{folder})
+	ms.listMails(c, userID, page, query)
+}
 
-	mailIdx := int64s.IndexOf(tr.Archived, int64(mailID))
-	if err := ms.db.Model(&model.Trash{}).
-		Where("user_id = ?", userID).
-		Update("archived", append(tr.Archived[:mailIdx], tr.Archived[mailIdx+1:]...)).Error(); err != nil {
+func (ms *mailService) UnArchiveMail(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	mailID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid mailID"})
 		return
 	}
 
+	unarchived, err := unarchiveMailbox(ms.db, userID, uint(mailID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error unarchiving mail"})
+		return
+	}
+	if unarchived == 0 {
+		ms.replyUnchanged(c, userID, uint(mailID), "Mail is not archived")
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{})
 }
 
 func (ms *mailService) ArchiveMail(c *gin.Context) {
 	userID := c.MustGet("userID").(uint)
-	mailID, _ := strconv.Atoi(c.Param("id"))
-
-	var tr model.Trash
-	if err := ms.db.Where("user_id = ?", userID).First(&tr).Error(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "Archived mails not found"})
+	mailID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid mailID"})
 		return
 	}
 
-	if err := ms.db.Model(&model.Trash{}).
-		Where("user_id = ?", userID).
-		Update("archived", append(tr.Archived, int64(mailID))).Error(); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid mailID"})
+	archived, err := archiveMailbox(ms.db, userID, uint(mailID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error archiving mail"})
+		return
+	}
+	if archived == 0 {
+		ms.replyUnchanged(c, userID, uint(mailID), "Mail is already archived or in the trash")
 		return
 	}
 
@@ -267,45 +422,135 @@ func (ms *mailService) ArchiveMail(c *gin.Context) {
 
 func (ms *mailService) DeleteMail(c *gin.Context) {
 	userID := c.MustGet("userID").(uint)
-	mailID, _ := strconv.Atoi(c.Param("id"))
+	mailID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid mailID"})
+		return
+	}
 
-	var tr model.Trash
-	if err := ms.db.Where("user_id = ?", userID).First(&tr).Error(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "Archived mails not found"})
+	// A mail is moved to the trash first and deleted for good only when
+	// it is deleted from the trash.
+	deleted, err := trashMailbox(ms.db, userID, uint(mailID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error deleting mail"})
+		return
+	}
+	if deleted == 0 {
+		ms.replyUnchanged(c, userID, uint(mailID), "Mail is scheduled")
 		return
 	}
 
-	mailIdx := int64s.IndexOf(tr.Archived, int64(mailID))
-	if err := ms.db.Model(&model.Trash{}).
-		Where("user_id = ?", userID).
-		Update("archived", append(tr.Archived[:mailIdx], tr.Archived[mailIdx+1:]...)).Error(); err != nil {
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// RestoreMail takes a mail out of the trash, back to the inbox or sent
+// folder it arrived in.
+func (ms *mailService) RestoreMail(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	mailID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid mailID"})
 		return
 	}
 
-	if err := ms.db.Model(&model.Trash{}).
-		Where("user_id = ?", userID).
-		Update("deleted", append(tr.Deleted, int64(mailID))).Error(); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid mailID"})
+	restored, err := restoreMailbox(ms.db, userID, uint(mailID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error restoring mail"})
+		return
+	}
+	if restored == 0 {
+		ms.replyUnchanged(c, userID, uint(mailID), "Mail is not in the trash")
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{})
 }
 
-func (ms *mailService) checkEmailStat(userID, mailID uint) (bool, error) {
-	var tr model.Trash
-	if err := ms.db.Model(&model.Trash{}).Where("user_id = ?", userID).First(&tr).Error(); err != nil {
-		return false, err
+// EmptyTrash deletes every mail in the user's trash for good.
+func (ms *mailService) EmptyTrash(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	res := ms.db.Model(&model.Mailbox{}).
+		Where("user_id = ? AND folder = ?", userID, model.FolderTrash).
+		Update("folder", model.FolderDeleted)
+	if err := res.Error(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error emptying trash"})
+		return
 	}
 
-	if int64s.Contains(tr.Archived, int64(mailID)) {
-		log.Printf("mails %d is archived", mailID)
-		return false, nil
-	} else if int64s.Contains(tr.Deleted, int64(mailID)) {
-		log.Printf("mails %d is deleted", mailID)
-		return false, nil
+	c.JSON(http.StatusOK, gin.H{"deleted": res.RowsAffected()})
+}
+
+// replyUnchanged answers a state change that changed no copy of mailID:
+// with a conflict when the user holds the mail in a state the change
+// does not apply to, and as not found when they do not hold it at all.
+func (ms *mailService) replyUnchanged(c *gin.Context, userID, mailID uint, conflict string) {
+	held, err := holdsMail(ms.db, userID, mailID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error fetching mail"})
+		return
 	}
+	if held {
+		c.JSON(http.StatusConflict, gin.H{"message": conflict})
+		return
+	}
+	c.JSON(http.StatusNotFound, gin.H{"message": "Mail not found"})
+}
 
-	return true, nil
+// mailDeliveries returns the external delivery status of mails by mail ID.
+func (ms *mailService) mailDeliveries(mails []model.Mail) (map[uint][]model.Delivery, error) {
+	byMail := make(map[uint][]model.Delivery, len(mails))
+	if len(mails) == 0 {
+		return byMail, nil
+	}
+
+	ids := make([]uint, 0, len(mails))
+	for _, mail := range mails {
+		ids = append(ids, mail.ID)
+	}
+
+	var deliveries []model.Delivery
+	if err := ms.db.Where("mail_id IN ?", ids).Order("id").Find(&deliveries).Error(); err != nil {
+		return nil, err
+	}
+	for _, d := range deliveries {
+		byMail[d.MailId] = append(byMail[d.MailId], d)
+	}
+	return byMail, nil
+}
+
+// normalizeAddresses parses every non-blank entry of addrs into a bare
+// address, dropping duplicates. It reports false if any entry is invalid.
+func normalizeAddresses(addrs []string) ([]string, bool) {
+	var out []string
+	seen := make(map[string]bool)
+	for _, addr := range addrs {
+		if strings.TrimSpace(addr) == "" {
+			continue
+		}
+		parsed, ok := utils.ParseAddress(addr)
+		if !ok {
+			return nil, false
+		}
+		if !seen[parsed] {
+			seen[parsed] = true
+			out = append(out, parsed)
+		}
+	}
+	return out, true
+}
+
+// folderMails scopes a query to the mails that userID currently keeps in
+// folder. Bcc recipients are only loaded for the sent folder, which holds
+// nothing but the user's own mails.
+func (ms *mailService) folderMails(userID uint, folder string) model.MailDB {
+	db := ms.db.Select("mails.*").Preload("Attachments")
+	if folder == model.FolderSent {
+		db = db.Preload("Recipients")
+	} else {
+		db = db.Preload("Recipients", "type <> ?", model.RecipientBcc)
+	}
+	return db.
+		Joins("JOIN mailboxes ON mailboxes.mail_id = mails.id").
+		Where("mailboxes.user_id = ? AND mailboxes.folder = ?", userID, folder)
 }
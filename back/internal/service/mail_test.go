@@ -1,6 +1,8 @@
 package service
 
 import (
+	"backend/internal/model"
+	"backend/utils"
 	"bytes"
 	"encoding/json"
 	"math/rand"
@@ -15,6 +17,8 @@ import (
 	"github.com/stretchr/testify/mock"
 )
 
+const testDomain = "gomail.kurs"
+
 func FuzzMailService_GetInboxMails(f *testing.F) {
 	rand.Seed(time.Now().UnixNano())
 	f.Add(uint(1))
@@ -23,15 +27,23 @@ func FuzzMailService_GetInboxMails(f *testing.F) {
 
 	f.Fuzz(func(t *testing.T, userID uint) {
 		mockDB := new(MockMailDB)
-		service := NewMailService(mockDB)
-
-		mockDB.On("Where", "id = ?", userID).Return(mockDB)
-		mockDB.On("First", mock.AnythingOfType("*model.User")).Return(mockDB)
+		service := NewMailService(mockDB, nil)
+
+		mockDB.On("Select", "mails.*").Return(mockDB)
+		mockDB.On("Preload", "Attachments").Return(mockDB)
+		mockDB.On("Preload", "Recipients", "type <> ?", model.RecipientBcc).Return(mockDB)
+		mockDB.On("Joins", "JOIN mailboxes ON mailboxes.mail_id = mails.id").Return(mockDB)
+		mockDB.On("Where", "mailboxes.user_id = ? AND mailboxes.folder = ?", userID, model.FolderInbox).Return(mockDB)
+		mockDB.On("Order", "mails.created_at desc, mails.id desc").Return(mockDB)
+		mockDB.On("Limit", defaultPageLimit+1).Return(mockDB)
+		mockDB.On("Find", mock.AnythingOfType("*[]model.Mail")).Return(mockDB)
+		mockDB.On("Model", mock.AnythingOfType("*model.Mailbox")).Return(mockDB).Maybe()
+		mockDB.On("Select", "count(*)").Return(mockDB).Maybe()
+		mockDB.On("Where", "user_id = ? AND folder = ? AND seen = ?", userID, model.FolderInbox, false).Return(mockDB).Maybe()
+		mockDB.On("Find", mock.AnythingOfType("*int64")).Return(mockDB).Maybe()
 
 		if rand.Intn(2) == 0 {
 			mockDB.On("Error").Return(nil)
-			mockDB.On("Find", mock.AnythingOfType("*[]model.Mail")).Return(mockDB)
-			mockDB.On("Error").Return(nil)
 		} else {
 			mockDB.On("Error").Return(assert.AnError)
 		}
@@ -39,6 +51,7 @@ func FuzzMailService_GetInboxMails(f *testing.F) {
 		w := httptest.NewRecorder()
 		c, _ := gin.CreateTestContext(w)
 		c.Set("userID", userID)
+		c.Request = httptest.NewRequest(http.MethodGet, "/inbox", nil)
 
 		service.GetInboxMails(c)
 
@@ -60,7 +73,7 @@ func FuzzMailService_SendMail(f *testing.F) {
 
 	f.Fuzz(func(t *testing.T, userID uint, receiver, subject, body string) {
 		mockDB := new(MockMailDB)
-		service := NewMailService(mockDB)
+		service := NewMailService(mockDB, nil)
 
 		mockDB.On("Where", "id = ?", userID).Return(mockDB)
 		mockDB.On("First", mock.AnythingOfType("*model.User")).Return(mockDB)
@@ -68,7 +81,14 @@ func FuzzMailService_SendMail(f *testing.F) {
 		if rand.Intn(2) == 0 {
 			mockDB.On("Error").Return(nil)
 			mockDB.On("Create", mock.AnythingOfType("*model.Mail")).Return(mockDB)
-			mockDB.On("Error").Return(nil)
+			mockDB.On("Where", "name IN ?", mock.Anything).Return(mockDB)
+			mockDB.On("Find", mock.AnythingOfType("*[]model.Domain")).Return(mockDB).Run(func(args mock.Arguments) {
+				*args.Get(0).(*[]model.Domain) = []model.Domain{{Name: testDomain}}
+			})
+			mockDB.On("Where", "email IN ?", mock.Anything).Return(mockDB)
+			mockDB.On("Find", mock.AnythingOfType("*[]model.User")).Return(mockDB)
+			mockDB.On("Create", mock.AnythingOfType("*[]model.Mailbox")).Return(mockDB)
+			mockDB.On("Create", mock.AnythingOfType("*[]model.Delivery")).Return(mockDB)
 		} else {
 			mockDB.On("Error").Return(assert.AnError)
 		}
@@ -95,6 +115,66 @@ func FuzzMailService_SendMail(f *testing.F) {
 			http.StatusInternalServerError,
 		}
 		assert.Contains(t, validCodes, w.Code, "unexpected status code")
+
+		addr, ok := utils.ParseAddress(receiver)
+		if w.Code == http.StatusCreated && ok && utils.AddressDomain(addr) != testDomain {
+			mockDB.AssertCalled(t, "Create", mock.AnythingOfType("*[]model.Delivery"))
+		}
+	})
+}
+
+func FuzzMailService_SendMailBcc(f *testing.F) {
+	f.Add("test2@gomail.kurs", "boss@example.com", "secret@example.com")
+	f.Add("friend@example.com", "", "test3@gomail.kurs")
+
+	f.Fuzz(func(t *testing.T, to, cc, bcc string) {
+		mockDB := new(MockMailDB)
+		service := NewMailService(mockDB, nil)
+
+		var created *model.Mail
+		mockDB.On("Where", "id = ?", uint(1)).Return(mockDB)
+		mockDB.On("First", mock.AnythingOfType("*model.User")).Return(mockDB)
+		mockDB.On("Where", mock.Anything, mock.Anything).Return(mockDB)
+		mockDB.On("Find", mock.Anything).Return(mockDB)
+		mockDB.On("Create", mock.AnythingOfType("*model.Mail")).Return(mockDB).Run(func(args mock.Arguments) {
+			created = args.Get(0).(*model.Mail)
+		})
+		mockDB.On("Create", mock.Anything).Return(mockDB)
+		mockDB.On("Error").Return(nil)
+
+		jsonData, _ := json.Marshal(map[string]interface{}{
+			"to":      []string{to},
+			"cc":      []string{cc},
+			"bcc":     []string{bcc},
+			"subject": "subject",
+		})
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Set("userID", uint(1))
+		c.Request = httptest.NewRequest(http.MethodPost, "/send", bytes.NewBuffer(jsonData))
+		c.Request.Header.Set("Content-Type", "application/json")
+
+		service.SendMail(c)
+
+		if w.Code != http.StatusCreated {
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			return
+		}
+
+		bccAddr, ok := utils.ParseAddress(bcc)
+		if !ok {
+			return
+		}
+		assert.Contains(t, utils.RecipientAddresses(created.Recipients, model.RecipientBcc), bccAddr)
+
+		var visible []string
+		assert.NoError(t, created.Receivers.AssignTo(&visible))
+		toAddr, _ := utils.ParseAddress(to)
+		ccAddr, _ := utils.ParseAddress(cc)
+		if bccAddr != toAddr && bccAddr != ccAddr {
+			assert.NotContains(t, visible, bccAddr)
+		}
 	})
 }
 
@@ -106,19 +186,20 @@ func FuzzMailService_ArchiveMail(f *testing.F) {
 
 	f.Fuzz(func(t *testing.T, userID uint, mailID string) {
 		mockDB := new(MockMailDB)
-		service := NewMailService(mockDB)
-
-		mockDB.On("Where", "user_id = ?", userID).Return(mockDB)
-		mockDB.On("First", mock.AnythingOfType("*model.Trash")).Return(mockDB)
-
-		if rand.Intn(2) == 0 {
-			mockDB.On("Error").Return(nil)
-			mockDB.On("Model", mock.AnythingOfType("*model.Trash")).Return(mockDB)
-			mockDB.On("Where", "user_id = ?", userID).Return(mockDB)
-			mockDB.On("Update", "archived", mock.Anything).Return(mockDB)
-			mockDB.On("Error").Return(nil)
-		} else {
-			mockDB.On("Error").Return(assert.AnError)
+		service := NewMailService(mockDB, nil)
+
+		if id, err := strconv.Atoi(mailID); err == nil {
+			folders := []string{model.FolderInbox, model.FolderSent, model.FolderUser}
+			mockDB.On("Model", mock.AnythingOfType("*model.Mailbox")).Return(mockDB)
+			mockDB.On("Where", "user_id = ? AND mail_id = ? AND folder IN ?", userID, uint(id), folders).Return(mockDB)
+			mockDB.On("Updates", map[string]interface{}{"folder": model.FolderArchive, "folder_id": nil}).Return(mockDB)
+			mockDB.On("RowsAffected").Return(int64(1)).Maybe()
+
+			if rand.Intn(2) == 0 {
+				mockDB.On("Error").Return(nil)
+			} else {
+				mockDB.On("Error").Return(assert.AnError)
+			}
 		}
 
 		w := httptest.NewRecorder()
@@ -137,58 +218,3 @@ func FuzzMailService_ArchiveMail(f *testing.F) {
 		mockDB.AssertExpectations(t)
 	})
 }
-
-// func FuzzMailService_DeleteMail(f *testing.F) {
-// 	rand.Seed(time.Now().UnixNano())
-// 	f.Add(uint(1), "1")
-// 	f.Add(uint(0), "invalid_id")
-// 	f.Add(uint(rand.Uint32()), strconv.Itoa(rand.Int()))
-
-// 	f.Fuzz(func(t *testing.T, userID uint, mailID string) {
-// 		mockDB := new(MockMailDB)
-// 		service := NewMailService(mockDB)
-
-// 		mockDB.On("Where", "user_id = ?", userID).Return(mockDB)
-
-// 		tr := model.Trash{
-// 			Archived: []int64{},
-// 			Deleted:  []int64{},
-// 		}
-
-// 		if rand.Intn(2) == 0 {
-// 			id, _ := strconv.ParseInt(mailID, 10, 64)
-// 			tr.Archived = append(tr.Archived, id)
-// 		}
-
-// 		mockDB.On("First", mock.AnythingOfType("*model.Trash")).Run(func(args mock.Arguments) {
-// 			arg := args.Get(0).(*model.Trash)
-// 			*arg = tr
-// 		}).Return(mockDB)
-
-// 		if rand.Intn(2) == 0 {
-// 			mockDB.On("Error").Return(nil)
-// 			mockDB.On("Model", mock.AnythingOfType("*model.Trash")).Return(mockDB)
-// 			mockDB.On("Where", "user_id = ?", userID).Return(mockDB)
-// 			mockDB.On("Update", "archived", mock.Anything).Return(mockDB)
-// 			mockDB.On("Update", "deleted", mock.Anything).Return(mockDB)
-// 			mockDB.On("Error").Return(nil)
-// 		} else {
-// 			mockDB.On("Error").Return(assert.AnError)
-// 		}
-
-// 		w := httptest.NewRecorder()
-// 		c, _ := gin.CreateTestContext(w)
-// 		c.Set("userID", userID)
-// 		c.Params = gin.Params{gin.Param{Key: "id", Value: mailID}}
-
-// 		service.DeleteMail(c)
-
-// 		validCodes := []int{
-// 			http.StatusOK,
-// 			http.StatusBadRequest,
-// 			http.StatusInternalServerError,
-// 		}
-// 		assert.Contains(t, validCodes, w.Code, "unexpected status code")
-// 		mockDB.AssertExpectations(t)
-// 	})
-// }
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"backend/internal/model"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+)
+
+// likeEscaper escapes the LIKE wildcards, and the backslash escaping them.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// containsPattern is a LIKE pattern matching values that contain s, with
+// any wildcard in s taken literally.
+func containsPattern(s string) string {
+	return "%" + likeEscaper.Replace(s) + "%"
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// mailPage is the query string accepted by the mail listing endpoints.
+type mailPage struct {
+	Limit      int       `form:"limit"`
+	Cursor     string    `form:"cursor"`
+	Order      string    `form:"order"`
+	Sender     string    `form:"sender"`
+	Since      time.Time `form:"since" time_format:"2006-01-02T15:04:05Z07:00"`
+	Until      time.Time `form:"until" time_format:"2006-01-02T15:04:05Z07:00"`
+	HasSubject *bool     `form:"has_subject"`
+}
+
+func (p *mailPage) normalize() error {
+	if p.Limit <= 0 {
+		p.Limit = defaultPageLimit
+	} else if p.Limit > maxPageLimit {
+		p.Limit = maxPageLimit
+	}
+
+	switch p.Order {
+	case "":
+		p.Order = "desc"
+	case "asc", "desc":
+	default:
+		return fmt.Errorf("invalid order %q", p.Order)
+	}
+
+	if p.Cursor != "" {
+		if _, _, err := decodeCursor(p.Cursor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply adds filters, keyset condition, ordering and limit to a mails query.
+// One extra row is requested so the caller can tell whether a next page exists.
+func (p *mailPage) apply(db model.MailDB) model.MailDB {
+	if p.Sender != "" {
+		db = db.Where("mails.sender ILIKE ?", containsPattern(p.Sender))
+	}
+	if !p.Since.IsZero() {
+		db = db.Where("mails.created_at >= ?", p.Since)
+	}
+	if !p.Until.IsZero() {
+		db = db.Where("mails.created_at < ?", p.Until)
+	}
+	if p.HasSubject != nil {
+		if *p.HasSubject {
+			db = db.Where("COALESCE(mails.subject, '') <> ''")
+		} else {
+			db = db.Where("COALESCE(mails.subject, '') = ''")
+		}
+	}
+
+	cmp := "<"
+	if p.Order == "asc" {
+		cmp = ">"
+	}
+	if p.Cursor != "" {
+		createdAt, id, _ := decodeCursor(p.Cursor)
+		db = db.Where(fmt.Sprintf("(mails.created_at, mails.id) %s (?, ?)", cmp), createdAt, id)
+	}
+
+	return db.Order(fmt.Sprintf("mails.created_at %s, mails.id %s", p.Order, p.Order)).Limit(p.Limit + 1)
+}
+
+// trim cuts the extra row fetched by apply and returns the cursor of the
+// next page, or an empty string when mails was the last page.
+func (p *mailPage) trim(mails []model.Mail) ([]model.Mail, string) {
+	if len(mails) <= p.Limit {
+		return mails, ""
+	}
+	mails = mails[:p.Limit]
+	last := mails[len(mails)-1]
+	return mails, encodeCursor(last.CreatedAt, last.ID)
+}
+
+func encodeCursor(createdAt time.Time, id uint) string {
+	raw := fmt.Sprintf("%d:%d", createdAt.UnixMicro(), id)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (time.Time, uint, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, errInvalidCursor
+	}
+
+	var micros int64
+	var id uint
+	if n, err := fmt.Sscanf(string(raw), "%d:%d", &micros, &id); err != nil || n != 2 {
+		return time.Time{}, 0, errInvalidCursor
+	}
+	return time.UnixMicro(micros), id, nil
+}
@@ -0,0 +1,43 @@
+//go:build integration
+
+package service
+
+import (
+	"backend/internal/model"
+	"backend/internal/testdb"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMailPage_SenderFilter(t *testing.T) {
+	db := testdb.Open(t)
+	for _, sender := range []string{"first_last@example.com", "firstXlast@example.com", "100%@example.com", "100 percent@example.com"} {
+		require.NoError(t, db.Create(&model.Mail{Sender: sender}).Error)
+	}
+
+	tests := []struct {
+		sender string
+		want   []string
+	}{
+		{"first_last", []string{"first_last@example.com"}},
+		{"100%", []string{"100%@example.com"}},
+		{"FIRST", []string{"first_last@example.com", "firstXlast@example.com"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sender, func(t *testing.T) {
+			page := mailPage{Sender: tt.sender}
+			require.NoError(t, page.normalize())
+
+			var mails []model.Mail
+			require.NoError(t, page.apply(model.NewMailDB(db).Model(&model.Mail{})).Find(&mails).Error())
+			var senders []string
+			for _, mail := range mails {
+				senders = append(senders, mail.Sender)
+			}
+			assert.ElementsMatch(t, tt.want, senders)
+		})
+	}
+}
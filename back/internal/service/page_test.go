@@ -0,0 +1,60 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func FuzzMailPage_Cursor(f *testing.F) {
+	f.Add(int64(0), uint(0))
+	f.Add(time.Now().UnixMicro(), uint(1))
+	f.Add(int64(-1), uint(100000))
+
+	f.Fuzz(func(t *testing.T, micros int64, id uint) {
+		cursor := encodeCursor(time.UnixMicro(micros), id)
+
+		createdAt, decodedID, err := decodeCursor(cursor)
+		assert.NoError(t, err)
+		assert.Equal(t, micros, createdAt.UnixMicro())
+		assert.Equal(t, id, decodedID)
+	})
+}
+
+func FuzzMailPage_Normalize(f *testing.F) {
+	f.Add(0, "", "")
+	f.Add(1000, "asc", "")
+	f.Add(10, "sideways", "")
+	f.Add(10, "desc", "not-a-cursor")
+
+	f.Fuzz(func(t *testing.T, limit int, order, cursor string) {
+		page := mailPage{Limit: limit, Order: order, Cursor: cursor}
+		if err := page.normalize(); err != nil {
+			return
+		}
+
+		assert.True(t, page.Limit > 0 && page.Limit <= maxPageLimit)
+		assert.Contains(t, []string{"asc", "desc"}, page.Order)
+	})
+}
+
+func TestContainsPattern(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "alice", "%alice%"},
+		{"percent", "100%", `%100\%%`},
+		{"underscore", "first_last", `%first\_last%`},
+		{"backslash", `a\b`, `%a\\b%`},
+		{"only wildcards", "%_", `%\%\_%`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, containsPattern(tt.in))
+		})
+	}
+}
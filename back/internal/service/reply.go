@@ -0,0 +1,265 @@
+package service
+
+import (
+	"backend/internal/model"
+	"backend/utils"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	respondReply    = "reply"
+	respondReplyAll = "reply-all"
+	respondForward  = "forward"
+
+	quoteDateLayout = "Mon, 2 Jan 2006 15:04"
+)
+
+func (ms *mailService) ReplyMail(c *gin.Context) {
+	ms.respond(c, respondReply)
+}
+
+func (ms *mailService) ReplyAllMail(c *gin.Context) {
+	ms.respond(c, respondReplyAll)
+}
+
+func (ms *mailService) ForwardMail(c *gin.Context) {
+	ms.respond(c, respondForward)
+}
+
+// respond sends a reply to, or a forward of, a mail the user can see. The
+// recipients and subject are derived from the original, and any given in
+// the payload are added. The original is quoted below the new text, and a
+// forward carries its attachments along. The original is flagged as
+// answered or forwarded for the user once the reply is actually sent.
+func (ms *mailService) respond(c *gin.Context, mode string) {
+	userID := c.MustGet("userID").(uint)
+	mailID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid mailID"})
+		return
+	}
+
+	var input composeInput
+	if err := c.ShouldBind(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid input"})
+		return
+	}
+	extra, ok := input.outgoing()
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid receiver address"})
+		return
+	}
+
+	var user model.User
+	if err := ms.db.Where("id = ?", userID).First(&user).Error(); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "Invalid credentials"})
+		return
+	}
+
+	original, isSender, err := ms.visibleMail(userID, uint(mailID))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Mail not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error fetching mail"})
+		return
+	}
+
+	out := outgoingMail{
+		InReplyTo:  original.MessageId,
+		References: strings.TrimSpace(original.References + " " + original.MessageId),
+	}
+	if mode == respondForward {
+		out.Subject = prefixSubject(original.Subject, "Fwd:", "fwd:", "fw:")
+		out.Body, out.HTMLBody = forwardBodies(original, extra.Body, extra.HTMLBody)
+		for _, att := range original.Attachments {
+			out.Attachments = append(out.Attachments, model.Attachment{
+				Filename:    att.Filename,
+				ContentType: att.ContentType,
+				Size:        att.Size,
+				Checksum:    att.Checksum,
+				StorageKey:  att.StorageKey,
+			})
+		}
+	} else {
+		out.Subject = prefixSubject(original.Subject, "Re:", "re:")
+		out.Body, out.HTMLBody = replyBodies(original, extra.Body, extra.HTMLBody)
+		out.To, out.Cc = replyRecipients(original, isSender, mode == respondReplyAll)
+	}
+	if extra.Subject != "" {
+		out.Subject = extra.Subject
+	}
+
+	self := strings.ToLower(user.Email)
+	out.To = withoutAddress(append(out.To, extra.To...), self)
+	out.Cc = withoutAddress(append(out.Cc, extra.Cc...), self)
+	out.Bcc = extra.Bcc
+	if len(out.To)+len(out.Cc)+len(out.Bcc) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "No receivers"})
+		return
+	}
+
+	uploads, status, err := ms.saveUploads(c)
+	if err != nil {
+		c.JSON(status, gin.H{"message": err.Error()})
+		return
+	}
+	out.Attachments = append(out.Attachments, uploads...)
+
+	source := uint(mailID)
+	out.SourceMailId = &source
+	out.SourceFlag = model.FlagAnswered
+	if mode == respondForward {
+		out.SourceFlag = model.FlagForwarded
+	}
+
+	mail, err := ms.sendMail(ms.db, user, out)
+	if err != nil {
+		replySendError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, sentMail(mail))
+}
+
+// visibleMail loads a mail userID keeps in a visible folder, with its
+// recipients and attachments, and reports whether userID sent it.
+func (ms *mailService) visibleMail(userID, mailID uint) (model.Mail, bool, error) {
+	var boxes []model.Mailbox
+	if err := ms.db.Where("user_id = ? AND mail_id = ? AND folder IN ?", userID, mailID, visibleFolders).
+		Find(&boxes).Error(); err != nil {
+		return model.Mail{}, false, err
+	}
+	if len(boxes) == 0 {
+		return model.Mail{}, false, gorm.ErrRecordNotFound
+	}
+
+	isSender := false
+	for _, box := range boxes {
+		isSender = isSender || box.Role == model.MailboxSender
+	}
+
+	var mail model.Mail
+	if err := ms.db.Preload("Recipients").Preload("Attachments").Where("id = ?", mailID).First(&mail).Error(); err != nil {
+		return model.Mail{}, false, err
+	}
+	return mail, isSender, nil
+}
+
+// replyRecipients addresses a reply to the author of original, or to its
+// recipients again when the user wrote it. A reply to all also keeps the
+// other To and Cc recipients. Bcc recipients are never included.
+func replyRecipients(original model.Mail, isSender, all bool) ([]string, []string) {
+	to := toAddresses(original)
+	cc := utils.RecipientAddresses(original.Recipients, model.RecipientCc)
+
+	if isSender {
+		if all {
+			return to, cc
+		}
+		return to, nil
+	}
+
+	author, _ := utils.ParseAddress(original.Sender)
+	if !all {
+		return []string{author}, nil
+	}
+	return append([]string{author}, to...), cc
+}
+
+// toAddresses returns the To recipients of mail. Mails stored before
+// recipients were recorded only have Receivers, which are all taken as To.
+func toAddresses(mail model.Mail) []string {
+	if len(mail.Recipients) > 0 {
+		return utils.RecipientAddresses(mail.Recipients, model.RecipientTo)
+	}
+	to, err := model.DecodeReceivers(mail.Receivers)
+	if err != nil {
+		log.Printf("Error decoding receivers of mail %d: %v", mail.ID, err)
+	}
+	return to
+}
+
+// withoutAddress normalizes addrs, dropping invalid entries and self.
+func withoutAddress(addrs []string, self string) []string {
+	var out []string
+	seen := map[string]bool{self: true}
+	for _, addr := range addrs {
+		parsed, ok := utils.ParseAddress(addr)
+		if ok && !seen[parsed] {
+			seen[parsed] = true
+			out = append(out, parsed)
+		}
+	}
+	return out
+}
+
+// prefixSubject puts prefix in front of subject unless it already starts
+// with one of the given lower-case forms.
+func prefixSubject(subject, prefix string, forms ...string) string {
+	trimmed := strings.TrimSpace(subject)
+	lower := strings.ToLower(trimmed)
+	for _, form := range forms {
+		if strings.HasPrefix(lower, form) {
+			return trimmed
+		}
+	}
+	return strings.TrimSpace(prefix + " " + trimmed)
+}
+
+func replyBodies(original model.Mail, text, htmlText string) (string, string) {
+	attribution := fmt.Sprintf("%s, %s wrote:", original.CreatedAt.Format(quoteDateLayout), original.Sender)
+
+	lines := strings.Split(original.Body, "\n")
+	for i, line := range lines {
+		lines[i] = "> " + line
+	}
+	body := strings.TrimSpace(text + "\n\n" + attribution + "\n" + strings.Join(lines, "\n"))
+
+	if htmlText == "" && original.HTMLBody == "" {
+		return body, ""
+	}
+	return body, composeHTML(text, htmlText) +
+		"<p>" + html.EscapeString(attribution) + "</p><blockquote>" + originalHTML(original) + "</blockquote>"
+}
+
+func forwardBodies(original model.Mail, text, htmlText string) (string, string) {
+	to := toAddresses(original)
+	header := fmt.Sprintf("---------- Forwarded message ----------\nFrom: %s\nDate: %s\nSubject: %s\nTo: %s",
+		original.Sender, original.CreatedAt.Format(quoteDateLayout), original.Subject, strings.Join(to, ", "))
+	body := strings.TrimSpace(text + "\n\n" + header + "\n\n" + original.Body)
+
+	if htmlText == "" && original.HTMLBody == "" {
+		return body, ""
+	}
+	headerHTML := strings.ReplaceAll(html.EscapeString(header), "\n", "<br>")
+	return body, composeHTML(text, htmlText) + "<p>" + headerHTML + "</p>" + originalHTML(original)
+}
+
+// composeHTML returns the HTML of the user's new text, rendering plain text
+// when no HTML was given.
+func composeHTML(text, htmlText string) string {
+	if htmlText != "" {
+		return "<div>" + htmlText + "</div>"
+	}
+	if text == "" {
+		return ""
+	}
+	return "<div>" + strings.ReplaceAll(html.EscapeString(text), "\n", "<br>") + "</div>"
+}
+
+func originalHTML(original model.Mail) string {
+	if original.HTMLBody != "" {
+		return original.HTMLBody
+	}
+	return strings.ReplaceAll(html.EscapeString(original.Body), "\n", "<br>")
+}
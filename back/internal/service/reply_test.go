@@ -0,0 +1,150 @@
+package service
+
+import (
+	"backend/internal/model"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func FuzzReply_PrefixSubject(f *testing.F) {
+	f.Add("Weekly report")
+	f.Add("Re: Weekly report")
+	f.Add("RE:RE: hello")
+	f.Add("  Fwd: news ")
+	f.Add("")
+
+	f.Fuzz(func(t *testing.T, subject string) {
+		once := prefixSubject(subject, "Re:", "re:")
+		assert.Equal(t, once, prefixSubject(once, "Re:", "re:"))
+		assert.True(t, strings.HasPrefix(strings.ToLower(once), "re:"))
+
+		fwd := prefixSubject(subject, "Fwd:", "fwd:", "fw:")
+		assert.Equal(t, fwd, prefixSubject(fwd, "Fwd:", "fwd:", "fw:"))
+	})
+}
+
+func FuzzMailService_ReplyAll(f *testing.F) {
+	f.Add(false, "Alice <alice@example.com>", "test1@gomail.kurs", "bob@example.com")
+	f.Add(true, "test1@gomail.kurs", "alice@example.com", "test1@gomail.kurs")
+	f.Add(false, "not an address", "", "")
+
+	f.Fuzz(func(t *testing.T, isSender bool, sender, to, cc string) {
+		mockDB := new(MockMailDB)
+		service := NewMailService(mockDB, nil)
+
+		const self = "test1@gomail.kurs"
+		role := model.MailboxRecipient
+		if isSender {
+			role = model.MailboxSender
+		}
+
+		original := model.Mail{
+			MessageId: "<root@example.com>",
+			Sender:    sender,
+			Subject:   "Plans",
+			Body:      "line one\nline two",
+			Recipients: []model.Recipient{
+				{Address: to, Type: model.RecipientTo},
+				{Address: cc, Type: model.RecipientCc},
+				{Address: "hidden@example.com", Type: model.RecipientBcc},
+			},
+		}
+
+		var created *model.Mail
+		mockDB.On("Where", "id = ?", mock.Anything).Return(mockDB)
+		mockDB.On("First", mock.AnythingOfType("*model.User")).Return(mockDB).Run(func(args mock.Arguments) {
+			*args.Get(0).(*model.User) = model.User{Id: 1, Email: self}
+		})
+		mockDB.On("Where", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(mockDB)
+		mockDB.On("Find", mock.AnythingOfType("*[]model.Mailbox")).Return(mockDB).Run(func(args mock.Arguments) {
+			*args.Get(0).(*[]model.Mailbox) = []model.Mailbox{{Role: role}}
+		})
+		mockDB.On("Preload", mock.Anything).Return(mockDB)
+		mockDB.On("First", mock.AnythingOfType("*model.Mail")).Return(mockDB).Run(func(args mock.Arguments) {
+			*args.Get(0).(*model.Mail) = original
+		})
+		mockDB.On("Where", mock.Anything, mock.Anything).Return(mockDB)
+		mockDB.On("Select", "thread_id").Return(mockDB)
+		mockDB.On("Limit", 1).Return(mockDB)
+		mockDB.On("Find", mock.Anything).Return(mockDB)
+		mockDB.On("Create", mock.AnythingOfType("*model.Mail")).Return(mockDB).Run(func(args mock.Arguments) {
+			created = args.Get(0).(*model.Mail)
+		})
+		mockDB.On("Create", mock.Anything).Return(mockDB)
+		mockDB.On("Model", mock.AnythingOfType("*model.Mailbox")).Return(mockDB)
+		mockDB.On("Where", "user_id = ? AND mail_id = ?", uint(1), uint(7)).Return(mockDB)
+		mockDB.On("Update", "answered", true).Return(mockDB)
+		mockDB.On("Error").Return(nil)
+
+		jsonData, _ := json.Marshal(map[string]string{"body": "Sounds good"})
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Set("userID", uint(1))
+		c.Params = gin.Params{gin.Param{Key: "id", Value: "7"}}
+		c.Request = httptest.NewRequest(http.MethodPost, "/mail/7/reply-all", bytes.NewBuffer(jsonData))
+		c.Request.Header.Set("Content-Type", "application/json")
+
+		service.ReplyAllMail(c)
+
+		if w.Code != http.StatusCreated {
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			return
+		}
+
+		mockDB.AssertCalled(t, "Where", "user_id = ? AND mail_id = ?", uint(1), uint(7))
+		mockDB.AssertCalled(t, "Update", "answered", true)
+		assert.Equal(t, uint(7), *created.SourceMailId)
+		assert.Equal(t, model.FlagAnswered, created.SourceFlag)
+		assert.Equal(t, "Re: Plans", created.Subject)
+		assert.Equal(t, "<root@example.com>", created.InReplyTo)
+		assert.Contains(t, created.References, "<root@example.com>")
+		assert.Contains(t, created.Body, "> line two")
+		for _, rec := range created.Recipients {
+			assert.NotEqual(t, self, rec.Address)
+			assert.NotEqual(t, "hidden@example.com", rec.Address)
+		}
+	})
+}
+
+func TestReply_LegacyReceivers(t *testing.T) {
+	// Mails stored before recipients were recorded hold their receivers as
+	// the JSON encoding of pgtype.JSONB, the way they are loaded from the
+	// database.
+	var stored pgtype.JSONB
+	assert.NoError(t, stored.Set([]string{"bob@example.com", "carol@example.com"}))
+	column, _ := json.Marshal(stored)
+
+	tests := []struct {
+		name     string
+		isSender bool
+		all      bool
+		to       []string
+	}{
+		{"reply to own mail", true, false, []string{"bob@example.com", "carol@example.com"}},
+		{"reply to all", false, true, []string{"alice@example.com", "bob@example.com", "carol@example.com"}},
+		{"reply to author", false, false, []string{"alice@example.com"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := model.Mail{Sender: "Alice <alice@example.com>", Receivers: pgtype.JSONB{Bytes: column, Status: pgtype.Present}}
+
+			to, cc := replyRecipients(original, tt.isSender, tt.all)
+			assert.Equal(t, tt.to, to)
+			assert.Empty(t, cc)
+
+			body, _ := forwardBodies(original, "FYI", "")
+			assert.Contains(t, body, "To: bob@example.com, carol@example.com")
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package service
+
+import (
+	"backend/internal/model"
+	"backend/utils"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// scheduledMailQuery matches a mail the given user scheduled for later
+// which is still waiting to be sent.
+const scheduledMailQuery = "send_at IS NOT NULL AND EXISTS (SELECT 1 FROM mailboxes" +
+	" WHERE mailboxes.mail_id = mails.id AND mailboxes.user_id = ? AND mailboxes.folder = ?)"
+
+// heldMailQuery matches a mail the given user sent right away which is
+// still held back for the undo window. Its sender copy is filed like any
+// sent mail, so it is told apart from a scheduled one by its folder.
+const heldMailQuery = "send_at IS NOT NULL AND EXISTS (SELECT 1 FROM mailboxes" +
+	" WHERE mailboxes.mail_id = mails.id AND mailboxes.user_id = ? AND mailboxes.role = ? AND mailboxes.folder <> ?)"
+
+// rescheduleInput moves a scheduled mail to another send time.
+type rescheduleInput struct {
+	SendAt *time.Time `json:"send_at" binding:"required"`
+}
+
+// GetScheduled lists the user's mails waiting for their send time.
+func (ms *mailService) GetScheduled(c *gin.Context) {
+	ms.listFolder(c, model.FolderScheduled)
+}
+
+// RescheduleMail changes when a scheduled mail is sent.
+func (ms *mailService) RescheduleMail(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	mailID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid mailID"})
+		return
+	}
+
+	var input rescheduleInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid input"})
+		return
+	}
+	if !input.SendAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "send_at must be in the future"})
+		return
+	}
+
+	res := ms.db.Model(&model.Mail{}).
+		Where("id = ?", mailID).
+		Where(scheduledMailQuery, userID, model.FolderScheduled).
+		Update("send_at", input.SendAt)
+	if err := res.Error(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error rescheduling mail"})
+		return
+	}
+	if res.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Scheduled mail not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"id": mailID, "send_at": input.SendAt})
+}
+
+// CancelScheduled keeps a scheduled mail from being sent and turns it
+// back into a draft, which it replies with.
+func (ms *mailService) CancelScheduled(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	mailID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid mailID"})
+		return
+	}
+
+	draft, err := ms.unsend(userID, uint(mailID), scheduledMailQuery, userID, model.FolderScheduled)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Scheduled mail not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error cancelling mail"})
+		return
+	}
+
+	c.JSON(http.StatusOK, draft)
+}
+
+// UndoSend cancels a mail the user sent while it is still held back and
+// returns it as a draft. Once the mail has gone out it is too late, and
+// mail scheduled for later is cancelled through CancelScheduled instead.
+func (ms *mailService) UndoSend(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	mailID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid mailID"})
+		return
+	}
+
+	draft, err := ms.unsend(userID, uint(mailID), heldMailQuery, userID, model.MailboxSender, model.FolderScheduled)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		ms.replyUnchanged(c, userID, uint(mailID), "Mail has already been sent")
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error undoing send"})
+		return
+	}
+
+	c.JSON(http.StatusOK, draft)
+}
+
+// unsend replaces a mail of userID matching query, which has not been sent
+// yet, with a draft of the same content. The mail is claimed before it is
+// read, so it is either sent or turned into a draft, never both.
+func (ms *mailService) unsend(userID, mailID uint, query string, args ...interface{}) (model.Draft, error) {
+	var draft model.Draft
+	err := ms.db.Transaction(func(tx model.MailDB) error {
+		res := tx.Model(&model.Mail{}).
+			Where("id = ?", mailID).
+			Where(query, args...).
+			Update("send_at", nil)
+		if err := res.Error(); err != nil {
+			return err
+		}
+		if res.RowsAffected() == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		var mail model.Mail
+		if err := tx.Preload("Recipients").Preload("Attachments").
+			Where("id = ?", mailID).First(&mail).Error(); err != nil {
+			return err
+		}
+
+		draft = draftFromMail(userID, mail)
+		if err := tx.Create(&draft).Error(); err != nil {
+			return err
+		}
+		return utils.DeleteMails(tx, []uint{mailID})
+	})
+	return draft, err
+}
+
+// draftFromMail is a draft of userID with the content of mail. Its
+// attachments share the stored files of the mail's.
+func draftFromMail(userID uint, mail model.Mail) model.Draft {
+	return model.Draft{
+		UserId:      userID,
+		To:          append([]string{}, utils.RecipientAddresses(mail.Recipients, model.RecipientTo)...),
+		Cc:          append([]string{}, utils.RecipientAddresses(mail.Recipients, model.RecipientCc)...),
+		Bcc:         append([]string{}, utils.RecipientAddresses(mail.Recipients, model.RecipientBcc)...),
+		Subject:     mail.Subject,
+		Body:        mail.Body,
+		HTMLBody:    mail.HTMLBody,
+		InReplyTo:   mail.InReplyTo,
+		References:  mail.References,
+		Version:     1,
+		Attachments: draftAttachments(mail.Attachments),
+	}
+}
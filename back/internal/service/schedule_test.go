@@ -0,0 +1,430 @@
+package service
+
+import (
+	"backend/internal/model"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestMailService_SendMailScheduled(t *testing.T) {
+	tests := []struct {
+		name   string
+		offset time.Duration
+		zone   string
+		code   int
+	}{
+		{"within the hour", time.Hour, "Europe/Moscow", http.StatusCreated},
+		{"next day in another zone", 24 * time.Hour, "America/New_York", http.StatusCreated},
+		{"in the past", -time.Minute, "UTC", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loc, err := time.LoadLocation(tt.zone)
+			if err != nil {
+				t.Skipf("no time zone data: %v", err)
+			}
+			mockDB := new(MockMailDB)
+			service := NewMailService(mockDB, nil)
+
+			var created *model.Mail
+			var box *model.Mailbox
+			mockDB.On("Where", "id = ?", uint(1)).Return(mockDB)
+			mockDB.On("First", mock.AnythingOfType("*model.User")).Return(mockDB).Run(func(args mock.Arguments) {
+				*args.Get(0).(*model.User) = model.User{Id: 1, Email: "test@gomail.kurs"}
+			})
+			mockDB.On("Create", mock.AnythingOfType("*model.Mail")).Return(mockDB).Run(func(args mock.Arguments) {
+				created = args.Get(0).(*model.Mail)
+			})
+			mockDB.On("Create", mock.AnythingOfType("*model.Mailbox")).Return(mockDB).Run(func(args mock.Arguments) {
+				box = args.Get(0).(*model.Mailbox)
+			})
+			mockDB.On("Error").Return(nil)
+
+			sendAt := time.Now().Add(tt.offset).In(loc).Truncate(time.Second)
+			jsonData, _ := json.Marshal(map[string]interface{}{
+				"to":      []string{"friend@example.com", "test2@gomail.kurs"},
+				"subject": "later",
+				"send_at": sendAt.Format(time.RFC3339),
+			})
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Set("userID", uint(1))
+			c.Request = httptest.NewRequest(http.MethodPost, "/send", bytes.NewBuffer(jsonData))
+			c.Request.Header.Set("Content-Type", "application/json")
+
+			service.SendMail(c)
+
+			assert.Equal(t, tt.code, w.Code)
+			if tt.code != http.StatusCreated {
+				assert.Nil(t, created)
+				assert.Nil(t, box)
+				return
+			}
+			if assert.NotNil(t, created.SendAt) {
+				assert.True(t, created.SendAt.Equal(sendAt))
+			}
+			assert.Equal(t, "test@gomail.kurs", created.Sender)
+			assert.Equal(t, "later", created.Subject)
+			assert.Equal(t, []model.Recipient{
+				{Address: "friend@example.com", Type: model.RecipientTo},
+				{Address: "test2@gomail.kurs", Type: model.RecipientTo},
+			}, created.Recipients)
+			assert.Equal(t, uint(1), box.UserId)
+			assert.Equal(t, model.FolderScheduled, box.Folder)
+			assert.Equal(t, model.MailboxSender, box.Role)
+			// Nobody else gets the mail until it is sent.
+			mockDB.AssertNotCalled(t, "Create", mock.AnythingOfType("*[]model.Mailbox"))
+			mockDB.AssertNotCalled(t, "Create", mock.AnythingOfType("*[]model.Delivery"))
+		})
+	}
+}
+
+func TestMailService_RescheduleMail(t *testing.T) {
+	tests := []struct {
+		name     string
+		mailID   string
+		offset   time.Duration
+		affected int64
+		code     int
+	}{
+		{"moved", "4", 10 * time.Minute, 1, http.StatusOK},
+		{"not scheduled", "4", 10 * time.Minute, 0, http.StatusNotFound},
+		{"in the past", "4", -10 * time.Minute, 1, http.StatusBadRequest},
+		{"invalid id", "x", 10 * time.Minute, 1, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(MockMailDB)
+			service := NewMailService(mockDB, nil)
+
+			var moved *time.Time
+			mockDB.On("Model", &model.Mail{}).Return(mockDB)
+			mockDB.On("Where", "id = ?", 4).Return(mockDB)
+			mockDB.On("Where", scheduledMailQuery, uint(1), model.FolderScheduled).Return(mockDB)
+			mockDB.On("Update", "send_at", mock.AnythingOfType("*time.Time")).Return(mockDB).Run(func(args mock.Arguments) {
+				moved = args.Get(1).(*time.Time)
+			})
+			mockDB.On("RowsAffected").Return(tt.affected)
+			mockDB.On("Error").Return(nil)
+
+			sendAt := time.Now().Add(tt.offset).Truncate(time.Second)
+			jsonData, _ := json.Marshal(map[string]interface{}{"send_at": sendAt.Format(time.RFC3339)})
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Set("userID", uint(1))
+			c.Params = gin.Params{gin.Param{Key: "id", Value: tt.mailID}}
+			c.Request = httptest.NewRequest(http.MethodPut, "/mail/scheduled/"+tt.mailID, bytes.NewBuffer(jsonData))
+			c.Request.Header.Set("Content-Type", "application/json")
+
+			service.RescheduleMail(c)
+
+			assert.Equal(t, tt.code, w.Code)
+			if tt.code == http.StatusBadRequest {
+				assert.Nil(t, moved)
+				return
+			}
+			if assert.NotNil(t, moved) {
+				assert.True(t, moved.Equal(sendAt))
+			}
+		})
+	}
+}
+
+func TestMailService_CancelScheduled(t *testing.T) {
+	tests := []struct {
+		name     string
+		affected int64
+		code     int
+	}{
+		{"cancelled", 1, http.StatusOK},
+		{"not scheduled", 0, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(MockMailDB)
+			service := NewMailService(mockDB, nil)
+
+			var draft *model.Draft
+			var deleted []string
+			mockDB.On("Model", &model.Mail{}).Return(mockDB)
+			mockDB.On("Where", "id = ?", uint(4)).Return(mockDB)
+			mockDB.On("Where", scheduledMailQuery, uint(1), model.FolderScheduled).Return(mockDB)
+			mockDB.On("Update", "send_at", nil).Return(mockDB)
+			mockDB.On("RowsAffected").Return(tt.affected)
+			mockDB.On("Preload", "Recipients").Return(mockDB)
+			mockDB.On("Preload", "Attachments").Return(mockDB)
+			mockDB.On("First", mock.AnythingOfType("*model.Mail")).Return(mockDB).Run(func(args mock.Arguments) {
+				*args.Get(0).(*model.Mail) = model.Mail{
+					Subject: "later",
+					Body:    "text",
+					Recipients: []model.Recipient{
+						{Address: "to@example.com", Type: model.RecipientTo},
+						{Address: "copy@example.com", Type: model.RecipientCc},
+						{Address: "hidden@example.com", Type: model.RecipientBcc},
+					},
+					Attachments: []model.Attachment{{Filename: "a.txt", ContentType: "text/plain", Size: 3, Checksum: "sum", StorageKey: "key"}},
+				}
+			})
+			mockDB.On("Create", mock.AnythingOfType("*model.Draft")).Return(mockDB).Run(func(args mock.Arguments) {
+				draft = args.Get(0).(*model.Draft)
+			})
+			mockDB.On("Where", "mailbox_id IN (SELECT id FROM mailboxes WHERE mail_id IN ?)", []uint{4}).Return(mockDB)
+			mockDB.On("Where", "mail_id IN ?", []uint{4}).Return(mockDB)
+			mockDB.On("Where", "id IN ?", []uint{4}).Return(mockDB)
+			mockDB.On("Delete", mock.Anything).Return(mockDB).Run(func(args mock.Arguments) {
+				deleted = append(deleted, fmt.Sprintf("%T", args.Get(0)))
+			})
+			mockDB.On("Error").Return(nil)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Set("userID", uint(1))
+			c.Params = gin.Params{gin.Param{Key: "id", Value: "4"}}
+
+			service.CancelScheduled(c)
+
+			assert.Equal(t, tt.code, w.Code)
+			if tt.code != http.StatusOK {
+				assert.Nil(t, draft)
+				assert.Empty(t, deleted)
+				return
+			}
+			assert.Equal(t, uint(1), draft.UserId)
+			assert.Equal(t, []string{"to@example.com"}, draft.To)
+			assert.Equal(t, []string{"copy@example.com"}, draft.Cc)
+			assert.Equal(t, []string{"hidden@example.com"}, draft.Bcc)
+			assert.Equal(t, "later", draft.Subject)
+			assert.Equal(t, "text", draft.Body)
+			assert.Equal(t, 1, draft.Version)
+			if assert.Len(t, draft.Attachments, 1) {
+				assert.Equal(t, "a.txt", draft.Attachments[0].Filename)
+				assert.Equal(t, "key", draft.Attachments[0].StorageKey)
+				assert.Equal(t, "sum", draft.Attachments[0].Checksum)
+			}
+			assert.Equal(t, []string{
+				"*model.MailboxLabel",
+				"*model.Mailbox",
+				"*model.Recipient",
+				"*model.Attachment",
+				"*model.Delivery",
+				"*model.Mail",
+			}, deleted)
+		})
+	}
+}
+
+func TestMailService_SendMailUndoWindow(t *testing.T) {
+	later := time.Now().Add(time.Hour).Round(time.Second)
+
+	tests := []struct {
+		name   string
+		window int
+		sendAt *time.Time
+		held   bool
+		folder string
+	}{
+		{"sent right away", 0, nil, false, model.FolderSent},
+		{"held for the undo window", 10, nil, true, model.FolderSent},
+		{"scheduled", 10, &later, true, model.FolderScheduled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(MockMailDB)
+			service := NewMailService(mockDB, nil)
+
+			var created *model.Mail
+			var box model.Mailbox
+			mockDB.On("Where", "id = ?", uint(1)).Return(mockDB)
+			mockDB.On("First", mock.AnythingOfType("*model.User")).Return(mockDB).Run(func(args mock.Arguments) {
+				*args.Get(0).(*model.User) = model.User{Id: 1, Email: "test@gomail.kurs", UndoSendSeconds: tt.window}
+			})
+			mockDB.On("Create", mock.AnythingOfType("*model.Mail")).Return(mockDB).Run(func(args mock.Arguments) {
+				created = args.Get(0).(*model.Mail)
+			})
+			mockDB.On("Create", mock.AnythingOfType("*model.Mailbox")).Return(mockDB).Maybe().Run(func(args mock.Arguments) {
+				box = *args.Get(0).(*model.Mailbox)
+			})
+			mockDB.On("Where", "name IN ?", []string{"example.com"}).Return(mockDB).Maybe()
+			mockDB.On("Find", mock.AnythingOfType("*[]model.Domain")).Return(mockDB).Maybe()
+			mockDB.On("Create", mock.AnythingOfType("*[]model.Mailbox")).Return(mockDB).Maybe().Run(func(args mock.Arguments) {
+				box = (*args.Get(0).(*[]model.Mailbox))[0]
+			})
+			mockDB.On("Create", mock.AnythingOfType("*[]model.Delivery")).Return(mockDB).Maybe()
+			mockDB.On("Error").Return(nil)
+
+			input := map[string]interface{}{
+				"to":      []string{"friend@example.com"},
+				"subject": "oops",
+			}
+			if tt.sendAt != nil {
+				input["send_at"] = tt.sendAt
+			}
+			jsonData, _ := json.Marshal(input)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Set("userID", uint(1))
+			c.Request = httptest.NewRequest(http.MethodPost, "/send", bytes.NewBuffer(jsonData))
+			c.Request.Header.Set("Content-Type", "application/json")
+
+			before := time.Now()
+			service.SendMail(c)
+
+			assert.Equal(t, http.StatusCreated, w.Code)
+			var reply map[string]interface{}
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &reply))
+			assert.Equal(t, uint(1), box.UserId)
+			assert.Equal(t, model.MailboxSender, box.Role)
+			assert.Equal(t, tt.folder, box.Folder)
+
+			switch {
+			case !tt.held:
+				assert.Nil(t, created.SendAt)
+				assert.NotContains(t, reply, "send_at")
+				mockDB.AssertCalled(t, "Create", mock.AnythingOfType("*[]model.Delivery"))
+				return
+			case tt.sendAt != nil:
+				assert.True(t, tt.sendAt.Equal(*created.SendAt))
+			default:
+				held := time.Duration(tt.window) * time.Second
+				assert.False(t, created.SendAt.Before(before.Add(held)))
+				assert.False(t, created.SendAt.After(time.Now().Add(held)))
+			}
+			assert.Contains(t, reply, "send_at")
+			mockDB.AssertNotCalled(t, "Create", mock.AnythingOfType("*[]model.Delivery"))
+		})
+	}
+}
+
+func TestMailService_UndoSend(t *testing.T) {
+	tests := []struct {
+		name     string
+		affected int64
+		kept     bool
+		code     int
+	}{
+		{"held", 1, false, http.StatusOK},
+		{"already sent", 0, true, http.StatusConflict},
+		{"not found", 0, false, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(MockMailDB)
+			service := NewMailService(mockDB, nil)
+
+			mockDB.On("Model", mock.AnythingOfType("*model.Mail")).Return(mockDB)
+			mockDB.On("Where", "id = ?", uint(4)).Return(mockDB)
+			mockDB.On("Where", heldMailQuery, uint(1), model.MailboxSender, model.FolderScheduled).Return(mockDB).Once()
+			mockDB.On("Update", "send_at", nil).Return(mockDB)
+			mockDB.On("RowsAffected").Return(tt.affected)
+			mockDB.On("Preload", "Recipients").Return(mockDB)
+			mockDB.On("Preload", "Attachments").Return(mockDB)
+			mockDB.On("First", mock.AnythingOfType("*model.Mail")).Return(mockDB).Run(func(args mock.Arguments) {
+				*args.Get(0).(*model.Mail) = model.Mail{
+					Subject:    "oops",
+					InReplyTo:  "<parent@gomail.kurs>",
+					Recipients: []model.Recipient{{Address: "test2@gomail.kurs", Type: model.RecipientTo}},
+				}
+			})
+			var draft *model.Draft
+			mockDB.On("Create", mock.AnythingOfType("*model.Draft")).Return(mockDB).Run(func(args mock.Arguments) {
+				draft = args.Get(0).(*model.Draft)
+			})
+			var deleted []string
+			mockDB.On("Where", "mailbox_id IN (SELECT id FROM mailboxes WHERE mail_id IN ?)", []uint{4}).Return(mockDB)
+			mockDB.On("Where", "mail_id IN ?", []uint{4}).Return(mockDB)
+			mockDB.On("Where", "id IN ?", []uint{4}).Return(mockDB)
+			mockDB.On("Delete", mock.Anything).Return(mockDB).Run(func(args mock.Arguments) {
+				deleted = append(deleted, fmt.Sprintf("%T", args.Get(0)))
+			})
+			mockDB.On("Model", mock.AnythingOfType("*model.Mailbox")).Return(mockDB)
+			mockDB.On("Select", "count(*) > 0").Return(mockDB)
+			mockDB.On("Where", "user_id = ? AND mail_id = ? AND folder <> ?", uint(1), uint(4), model.FolderDeleted).Return(mockDB)
+			mockDB.On("Find", mock.AnythingOfType("*bool")).Return(mockDB).Run(func(args mock.Arguments) {
+				*args.Get(0).(*bool) = tt.kept
+			})
+			mockDB.On("Error").Return(nil)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Set("userID", uint(1))
+			c.Params = gin.Params{gin.Param{Key: "id", Value: "4"}}
+
+			service.UndoSend(c)
+
+			assert.Equal(t, tt.code, w.Code)
+			if tt.code != http.StatusOK {
+				mockDB.AssertNotCalled(t, "Create", mock.Anything)
+				assert.Empty(t, deleted)
+				return
+			}
+			assert.Equal(t, uint(1), draft.UserId)
+			assert.Equal(t, []string{"test2@gomail.kurs"}, draft.To)
+			assert.Equal(t, "oops", draft.Subject)
+			assert.Equal(t, "<parent@gomail.kurs>", draft.InReplyTo)
+			assert.Contains(t, deleted, "*model.Mail")
+			assert.Contains(t, deleted, "*model.Mailbox")
+		})
+	}
+}
+
+func TestMailService_UpdateSettings(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		code  int
+		saved interface{}
+	}{
+		{"window", `{"undo_send_seconds": 10}`, http.StatusOK, 10},
+		{"off", `{"undo_send_seconds": 0}`, http.StatusOK, 0},
+		{"shortest window", `{"undo_send_seconds": 5}`, http.StatusOK, 5},
+		{"longest window", `{"undo_send_seconds": 30}`, http.StatusOK, 30},
+		{"too short", `{"undo_send_seconds": 3}`, http.StatusBadRequest, nil},
+		{"too long", `{"undo_send_seconds": 31}`, http.StatusBadRequest, nil},
+		{"missing", `{}`, http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(MockMailDB)
+			service := NewMailService(mockDB, nil)
+
+			mockDB.On("Model", mock.AnythingOfType("*model.User")).Return(mockDB)
+			mockDB.On("Where", "id = ?", uint(1)).Return(mockDB)
+			mockDB.On("Update", "undo_send_seconds", mock.Anything).Return(mockDB)
+			mockDB.On("Error").Return(nil)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Set("userID", uint(1))
+			c.Request = httptest.NewRequest(http.MethodPut, "/mail/settings", bytes.NewBufferString(tt.body))
+			c.Request.Header.Set("Content-Type", "application/json")
+
+			service.UpdateSettings(c)
+
+			assert.Equal(t, tt.code, w.Code)
+			if tt.saved == nil {
+				mockDB.AssertNotCalled(t, "Update", mock.Anything, mock.Anything)
+				return
+			}
+			mockDB.AssertCalled(t, "Update", "undo_send_seconds", tt.saved)
+			assert.JSONEq(t, fmt.Sprintf(`{"undo_send_seconds": %d}`, tt.saved), w.Body.String())
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package service
+
+import (
+	"backend/internal/model"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// ts_headline marks matches with control characters rather than
+	// <mark> tags, so that the text around them can be escaped first.
+	highlightStart  = "\x02"
+	highlightStop   = "\x03"
+	headlineOptions = "StartSel=" + highlightStart + ", StopSel=" + highlightStop + ", MaxWords=35, MinWords=15, MaxFragments=2"
+	subjectOptions  = "StartSel=" + highlightStart + ", StopSel=" + highlightStop + ", HighlightAll=true"
+
+	// receiversText decodes the Receivers column, which holds the JSON
+	// encoding of pgtype.JSONB rather than the receivers list itself.
+	receiversText = "convert_from(decode(mails.receivers->>'Bytes', 'base64'), 'UTF8')"
+)
+
+// visibleFolders hold the mails a user can find through search and threads.
+var visibleFolders = []string{model.FolderInbox, model.FolderSent, model.FolderArchive, model.FolderUser}
+
+// visibleMailsQuery limits mails to those a user, the first argument, keeps
+// in one of the folders given as the second.
+const visibleMailsQuery = "EXISTS (SELECT 1 FROM mailboxes WHERE mailboxes.mail_id = mails.id AND mailboxes.user_id = ? AND mailboxes.folder IN ?)"
+
+// searchQuery is a parsed search string: free text plus the supported
+// operators from:, to:, subject:, before: and after:.
+type searchQuery struct {
+	Text    string
+	From    []string
+	To      []string
+	Subject []string
+	Before  time.Time
+	After   time.Time
+}
+
+type searchResult struct {
+	ID        uint
+	Sender    string
+	Subject   string
+	CreatedAt time.Time
+	Rank      float64
+	Headline  string
+	Snippet   string
+}
+
+func (ms *mailService) SearchMails(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	var input struct {
+		Query string `form:"q"`
+		Limit int    `form:"limit"`
+	}
+	if err := c.ShouldBindQuery(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid query"})
+		return
+	}
+
+	query, err := parseSearchQuery(input.Query)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	if input.Limit <= 0 {
+		input.Limit = defaultPageLimit
+	} else if input.Limit > maxPageLimit {
+		input.Limit = maxPageLimit
+	}
+
+	db := ms.db.Model(&model.Mail{}).
+		Where(visibleMailsQuery, userID, visibleFolders)
+
+	for _, from := range query.From {
+		db = db.Where("mails.sender ILIKE ?", containsPattern(from))
+	}
+	for _, to := range query.To {
+		db = db.Where(receiversText+" ILIKE ?", containsPattern(to))
+	}
+	for _, subject := range query.Subject {
+		db = db.Where("mails.subject ILIKE ?", containsPattern(subject))
+	}
+	if !query.Before.IsZero() {
+		db = db.Where("mails.created_at < ?", query.Before)
+	}
+	if !query.After.IsZero() {
+		db = db.Where("mails.created_at >= ?", query.After)
+	}
+
+	if query.Text != "" {
+		db = db.Select(`mails.id, mails.sender, mails.subject, mails.created_at,
+			ts_rank(mails.search_vector, websearch_to_tsquery('simple', ?)) AS rank,
+			ts_headline('simple', coalesce(mails.subject, ''), websearch_to_tsquery('simple', ?), ?) AS headline,
+			ts_headline('simple', coalesce(mails.body, ''), websearch_to_tsquery('simple', ?), ?) AS snippet`,
+			query.Text, query.Text, subjectOptions, query.Text, headlineOptions).
+			Where("mails.search_vector @@ websearch_to_tsquery('simple', ?)", query.Text).
+			Order("rank DESC, mails.created_at DESC")
+	} else {
+		db = db.Select(`mails.id, mails.sender, mails.subject, mails.created_at,
+			0 AS rank, coalesce(mails.subject, '') AS headline, left(coalesce(mails.body, ''), 200) AS snippet`).
+			Order("mails.created_at DESC")
+	}
+
+	var results []searchResult
+	if err := db.Limit(input.Limit).Find(&results).Error(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error searching mails"})
+		return
+	}
+	for i := range results {
+		results[i].Headline = highlightHTML(results[i].Headline)
+		results[i].Snippet = highlightHTML(results[i].Snippet)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"mails": results})
+}
+
+// highlightHTML escapes text returned by ts_headline and turns its match
+// markers into <mark> tags.
+func highlightHTML(text string) string {
+	return strings.NewReplacer(highlightStart, "<mark>", highlightStop, "</mark>").Replace(html.EscapeString(text))
+}
+
+// parseSearchQuery splits q into operators and free text. Operator values
+// and free-text phrases may be double-quoted to include spaces.
+func parseSearchQuery(q string) (searchQuery, error) {
+	var query searchQuery
+	var text []string
+
+	for _, token := range splitSearchTokens(q) {
+		key, value, ok := strings.Cut(token, ":")
+		value = strings.Trim(value, `"`)
+		if !ok || value == "" {
+			text = append(text, token)
+			continue
+		}
+
+		switch strings.ToLower(key) {
+		case "from":
+			query.From = append(query.From, value)
+		case "to":
+			query.To = append(query.To, value)
+		case "subject":
+			query.Subject = append(query.Subject, value)
+		case "before", "after":
+			t, err := parseSearchDate(value)
+			if err != nil {
+				return searchQuery{}, fmt.Errorf("invalid %s date %q", key, value)
+			}
+			if strings.ToLower(key) == "before" {
+				query.Before = t
+			} else {
+				query.After = t
+			}
+		default:
+			text = append(text, token)
+		}
+	}
+
+	query.Text = strings.Join(text, " ")
+	return query, nil
+}
+
+func splitSearchTokens(q string) []string {
+	var tokens []string
+	var current strings.Builder
+	quoted := false
+
+	for _, r := range q {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			current.WriteRune(r)
+		case unicode.IsSpace(r) && !quoted:
+			if current.Len() > 0 {
+				tokens = append(tokens, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		tokens = append(tokens, current.String())
+	}
+
+	return tokens
+}
+
+func parseSearchDate(value string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
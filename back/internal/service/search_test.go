@@ -0,0 +1,51 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func FuzzMailService_ParseSearchQuery(f *testing.F) {
+	f.Add("hello world")
+	f.Add(`from:test1@gomail.kurs subject:"weekly report" budget`)
+	f.Add("to:admin after:2024-01-01 before:2024-02-01T00:00:00Z")
+	f.Add("before:yesterday")
+	f.Add(`"unterminated phrase`)
+	f.Add("")
+
+	f.Fuzz(func(t *testing.T, q string) {
+		query, err := parseSearchQuery(q)
+		if err != nil {
+			return
+		}
+
+		for _, values := range [][]string{query.From, query.To, query.Subject} {
+			for _, v := range values {
+				assert.NotEmpty(t, v)
+				assert.False(t, strings.HasPrefix(v, `"`) || strings.HasSuffix(v, `"`))
+			}
+		}
+	})
+}
+
+func TestHighlightHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"plain", "weekly " + highlightStart + "report" + highlightStop, "weekly <mark>report</mark>"},
+		{"markup in the body", `<img src=x onerror="alert(1)"> ` + highlightStart + "report" + highlightStop,
+			"&lt;img src=x onerror=&#34;alert(1)&#34;&gt; <mark>report</mark>"},
+		{"markup in a match", highlightStart + "<b>" + highlightStop + " & more", "<mark>&lt;b&gt;</mark> &amp; more"},
+		{"no match", "<script>", "&lt;script&gt;"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, highlightHTML(tt.text))
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"backend/internal/model"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// An undo window, when set, lasts between minUndoSend and maxUndoSend
+// seconds.
+const (
+	minUndoSend = 5
+	maxUndoSend = 30
+)
+
+// settingsInput changes the given mail settings of a user.
+type settingsInput struct {
+	UndoSendSeconds *int `json:"undo_send_seconds"`
+}
+
+func (ms *mailService) GetSettings(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	var user model.User
+	if err := ms.db.Where("id = ?", userID).First(&user).Error(); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "Invalid credentials"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"undo_send_seconds": user.UndoSendSeconds})
+}
+
+// UpdateSettings changes the user's mail settings. An undo window of 0
+// turns undo send off.
+func (ms *mailService) UpdateSettings(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	var input settingsInput
+	if err := c.ShouldBindJSON(&input); err != nil || input.UndoSendSeconds == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid input"})
+		return
+	}
+	seconds := *input.UndoSendSeconds
+	if seconds != 0 && (seconds < minUndoSend || seconds > maxUndoSend) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": fmt.Sprintf("undo_send_seconds must be 0 or between %d and %d", minUndoSend, maxUndoSend),
+		})
+		return
+	}
+
+	if err := ms.db.Model(&model.User{}).
+		Where("id = ?", userID).
+		Update("undo_send_seconds", seconds).Error(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error saving settings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"undo_send_seconds": seconds})
+}
@@ -0,0 +1,149 @@
+package service
+
+import (
+	"backend/internal/model"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type threadSummary struct {
+	ThreadId    string
+	Subject     string
+	Count       int
+	FirstMailAt time.Time
+	LastMailAt  time.Time
+}
+
+// GetThreads lists the conversations of the user, the most recently active
+// first.
+func (ms *mailService) GetThreads(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	var input struct {
+		Limit  int    `form:"limit"`
+		Cursor string `form:"cursor"`
+	}
+	if err := c.ShouldBindQuery(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid query"})
+		return
+	}
+	if input.Limit <= 0 {
+		input.Limit = defaultPageLimit
+	} else if input.Limit > maxPageLimit {
+		input.Limit = maxPageLimit
+	}
+
+	db := ms.db.Model(&model.Mail{}).
+		Select(`mails.thread_id, (array_agg(mails.subject ORDER BY mails.created_at))[1] AS subject, count(*) AS count,
+			min(mails.created_at) AS first_mail_at, max(mails.created_at) AS last_mail_at`).
+		Where(visibleMailsQuery, userID, visibleFolders).
+		Group("mails.thread_id")
+
+	if input.Cursor != "" {
+		lastAt, threadID, err := decodeThreadCursor(input.Cursor)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+		db = db.Having("(max(mails.created_at), mails.thread_id) < (?, ?)", lastAt, threadID)
+	}
+
+	var threads []threadSummary
+	if err := db.Order("last_mail_at desc, mails.thread_id desc").Limit(input.Limit + 1).Find(&threads).Error(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error fetching threads"})
+		return
+	}
+
+	var next string
+	if len(threads) > input.Limit {
+		threads = threads[:input.Limit]
+		last := threads[len(threads)-1]
+		next = encodeThreadCursor(last.LastMailAt, last.ThreadId)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"threads": threads, "next_cursor": next})
+}
+
+// GetThread returns every mail of a conversation the user can see, oldest
+// first. Bcc recipients are only shown on the user's own mails.
+func (ms *mailService) GetThread(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	threadID := c.Param("id")
+
+	var mails []model.Mail
+	if err := ms.db.Preload("Attachments").
+		Preload("Recipients").
+		Where("mails.thread_id = ?", threadID).
+		Where(visibleMailsQuery, userID, visibleFolders).
+		Order("mails.created_at, mails.id").
+		Find(&mails).Error(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error fetching thread"})
+		return
+	}
+	if len(mails) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Thread not found"})
+		return
+	}
+
+	if err := ms.hideBcc(userID, mails); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Error fetching thread"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"thread_id": threadID, "mails": mails})
+}
+
+// hideBcc drops the Bcc recipients of every mail in mails that userID did
+// not send.
+func (ms *mailService) hideBcc(userID uint, mails []model.Mail) error {
+	ids := make([]uint, 0, len(mails))
+	for _, mail := range mails {
+		ids = append(ids, mail.ID)
+	}
+
+	var sent []model.Mailbox
+	if err := ms.db.Select("mail_id").
+		Where("user_id = ? AND role = ? AND mail_id IN ?", userID, model.MailboxSender, ids).
+		Find(&sent).Error(); err != nil {
+		return err
+	}
+	own := make(map[uint]bool, len(sent))
+	for _, box := range sent {
+		own[box.MailId] = true
+	}
+
+	for i := range mails {
+		if own[mails[i].ID] {
+			continue
+		}
+		mails[i].Recipients = withoutBcc(mails[i].Recipients)
+	}
+	return nil
+}
+
+func encodeThreadCursor(lastAt time.Time, threadID string) string {
+	raw := strconv.FormatInt(lastAt.UnixMicro(), 10) + ":" + threadID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeThreadCursor(cursor string) (time.Time, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", errInvalidCursor
+	}
+
+	micros, threadID, ok := strings.Cut(string(raw), ":")
+	if !ok || threadID == "" {
+		return time.Time{}, "", errInvalidCursor
+	}
+	n, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil {
+		return time.Time{}, "", errInvalidCursor
+	}
+	return time.UnixMicro(n), threadID, nil
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"backend/internal/model"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func FuzzThread_Cursor(f *testing.F) {
+	f.Add(int64(0), model.ThreadID("<a@gomail.kurs>"))
+	f.Add(time.Now().UnixMicro(), "0123456789abcdef01234567")
+	f.Add(int64(-1), "x")
+
+	f.Fuzz(func(t *testing.T, micros int64, threadID string) {
+		if threadID == "" {
+			return
+		}
+		cursor := encodeThreadCursor(time.UnixMicro(micros), threadID)
+
+		lastAt, decodedID, err := decodeThreadCursor(cursor)
+		assert.NoError(t, err)
+		assert.Equal(t, micros, lastAt.UnixMicro())
+		assert.Equal(t, threadID, decodedID)
+	})
+}
+
+func FuzzMailService_GetThread(f *testing.F) {
+	f.Add(uint(1), "0123456789abcdef01234567", true)
+	f.Add(uint(2), "missing", false)
+
+	f.Fuzz(func(t *testing.T, userID uint, threadID string, own bool) {
+		mockDB := new(MockMailDB)
+		service := NewMailService(mockDB, nil)
+
+		mails := []model.Mail{{
+			Recipients: []model.Recipient{
+				{MailId: 7, Address: "to@example.com", Type: model.RecipientTo},
+				{MailId: 7, Address: "hidden@example.com", Type: model.RecipientBcc},
+			},
+		}}
+		mails[0].ID = 7
+
+		mockDB.On("Preload", mock.Anything).Return(mockDB)
+		mockDB.On("Where", mock.Anything, mock.Anything).Return(mockDB)
+		mockDB.On("Where", mock.Anything, mock.Anything, mock.Anything).Return(mockDB)
+		mockDB.On("Where", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(mockDB)
+		mockDB.On("Order", mock.Anything).Return(mockDB)
+		mockDB.On("Select", "mail_id").Return(mockDB)
+		mockDB.On("Find", mock.AnythingOfType("*[]model.Mail")).Return(mockDB).Run(func(args mock.Arguments) {
+			if threadID != "missing" {
+				*args.Get(0).(*[]model.Mail) = mails
+			}
+		})
+		mockDB.On("Find", mock.AnythingOfType("*[]model.Mailbox")).Return(mockDB).Run(func(args mock.Arguments) {
+			if own {
+				*args.Get(0).(*[]model.Mailbox) = []model.Mailbox{{MailId: 7}}
+			}
+		})
+		mockDB.On("Error").Return(nil)
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Set("userID", userID)
+		c.Params = gin.Params{gin.Param{Key: "id", Value: threadID}}
+
+		service.GetThread(c)
+
+		if threadID == "missing" {
+			assert.Equal(t, http.StatusNotFound, w.Code)
+			return
+		}
+		assert.Equal(t, http.StatusOK, w.Code)
+		if own {
+			assert.Contains(t, w.Body.String(), "hidden@example.com")
+		} else {
+			assert.NotContains(t, w.Body.String(), "hidden@example.com")
+		}
+	})
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"backend/internal/model"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func TestMailService_DeleteMail(t *testing.T) {
+	tests := []struct {
+		name     string
+		mailID   string
+		affected int64
+		held     bool
+		code     int
+	}{
+		{"moved to the trash", "4", 1, true, http.StatusOK},
+		{"scheduled", "4", 0, true, http.StatusConflict},
+		{"not held", "4", 0, false, http.StatusNotFound},
+		{"invalid id", "not a number", 1, true, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(MockMailDB)
+			service := NewMailService(mockDB, nil)
+
+			var updates map[string]interface{}
+			mockDB.On("Model", &model.Mailbox{}).Return(mockDB)
+			mockDB.On("Where", "user_id = ? AND mail_id = ? AND folder NOT IN ?", uint(1), uint(4), []string{model.FolderDeleted, model.FolderScheduled}).Return(mockDB)
+			mockDB.On("Updates", mock.AnythingOfType("map[string]interface {}")).Return(mockDB).Run(func(args mock.Arguments) {
+				updates = args.Get(0).(map[string]interface{})
+			})
+			mockDB.On("RowsAffected").Return(tt.affected)
+			mockDB.On("Select", "count(*) > 0").Return(mockDB)
+			mockDB.On("Where", "user_id = ? AND mail_id = ? AND folder <> ?", uint(1), uint(4), model.FolderDeleted).Return(mockDB)
+			mockDB.On("Find", mock.AnythingOfType("*bool")).Return(mockDB).Run(func(args mock.Arguments) {
+				*args.Get(0).(*bool) = tt.held
+			})
+			mockDB.On("Error").Return(nil)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Set("userID", uint(1))
+			c.Params = gin.Params{gin.Param{Key: "id", Value: tt.mailID}}
+
+			service.DeleteMail(c)
+
+			assert.Equal(t, tt.code, w.Code)
+			if tt.code == http.StatusBadRequest {
+				assert.Nil(t, updates)
+				return
+			}
+
+			// A mail already in the trash is deleted for good; any other is
+			// trashed now, and one trashed before keeps its time.
+			assert.Equal(t, gorm.Expr("CASE WHEN folder = ? THEN ? ELSE ? END", model.FolderTrash, model.FolderDeleted, model.FolderTrash), updates["folder"])
+			assert.Contains(t, updates, "folder_id")
+			assert.Nil(t, updates["folder_id"])
+			if trashedAt, ok := updates["trashed_at"].(clause.Expr); assert.True(t, ok) {
+				assert.Equal(t, "CASE WHEN folder = ? THEN trashed_at ELSE ? END", trashedAt.SQL)
+				assert.Equal(t, model.FolderTrash, trashedAt.Vars[0])
+			}
+			if tt.affected > 0 {
+				mockDB.AssertNotCalled(t, "Find", mock.Anything)
+			}
+		})
+	}
+}
+
+func TestMailService_RestoreMail(t *testing.T) {
+	tests := []struct {
+		name     string
+		mailID   string
+		affected int64
+		held     bool
+		code     int
+	}{
+		{"restored", "4", 1, true, http.StatusOK},
+		{"not in the trash", "4", 0, true, http.StatusConflict},
+		{"not held", "4", 0, false, http.StatusNotFound},
+		{"invalid id", "x", 1, true, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := new(MockMailDB)
+			service := NewMailService(mockDB, nil)
+
+			var updates map[string]interface{}
+			mockDB.On("Model", &model.Mailbox{}).Return(mockDB)
+			mockDB.On("Where", "user_id = ? AND mail_id = ? AND folder = ?", uint(1), uint(4), model.FolderTrash).Return(mockDB)
+			mockDB.On("Updates", mock.AnythingOfType("map[string]interface {}")).Return(mockDB).Run(func(args mock.Arguments) {
+				updates = args.Get(0).(map[string]interface{})
+			})
+			mockDB.On("RowsAffected").Return(tt.affected)
+			mockDB.On("Select", "count(*) > 0").Return(mockDB)
+			mockDB.On("Where", "user_id = ? AND mail_id = ? AND folder <> ?", uint(1), uint(4), model.FolderDeleted).Return(mockDB)
+			mockDB.On("Find", mock.AnythingOfType("*bool")).Return(mockDB).Run(func(args mock.Arguments) {
+				*args.Get(0).(*bool) = tt.held
+			})
+			mockDB.On("Error").Return(nil)
+
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Set("userID", uint(1))
+			c.Params = gin.Params{gin.Param{Key: "id", Value: tt.mailID}}
+
+			service.RestoreMail(c)
+
+			assert.Equal(t, tt.code, w.Code)
+			if tt.code == http.StatusBadRequest {
+				assert.Nil(t, updates)
+				return
+			}
+
+			assert.Equal(t, map[string]interface{}{
+				"folder":     gorm.Expr("CASE WHEN role = ? THEN ? ELSE ? END", model.MailboxSender, model.FolderSent, model.FolderInbox),
+				"folder_id":  nil,
+				"trashed_at": nil,
+			}, updates)
+			if tt.affected > 0 {
+				mockDB.AssertNotCalled(t, "Find", mock.Anything)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+//go:build integration
+
+package service
+
+import (
+	"backend/internal/model"
+	"backend/internal/testdb"
+	"backend/utils"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// serve calls handler as userID, with mailID as the id parameter unless
+// it is zero, and returns the recorded reply.
+func serve(handler gin.HandlerFunc, userID, mailID uint, method, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Set("userID", userID)
+	if mailID != 0 {
+		c.Params = gin.Params{gin.Param{Key: "id", Value: strconv.Itoa(int(mailID))}}
+	}
+	c.Request = httptest.NewRequest(method, "/", bytes.NewBufferString(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	handler(c)
+	return w
+}
+
+// listedIDs returns the ids of the mails in a reply of a listing endpoint.
+func listedIDs(t *testing.T, w *httptest.ResponseRecorder) []uint {
+	t.Helper()
+	require.Equal(t, http.StatusOK, w.Code)
+	var reply struct {
+		Mails []struct{ ID uint } `json:"mails"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &reply))
+	ids := make([]uint, 0, len(reply.Mails))
+	for _, mail := range reply.Mails {
+		ids = append(ids, mail.ID)
+	}
+	return ids
+}
+
+// mailboxFlags returns the flags userID keeps on mailID.
+func mailboxFlags(t *testing.T, db *gorm.DB, userID, mailID uint) model.Flags {
+	t.Helper()
+	var box model.Mailbox
+	require.NoError(t, db.Where("user_id = ? AND mail_id = ?", userID, mailID).First(&box).Error)
+	return box.Flags
+}
+
+func TestMailService_HeldReply(t *testing.T) {
+	tests := []struct {
+		name     string
+		release  bool
+		answered bool
+	}{
+		{"undone", false, false},
+		{"released", true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := testdb.Open(t)
+			service := NewMailService(model.NewMailDB(db), nil)
+
+			require.NoError(t, db.Create(&model.Domain{Name: "gomail.kurs"}).Error)
+			alice := model.User{Email: "alice@gomail.kurs", Password: "x", UndoSendSeconds: 10}
+			bob := model.User{Email: "bob@gomail.kurs", Password: "x"}
+			require.NoError(t, db.Create(&alice).Error)
+			require.NoError(t, db.Create(&bob).Error)
+
+			original := model.Mail{MessageId: "<root@gomail.kurs>", Sender: bob.Email, Subject: "Plans"}
+			require.NoError(t, original.Receivers.Set([]string{alice.Email}))
+			require.NoError(t, db.Create(&original).Error)
+			require.NoError(t, db.Create(&model.Mailbox{MailId: original.ID, UserId: alice.Id, Role: model.MailboxRecipient, Folder: model.FolderInbox}).Error)
+
+			w := serve(service.ReplyMail, alice.Id, original.ID, http.MethodPost, `{"body": "Sure"}`)
+			require.Equal(t, http.StatusCreated, w.Code)
+			var sent struct {
+				ID     uint      `json:"id"`
+				SendAt time.Time `json:"send_at"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &sent))
+
+			// While held back the reply is listed as sent, not as scheduled,
+			// and only undo send can take it back.
+			assert.NotContains(t, listedIDs(t, serve(service.GetScheduled, alice.Id, 0, http.MethodGet, "")), sent.ID)
+			assert.Contains(t, listedIDs(t, serve(service.GetSentMails, alice.Id, 0, http.MethodGet, "")), sent.ID)
+			assert.Equal(t, http.StatusNotFound, serve(service.CancelScheduled, alice.Id, sent.ID, http.MethodDelete, "").Code)
+			assert.False(t, mailboxFlags(t, db, alice.Id, original.ID).Answered)
+
+			if tt.release {
+				released, err := utils.ReleaseMail(model.NewMailDB(db), sent.ID, sent.SendAt.Add(time.Second))
+				require.NoError(t, err)
+				require.True(t, released)
+				assert.Equal(t, model.FolderInbox, mailboxFolder(t, db, bob.Id, sent.ID))
+				assert.Equal(t, http.StatusConflict, serve(service.UndoSend, alice.Id, sent.ID, http.MethodPost, "").Code)
+			} else {
+				assert.Equal(t, http.StatusOK, serve(service.UndoSend, alice.Id, sent.ID, http.MethodPost, "").Code)
+				assert.NotContains(t, listedIDs(t, serve(service.GetSentMails, alice.Id, 0, http.MethodGet, "")), sent.ID)
+			}
+			assert.Equal(t, tt.answered, mailboxFlags(t, db, alice.Id, original.ID).Answered)
+		})
+	}
+}
@@ -0,0 +1,78 @@
+// Package testdb gives integration tests a PostgreSQL database of their
+// own. Tests using it are built with the integration tag and run against
+// the server named by TEST_DATABASE_DSN:
+//
+//	TEST_DATABASE_DSN="host=localhost user=postgres password=postgres dbname=mails sslmode=disable" go test -tags integration ./...
+package testdb
+
+import (
+	"backend/internal/model"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var schemas atomic.Int64
+
+// Open migrates every table into a new schema and connects to it. The
+// schema is dropped when the test ends. The test is skipped when
+// TEST_DATABASE_DSN is not set.
+func Open(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	admin, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal("Failed to connect to the test database:", err)
+	}
+	schema := fmt.Sprintf("test_%d_%d", os.Getpid(), schemas.Add(1))
+	if err := admin.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+		t.Fatal("Failed to create test schema:", err)
+	}
+
+	db, err := gorm.Open(postgres.Open(withSearchPath(dsn, schema)), &gorm.Config{Logger: logger.Discard, TranslateError: true})
+	if err != nil {
+		t.Fatal("Failed to connect to the test schema:", err)
+	}
+	t.Cleanup(func() {
+		if pool, err := db.DB(); err == nil {
+			pool.Close()
+		}
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		if pool, err := admin.DB(); err == nil {
+			pool.Close()
+		}
+	})
+
+	if err := db.AutoMigrate(model.Tables...); err != nil {
+		t.Fatal("Failed to migrate tables:", err)
+	}
+	if err := model.MigrateSearch(db); err != nil {
+		t.Fatal("Failed to migrate search index:", err)
+	}
+	if err := model.MigrateFolders(db); err != nil {
+		t.Fatal("Failed to migrate folders:", err)
+	}
+	return db
+}
+
+// withSearchPath makes connections of dsn, a URL or a list of key=value
+// settings, use schema.
+func withSearchPath(dsn, schema string) string {
+	if !strings.Contains(dsn, "://") {
+		return dsn + " search_path=" + schema
+	}
+	if strings.Contains(dsn, "?") {
+		return dsn + "&search_path=" + schema
+	}
+	return dsn + "?search_path=" + schema
+}
@@ -2,61 +2,92 @@ package main
 
 import (
 	"backend/cmd"
+	"backend/internal/config"
 	"backend/internal/model"
-	"backend/utils"
 	"flag"
 	"log"
 
-	"github.com/joho/godotenv"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
 var (
-	db *gorm.DB
+	db   *gorm.DB
+	conf *config.Config
 )
 
 func init() {
-	if err := godotenv.Load(); err != nil {
-		log.Print("No .env file found")
-	}
+	devFlag := flag.Bool("dev", false, "Run in development mode")
+	configPath := flag.String("config", "", "Path to a YAML or TOML config file")
+	flag.Parse()
 
 	var err error
-	db, err = gorm.Open(postgres.Open(utils.GetEnv("DB_CONF", "")), &gorm.Config{})
+	conf, err = config.Load(*configPath)
 	if err != nil {
-		log.Fatal("Failed to connect to the database:", err)
+		log.Fatal("Invalid configuration:\n", err)
 	}
 
-	devFlag := flag.Bool("dev", false, "Run in development mode")
-	flag.Parse()
+	db, err = gorm.Open(postgres.Open(conf.DatabaseDSN), &gorm.Config{TranslateError: true})
+	if err != nil {
+		log.Fatal("Failed to connect to the database:", err)
+	}
 
 	if *devFlag {
 		devRun()
 	} else {
-		db.AutoMigrate(&model.User{}, &model.Mail{}, &model.Trash{})
+		db.AutoMigrate(model.Tables...)
+		if err := model.MigrateMailboxes(db); err != nil {
+			log.Fatal("Failed to migrate mailboxes:", err)
+		}
+		if err := model.MigrateSearch(db); err != nil {
+			log.Fatal("Failed to migrate search index:", err)
+		}
+		if err := model.MigrateThreads(db); err != nil {
+			log.Fatal("Failed to migrate threads:", err)
+		}
+		if err := model.MigrateFolders(db); err != nil {
+			log.Fatal("Failed to migrate folders:", err)
+		}
+		if err := model.EnsureDomains(db, conf.Domain); err != nil {
+			log.Fatal("Failed to create domains:", err)
+		}
 		log.Println("Database migration completed")
 	}
 }
 
 func main() {
-	app := cmd.NewApp(db)
+	app := cmd.NewApp(db, conf)
 	app.Run()
 }
 
 func devRun() {
-	if err := db.Migrator().DropTable(&model.User{}, &model.Mail{}, &model.Trash{}); err != nil {
+	if err := db.Migrator().DropTable(model.Tables...); err != nil {
 		log.Fatal("Failed to drop tables:", err)
 	}
-	if err := db.AutoMigrate(&model.User{}, &model.Mail{}, &model.Trash{}); err != nil {
+	if err := db.AutoMigrate(model.Tables...); err != nil {
 		log.Fatal("Failed to migrate tables:", err)
 	}
+	if err := model.MigrateSearch(db); err != nil {
+		log.Fatal("Failed to migrate search index:", err)
+	}
+	if err := model.MigrateFolders(db); err != nil {
+		log.Fatal("Failed to migrate folders:", err)
+	}
+
+	domains := []model.Domain{
+		{Name: conf.Domain, RegistrationOpen: true},
+		{Name: "admin." + conf.Domain, RegistrationOpen: false},
+	}
+	if err := db.Create(&domains).Error; err != nil {
+		log.Fatal("Failed to create domains:", err)
+	}
 
 	users := []model.User{
-		{Email: "test1@gomail.kurs", Password: "12344", Role: model.RoleUser},
-		{Email: "test2@gomail.kurs", Password: "12344", Role: model.RoleUser},
-		{Email: "test3@gomail.kurs", Password: "12344", Role: model.RoleUser},
-		{Email: "admin@admin.gomail.kurs", Password: "12344adm", Role: model.RoleAdmin},
+		{Email: "test1@" + conf.Domain, Password: "12344", Role: model.RoleUser},
+		{Email: "test2@" + conf.Domain, Password: "12344", Role: model.RoleUser},
+		{Email: "test3@" + conf.Domain, Password: "12344", Role: model.RoleUser},
+		{Email: "admin@admin." + conf.Domain, Password: "12344adm", Role: model.RoleAdmin},
 	}
 
 	for _, user := range users {
@@ -72,9 +103,5 @@ func devRun() {
 		} else {
 			log.Printf("User %s created successfully", user.Email)
 		}
-
-		if err := db.Create(&model.Trash{UserId: user.Id}).Error; err != nil {
-			log.Printf("Failed to create trash for user %s: %v", user.Email, err)
-		}
 	}
 }
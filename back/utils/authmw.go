@@ -3,6 +3,7 @@ package utils
 import (
 	"backend/internal/model"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
@@ -45,3 +46,36 @@ func (mw *BasicAuthMiddleware) Middleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+type TokenAuthMiddleware struct {
+	tokens *TokenIssuer
+}
+
+func NewTokenAuthMiddleware(tokens *TokenIssuer) *TokenAuthMiddleware {
+	return &TokenAuthMiddleware{
+		tokens: tokens,
+	}
+}
+
+func (mw *TokenAuthMiddleware) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			c.Header("WWW-Authenticate", `Bearer realm="Restricted"`)
+			c.JSON(http.StatusUnauthorized, gin.H{"message": "Authorization required"})
+			c.Abort()
+			return
+		}
+
+		userID, err := mw.tokens.Verify(token)
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.JSON(http.StatusUnauthorized, gin.H{"message": "Invalid token"})
+			c.Abort()
+			return
+		}
+
+		c.Set("userID", userID)
+		c.Next()
+	}
+}
@@ -0,0 +1,205 @@
+package utils
+
+import (
+	"backend/internal/model"
+	"time"
+)
+
+// StoreMail saves the mail and fans it out into the mailboxes of the sender
+// and every local user among receivers, and queues it for every external
+// recipient. A zero senderID is used for mail coming from outside, which has
+// no local sender copy.
+func StoreMail(db model.MailDB, mail *model.Mail, senderID uint, receivers, external []string) error {
+	return db.Transaction(func(tx model.MailDB) error {
+		if err := saveMail(tx, mail); err != nil {
+			return err
+		}
+
+		var boxes []model.Mailbox
+		if senderID != 0 {
+			boxes = append(boxes, senderMailbox(mail, senderID, model.FolderSent))
+		}
+		if err := deliverMail(tx, mail, boxes, receivers, external); err != nil {
+			return err
+		}
+		if senderID == 0 || mail.SourceMailId == nil {
+			return nil
+		}
+		return flagSource(tx, mail, senderID)
+	})
+}
+
+// ScheduleMail saves a mail to be sent at mail.SendAt. Until ReleaseMail
+// sends it, only the sender has a copy, kept in folder: the scheduled
+// folder for mail sent later, or the sent folder for mail only held back
+// for the undo window.
+func ScheduleMail(db model.MailDB, mail *model.Mail, senderID uint, folder string) error {
+	return db.Transaction(func(tx model.MailDB) error {
+		if err := saveMail(tx, mail); err != nil {
+			return err
+		}
+		box := senderMailbox(mail, senderID, folder)
+		return tx.Create(&box).Error()
+	})
+}
+
+// ReleaseMail sends the scheduled mail mailID if it is due at now: the
+// sender's copy moves from the scheduled to the sent folder and the mail
+// is delivered as StoreMail does, dated now. It reports false, changing
+// nothing, when the mail is no longer scheduled or was moved to a later
+// time.
+func ReleaseMail(db model.MailDB, mailID uint, now time.Time) (bool, error) {
+	released := false
+	err := db.Transaction(func(tx model.MailDB) error {
+		res := tx.Model(&model.Mail{}).
+			Where("id = ? AND send_at <= ?", mailID, now).
+			Updates(map[string]interface{}{"send_at": nil, "created_at": now})
+		if err := res.Error(); err != nil {
+			return err
+		}
+		if res.RowsAffected() == 0 {
+			return nil
+		}
+
+		var mail model.Mail
+		if err := tx.Preload("Recipients").Where("id = ?", mailID).First(&mail).Error(); err != nil {
+			return err
+		}
+		if err := tx.Model(&model.Mailbox{}).
+			Where("mail_id = ? AND folder = ?", mailID, model.FolderScheduled).
+			Update("folder", model.FolderSent).Error(); err != nil {
+			return err
+		}
+
+		addrs := make([]string, 0, len(mail.Recipients))
+		for _, rec := range mail.Recipients {
+			addrs = append(addrs, rec.Address)
+		}
+		local, external, err := ResolveRecipients(tx, addrs)
+		if err != nil {
+			return err
+		}
+		if err := deliverMail(tx, &mail, nil, local, external); err != nil {
+			return err
+		}
+		if mail.SourceMailId != nil {
+			var sender model.Mailbox
+			if err := tx.Where("mail_id = ? AND role = ?", mailID, model.MailboxSender).First(&sender).Error(); err != nil {
+				return err
+			}
+			if err := flagSource(tx, &mail, sender.UserId); err != nil {
+				return err
+			}
+		}
+		released = true
+		return nil
+	})
+	return released, err
+}
+
+func saveMail(tx model.MailDB, mail *model.Mail) error {
+	if mail.ThreadId == "" {
+		if err := AssignThread(tx, mail); err != nil {
+			return err
+		}
+	}
+	return tx.Create(mail).Error()
+}
+
+func senderMailbox(mail *model.Mail, senderID uint, folder string) model.Mailbox {
+	return model.Mailbox{
+		MailId: mail.ID,
+		UserId: senderID,
+		Role:   model.MailboxSender,
+		Folder: folder,
+		Flags:  model.Flags{Seen: true},
+	}
+}
+
+// flagSource marks the mail that mail replies to or forwards as answered
+// or forwarded for senderID, now that mail is sent.
+func flagSource(tx model.MailDB, mail *model.Mail, senderID uint) error {
+	flag := model.FlagAnswered
+	if mail.SourceFlag == model.FlagForwarded {
+		flag = model.FlagForwarded
+	}
+	return tx.Model(&model.Mailbox{}).
+		Where("user_id = ? AND mail_id = ?", senderID, *mail.SourceMailId).
+		Update(flag, true).Error()
+}
+
+// deliverMail creates boxes along with an inbox copy for every local user
+// among receivers, and queues mail for every external recipient. A local
+// user over their quota gets no copy; a failed delivery to them is
+// recorded instead, so the sender can tell.
+func deliverMail(tx model.MailDB, mail *model.Mail, boxes []model.Mailbox, receivers, external []string) error {
+	var deliveries []model.Delivery
+	if len(receivers) > 0 {
+		var users []model.User
+		if err := tx.Where("email IN ?", receivers).Find(&users).Error(); err != nil {
+			return err
+		}
+		full, err := overQuota(tx, users, MailSize(mail))
+		if err != nil {
+			return err
+		}
+
+		for _, user := range users {
+			if full[user.Id] {
+				deliveries = append(deliveries, model.Delivery{
+					MailId:        mail.ID,
+					Recipient:     user.Email,
+					Status:        model.DeliveryFailed,
+					LastError:     ErrQuotaExceeded.Error(),
+					NextAttemptAt: mail.CreatedAt,
+				})
+				continue
+			}
+			boxes = append(boxes, model.Mailbox{
+				MailId: mail.ID,
+				UserId: user.Id,
+				Role:   model.MailboxRecipient,
+				Folder: model.FolderInbox,
+			})
+		}
+	}
+
+	if len(boxes) > 0 {
+		if err := tx.Create(&boxes).Error(); err != nil {
+			return err
+		}
+	}
+
+	for _, rec := range external {
+		deliveries = append(deliveries, model.Delivery{
+			MailId:        mail.ID,
+			Recipient:     rec,
+			Status:        model.DeliveryQueued,
+			NextAttemptAt: mail.CreatedAt,
+		})
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return tx.Create(&deliveries).Error()
+}
+
+// NewRecipients describes addrs as recipients of the header typ.
+func NewRecipients(typ string, addrs []string) []model.Recipient {
+	recs := make([]model.Recipient, 0, len(addrs))
+	for _, addr := range addrs {
+		recs = append(recs, model.Recipient{Address: addr, Type: typ})
+	}
+	return recs
+}
+
+// RecipientAddresses returns the addresses of recs sent in the header typ.
+func RecipientAddresses(recs []model.Recipient, typ string) []string {
+	var addrs []string
+	for _, rec := range recs {
+		if rec.Type == typ {
+			addrs = append(addrs, rec.Address)
+		}
+	}
+	return addrs
+}
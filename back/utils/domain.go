@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"backend/internal/model"
+	"log"
+	"net/mail"
+	"strings"
+)
+
+// ParseAddress returns the bare, lower-cased form of a single address, which
+// may carry a display name.
+func ParseAddress(addr string) (string, bool) {
+	parsed, err := mail.ParseAddress(strings.TrimSpace(addr))
+	if err != nil {
+		return "", false
+	}
+	return strings.ToLower(parsed.Address), true
+}
+
+// AddressDomain returns the part of a bare address after the last "@".
+func AddressDomain(addr string) string {
+	return addr[strings.LastIndex(addr, "@")+1:]
+}
+
+// ResolveRecipients splits addrs by the domains hosted in db. Addresses of
+// existing local users are returned in local, addresses of other domains in
+// external. Mail to an unknown user of a hosted domain goes to the domain's
+// catch-all address, or is dropped when it has none. Invalid addresses are
+// skipped.
+func ResolveRecipients(db model.MailDB, addrs []string) (local, external []string, err error) {
+	var names []string
+	byDomain := make(map[string][]string)
+	seen := make(map[string]bool)
+	for _, addr := range addrs {
+		addr, ok := ParseAddress(addr)
+		if !ok || seen[addr] {
+			continue
+		}
+		seen[addr] = true
+
+		name := AddressDomain(addr)
+		if _, ok := byDomain[name]; !ok {
+			names = append(names, name)
+		}
+		byDomain[name] = append(byDomain[name], addr)
+	}
+	if len(names) == 0 {
+		return nil, nil, nil
+	}
+
+	var domains []model.Domain
+	if err := db.Where("name IN ?", names).Find(&domains).Error(); err != nil {
+		return nil, nil, err
+	}
+	hosted := make(map[string]model.Domain, len(domains))
+	var candidates []string
+	for _, d := range domains {
+		hosted[d.Name] = d
+		candidates = append(candidates, byDomain[d.Name]...)
+	}
+
+	exists := make(map[string]bool)
+	if len(candidates) > 0 {
+		var users []model.User
+		if err := db.Where("email IN ?", candidates).Find(&users).Error(); err != nil {
+			return nil, nil, err
+		}
+		for _, user := range users {
+			exists[strings.ToLower(user.Email)] = true
+		}
+	}
+
+	added := make(map[string]bool)
+	for _, name := range names {
+		d, ok := hosted[name]
+		if !ok {
+			external = append(external, byDomain[name]...)
+			continue
+		}
+
+		for _, addr := range byDomain[name] {
+			target := addr
+			if !exists[addr] {
+				if d.CatchAll == "" {
+					log.Printf("Dropping mail to unknown local address %s", addr)
+					continue
+				}
+				target = d.CatchAll
+			}
+			if !added[target] {
+				added[target] = true
+				local = append(local, target)
+			}
+		}
+	}
+	return local, external, nil
+}
@@ -1,11 +0,0 @@
-package utils
-
-import "os"
-
-func GetEnv(key string, defaultVal string) string {
-	if value, exists := os.LookupEnv(key); exists {
-		return value
-	}
-
-	return defaultVal
-}
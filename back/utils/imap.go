@@ -1,170 +1,450 @@
 package utils
 
 import (
+	"backend/internal/config"
 	"backend/internal/model"
-	"bytes"
+	"context"
 	"crypto/tls"
+	"errors"
+	"fmt"
 	"io"
 	"log"
-	"mime"
-	"mime/multipart"
-	"net/mail"
+	"math/rand"
+	"net"
 	"strings"
+	"time"
 
 	"github.com/emersion/go-imap"
 	"github.com/emersion/go-imap/client"
-	"golang.org/x/net/html"
+	"github.com/emersion/go-message"
+	_ "github.com/emersion/go-message/charset"
+	"github.com/emersion/go-message/mail"
+	"gorm.io/gorm"
 )
 
-func ReadMailIMAP(db model.MailDB) error {
-	var (
-		imapHost = GetEnv("IMAP_HOST", "")
-		imapUser = GetEnv("IMAP_USER", "")
-		imapPass = GetEnv("MAIL_PASS", "")
-	)
+// errMalformedMessage marks a message whose MIME structure cannot be read.
+var errMalformedMessage = errors.New("malformed message")
 
-	c, err := client.DialTLS(imapHost, &tls.Config{})
+const (
+	imapInbox = "INBOX"
+
+	imapMinBackoff = time.Second
+	imapMaxBackoff = 5 * time.Minute
+
+	// imapDialTimeout bounds connecting to the server and reading its
+	// greeting.
+	imapDialTimeout = 30 * time.Second
+)
+
+// ImapWorker keeps one IMAP session open and imports new INBOX messages as
+// they arrive. It waits with IDLE when the server supports it and polls every
+// interval otherwise. Each import only fetches messages newer than the last
+// imported UID, so restarts never duplicate or lose mail, and messages are
+// deduplicated on Message-ID. What happens to an imported message on the
+// server is set by conf.AfterImport: keep it, move it to
+// conf.ProcessedFolder, or delete it. Messages without a local recipient are
+// dropped, or moved to conf.QuarantineFolder when it is set.
+type ImapWorker struct {
+	db    model.MailDB
+	store *AttachmentStore
+	conf  config.IMAPConfig
+}
+
+func NewImapWorker(db model.MailDB, store *AttachmentStore, conf config.IMAPConfig) *ImapWorker {
+	return &ImapWorker{
+		db:    db,
+		store: store,
+		conf:  conf,
+	}
+}
+
+// Run reconnects with jittered exponential backoff until ctx is cancelled.
+func (w *ImapWorker) Run(ctx context.Context) {
+	backoff := imapMinBackoff
+	for ctx.Err() == nil {
+		started := time.Now()
+		err := w.session(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if time.Since(started) > imapMaxBackoff {
+			backoff = imapMinBackoff
+		}
+		log.Println("Error reading emails:", err)
+
+		delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		backoff = min(backoff*2, imapMaxBackoff)
+	}
+}
+
+func (w *ImapWorker) session(ctx context.Context) error {
+	c, err := client.DialWithDialerTLS(&net.Dialer{Timeout: imapDialTimeout}, w.conf.Host, &tls.Config{})
 	if err != nil {
 		log.Println("Failed to connect to IMAP server:", err)
 		return err
 	}
+
+	done := make(chan struct{})
+	defer close(done)
+	// Commands do not take ctx, so the connection is closed under them
+	// when it is cancelled.
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Terminate()
+		case <-done:
+		}
+	}()
+
+	// The client blocks on Updates, so they are drained for the whole
+	// session and folded into a single pending wake-up.
+	updates := make(chan client.Update, 16)
+	wake := make(chan struct{}, 1)
+	go func() {
+		for {
+			select {
+			case u := <-updates:
+				if _, ok := u.(*client.MailboxUpdate); ok {
+					select {
+					case wake <- struct{}{}:
+					default:
+					}
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	c.Updates = updates
 	defer c.Logout()
 
-	if err := c.Login(imapUser, imapPass); err != nil {
+	if err := c.Login(w.conf.User, w.conf.Password); err != nil {
 		log.Println("Failed to login to IMAP server:", err)
 		return err
 	}
 
-	mbox, err := c.Select("INBOX", false)
+	idle, err := c.Support("IDLE")
+	if err != nil {
+		return err
+	}
+
+	account := w.conf.User + "@" + w.conf.Host
+	for {
+		if err := w.syncMailbox(c, account); err != nil {
+			return err
+		}
+
+		if !idle {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(w.conf.PollInterval):
+			}
+			continue
+		}
+
+		stop := make(chan struct{})
+		idleDone := make(chan error, 1)
+		go func() {
+			idleDone <- c.Idle(stop, nil)
+		}()
+
+		select {
+		case <-wake:
+			close(stop)
+			if err := <-idleDone; err != nil {
+				return err
+			}
+		case err := <-idleDone:
+			if err == nil {
+				err = errors.New("IDLE ended unexpectedly")
+			}
+			return err
+		case <-ctx.Done():
+			close(stop)
+			<-idleDone
+			return nil
+		}
+	}
+}
+
+func (w *ImapWorker) syncMailbox(c *client.Client, account string) error {
+	readOnly := w.conf.AfterImport == config.ImapKeep && w.conf.QuarantineFolder == ""
+	mbox, err := c.Select(imapInbox, readOnly)
 	if err != nil {
 		log.Println("Failed to select INBOX:", err)
 		return err
 	}
-	if mbox.Messages == 0 {
-		log.Println("No messages found")
+
+	state, err := loadImapState(w.db, account, mbox.UidValidity)
+	if err != nil {
+		return err
+	}
+	if mbox.Messages == 0 || mbox.UidNext != 0 && mbox.UidNext <= state.LastUid+1 {
 		return nil
 	}
 
 	seqSet := new(imap.SeqSet)
-	seqSet.AddRange(1, mbox.Messages)
+	seqSet.AddRange(state.LastUid+1, 0)
 
-	section := imap.BodySectionName{}
-	items := []imap.FetchItem{section.FetchItem()}
+	section := &imap.BodySectionName{Peek: true}
+	items := []imap.FetchItem{imap.FetchUid, section.FetchItem()}
 
 	messages := make(chan *imap.Message, 10)
 	done := make(chan error, 1)
 	go func() {
-		done <- c.Fetch(seqSet, items, messages)
+		done <- c.UidFetch(seqSet, items, messages)
 	}()
 
+	imported, unroutable := new(imap.SeqSet), new(imap.SeqSet)
+	var importErr error
 	for msg := range messages {
-		if msg == nil {
-			log.Println("Server didn't return message")
+		// "n:*" always matches the last message, even when it was imported.
+		if msg == nil || msg.Uid <= state.LastUid || importErr != nil {
 			continue
 		}
 
-		for _, value := range msg.Body {
-			reader, err := mail.ReadMessage(value)
-			if err != nil {
-				log.Println("Failed to read mail message:", err)
-				continue
-			}
+		body := msg.GetBody(section)
+		if body == nil {
+			log.Println("Server didn't return message body")
+			continue
+		}
 
-			header := reader.Header
-			from := header.Get("From")
-			to := header.Get("To")
-			subject := header.Get("Subject")
+		fallbackID := fmt.Sprintf("<%d.%d.%s>", mbox.UidValidity, msg.Uid, account)
+		routed, err := importMessage(w.db, w.store, body, fallbackID)
+		if err != nil {
+			log.Println("Failed to store mail:", err)
+			importErr = err
+			continue
+		}
 
-			body, err := extractEmailBody(reader)
-			if err != nil {
-				log.Println("Failed to extract mail body:", err)
-				continue
-			}
+		state.LastUid = msg.Uid
+		if err := w.db.Model(&model.ImapState{}).Where("id = ?", state.Id).Update("last_uid", state.LastUid).Error(); err != nil {
+			importErr = err
+			continue
+		}
+		if routed || w.conf.QuarantineFolder == "" {
+			imported.AddNum(msg.Uid)
+		} else {
+			unroutable.AddNum(msg.Uid)
+		}
+	}
+	if err := <-done; err != nil {
+		return err
+	}
 
-			mailRecord := model.Mail{
-				Sender:  from,
-				Subject: subject,
-				Body:    string(body),
+	if !imported.Empty() {
+		switch w.conf.AfterImport {
+		case config.ImapMove:
+			if err := c.UidMove(imported, w.conf.ProcessedFolder); err != nil {
+				log.Println("Failed to move imported messages:", err)
 			}
-
-			to = strings.TrimSpace(strings.Split(to, " ")[0])
-			mailRecord.Receivers.Set(to)
-			db.Create(&mailRecord)
-
-			delSeqSet := new(imap.SeqSet)
-			delSeqSet.AddNum(msg.SeqNum)
-			if err := c.Store(delSeqSet, imap.FormatFlagsOp(imap.AddFlags, true), []interface{}{imap.DeletedFlag}, nil); err != nil {
-				log.Println("Failed to mark message for deletion:", err)
-				continue
+		case config.ImapDelete:
+			flags := []interface{}{imap.DeletedFlag}
+			if err := c.UidStore(imported, imap.FormatFlagsOp(imap.AddFlags, true), flags, nil); err != nil {
+				log.Println("Failed to mark messages for deletion:", err)
+			} else if err := c.Expunge(nil); err != nil {
+				log.Println("Failed to expunge messages:", err)
 			}
 		}
 	}
 
-	if err := c.Expunge(nil); err != nil {
-		log.Println("Failed to expunge messages:", err)
+	if !unroutable.Empty() {
+		if err := c.UidMove(unroutable, w.conf.QuarantineFolder); err != nil {
+			log.Println("Failed to quarantine messages:", err)
+		}
 	}
 
-	return <-done
+	return importErr
 }
 
-func extractEmailBody(msg *mail.Message) (string, error) {
-	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+// loadImapState returns the sync position of account's INBOX, starting over
+// when the server reports a new UIDVALIDITY.
+func loadImapState(db model.MailDB, account string, uidValidity uint32) (*model.ImapState, error) {
+	var state model.ImapState
+	err := db.Where("account = ? AND mailbox = ?", account, imapInbox).First(&state).Error()
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		state = model.ImapState{Account: account, Mailbox: imapInbox, UidValidity: uidValidity}
+		return &state, db.Create(&state).Error()
+	} else if err != nil {
+		return nil, err
+	}
+
+	if state.UidValidity != uidValidity {
+		log.Printf("UIDVALIDITY of %s changed, resyncing", account)
+		state.UidValidity, state.LastUid = uidValidity, 0
+		if err := db.Model(&model.ImapState{}).Where("id = ?", state.Id).
+			Updates(map[string]interface{}{"uid_validity": uidValidity, "last_uid": 0}).Error(); err != nil {
+			return nil, err
+		}
+	}
+	return &state, nil
+}
+
+// importMessage stores one raw message unless a mail with the same
+// Message-ID was already imported, and reports whether the message was
+// routed. Messages that cannot be parsed or have no local recipient are not
+// routed and not stored, since fetching them again would not help. Any
+// other error is returned so the message is fetched again; the attachment
+// files stored for it are removed first.
+func importMessage(db model.MailDB, store *AttachmentStore, raw io.Reader, fallbackID string) (bool, error) {
+	reader, err := mail.CreateReader(raw)
+	if err != nil && !message.IsUnknownCharset(err) {
+		log.Println("Failed to read mail message:", err)
+		return false, nil
+	}
+
+	header := reader.Header
+	messageID := fallbackID
+	if id, err := header.MessageID(); err == nil && id != "" {
+		messageID = "<" + id + ">"
+	}
+
+	var exists bool
+	if err := db.Model(&model.Mail{}).Select("count(*) > 0").Where("message_id = ?", messageID).Find(&exists).Error(); err != nil {
+		return false, err
+	}
+	if exists {
+		return true, nil
+	}
+
+	to := headerRecipients(header, "To")
+	cc := headerRecipients(header, "Cc")
+	local, _, err := ResolveRecipients(db, headerRecipients(header, "To", "Cc", "Delivered-To", "X-Original-To"))
 	if err != nil {
-		return "", err
+		return false, err
+	}
+	if len(local) == 0 {
+		log.Printf("No local recipient for %s", messageID)
+		return false, nil
+	}
+
+	from := header.Get("From")
+	subject, err := header.Subject()
+	if err != nil {
+		subject = header.Get("Subject")
+	}
+
+	body, htmlBody, attachments, err := extractEmailBody(reader, store)
+	if err != nil {
+		removeUnused(db, store, storageKeys(attachments), time.Now())
+		if errors.Is(err, errMalformedMessage) {
+			log.Printf("Failed to read mail message %s: %v", messageID, err)
+			return false, nil
+		}
+		return false, err
+	}
+
+	var inReplyTo string
+	if ids, err := header.MsgIDList("In-Reply-To"); err == nil && len(ids) > 0 {
+		inReplyTo = "<" + ids[0] + ">"
+	}
+	references, _ := header.MsgIDList("References")
+
+	mailRecord := model.Mail{
+		MessageId:   messageID,
+		InReplyTo:   inReplyTo,
+		References:  FormatMessageIDs(references),
+		Sender:      from,
+		Subject:     subject,
+		Body:        body,
+		HTMLBody:    htmlBody,
+		Recipients:  append(NewRecipients(model.RecipientTo, to), NewRecipients(model.RecipientCc, cc)...),
+		Attachments: attachments,
+	}
+	mailRecord.Receivers.Set(append(to, cc...))
+
+	if err := StoreMail(db, &mailRecord, 0, local, nil); err != nil {
+		removeUnused(db, store, storageKeys(attachments), time.Now())
+		return false, err
 	}
+	return true, nil
+}
 
-	if !strings.HasPrefix(mediaType, "multipart/") {
-		body, err := io.ReadAll(msg.Body)
-		return string(body), err
+func storageKeys(atts []model.Attachment) []string {
+	keys := make([]string, 0, len(atts))
+	for _, att := range atts {
+		keys = append(keys, att.StorageKey)
 	}
+	return keys
+}
 
-	mr := multipart.NewReader(msg.Body, params["boundary"])
+// extractEmailBody reads every part of the message: text parts become the
+// plain-text and sanitized HTML bodies and everything else is saved to store
+// as an attachment. A message without a plain-text part gets one rendered
+// from its HTML. On error the attachments stored so far are returned with
+// it; errors reading the message itself wrap errMalformedMessage.
+func extractEmailBody(mr *mail.Reader, store *AttachmentStore) (string, string, []model.Attachment, error) {
 	var plainTextBody, htmlBody string
+	var attachments []model.Attachment
 
 	for {
 		part, err := mr.NextPart()
-		if err != nil {
+		if err == io.EOF {
 			break
+		} else if err != nil && !message.IsUnknownCharset(err) {
+			return "", "", attachments, fmt.Errorf("%w: %v", errMalformedMessage, err)
 		}
 
-		partMediaType, _, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
-		if err != nil {
-			continue
-		}
+		switch h := part.Header.(type) {
+		case *mail.InlineHeader:
+			mediaType, params, _ := h.ContentType()
+			if !strings.HasPrefix(mediaType, "text/plain") && !strings.HasPrefix(mediaType, "text/html") {
+				att, err := store.Put(params["name"], mediaType, messageReader{part.Body})
+				if err != nil {
+					return "", "", attachments, err
+				}
+				attachments = append(attachments, att)
+				continue
+			}
 
-		partBody, err := io.ReadAll(part)
-		if err != nil {
-			continue
-		}
+			partBody, err := io.ReadAll(part.Body)
+			if err != nil {
+				continue
+			}
 
-		if strings.HasPrefix(partMediaType, "text/plain") {
-			plainTextBody = string(partBody)
-		} else if strings.HasPrefix(partMediaType, "text/html") {
-			htmlBody = string(partBody)
+			if strings.HasPrefix(mediaType, "text/plain") && plainTextBody == "" {
+				plainTextBody = string(partBody)
+			} else if strings.HasPrefix(mediaType, "text/html") && htmlBody == "" {
+				htmlBody = string(partBody)
+			}
+		case *mail.AttachmentHeader:
+			filename, _ := h.Filename()
+			mediaType, _, _ := h.ContentType()
+
+			att, err := store.Put(filename, mediaType, messageReader{part.Body})
+			if err != nil {
+				return "", "", attachments, err
+			}
+			attachments = append(attachments, att)
 		}
 	}
 
-	if htmlBody != "" {
-		return stripHTML(htmlBody), nil
+	if htmlBody == "" {
+		return plainTextBody, "", attachments, nil
 	}
-	return plainTextBody, nil
+	if plainTextBody == "" {
+		plainTextBody = HTMLToText(htmlBody)
+	}
+	return plainTextBody, SanitizeHTML(htmlBody), attachments, nil
 }
 
-func stripHTML(htmlStr string) string {
-	doc, err := html.Parse(strings.NewReader(htmlStr))
-	if err != nil {
-		return htmlStr
-	}
-	var buf bytes.Buffer
-	var f func(*html.Node)
-	f = func(n *html.Node) {
-		if n.Type == html.TextNode {
-			buf.WriteString(n.Data)
-		}
-		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			f(c)
-		}
+// messageReader reads a part of a message, telling errors decoding it
+// apart from errors storing it.
+type messageReader struct {
+	r io.Reader
+}
+
+func (m messageReader) Read(p []byte) (int, error) {
+	n, err := m.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = fmt.Errorf("%w: %v", errMalformedMessage, err)
 	}
-	f(doc)
-	return buf.String()
+	return n, err
 }
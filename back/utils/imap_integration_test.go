@@ -0,0 +1,146 @@
+//go:build integration
+
+package utils
+
+import (
+	"backend/internal/model"
+	"backend/internal/testdb"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rawMessage joins lines into a message with CRLF line endings.
+func rawMessage(lines ...string) string {
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+func TestImportMessage(t *testing.T) {
+	report := "quarterly numbers"
+	sum := sha256.Sum256([]byte(report))
+	reportKey := hex.EncodeToString(sum[:])
+
+	withReport := func(messageID, to, tail string) string {
+		return rawMessage(
+			"From: Carol <carol@example.com>",
+			"To: "+to,
+			"Subject: Numbers",
+			"Message-ID: "+messageID,
+			"Content-Type: multipart/mixed; boundary=b",
+			"",
+			"--b",
+			"Content-Type: text/plain",
+			"",
+			"See attached.",
+			"--b",
+			"Content-Type: application/octet-stream",
+			"Content-Disposition: attachment; filename=report.txt",
+			"",
+			report,
+		) + tail
+	}
+
+	tests := []struct {
+		name   string
+		raw    string
+		routed bool
+		// stored is whether a mail with the message's Message-ID is kept,
+		// and kept whether the attachment file is.
+		stored bool
+		kept   bool
+	}{
+		{"routed", withReport("<routed@example.com>", "alice@gomail.kurs", "--b--\r\n"), true, true, true},
+		{"no local recipient", withReport("<external@example.com>", "dave@example.com", "--b--\r\n"), false, false, false},
+		{"truncated after an attachment", withReport("<truncated@example.com>", "alice@gomail.kurs", "--b\r\nContent-Type: application/pdf\r\n\r\n%PDF"), false, false, false},
+		{"unreadable header", "not a header\r\n\r\nbody", false, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gdb := testdb.Open(t)
+			db := model.NewMailDB(gdb)
+			store, err := NewAttachmentStore(t.TempDir())
+			require.NoError(t, err)
+			require.NoError(t, gdb.Create(&model.Domain{Name: "gomail.kurs"}).Error)
+			alice := model.User{Email: "alice@gomail.kurs", Password: "x"}
+			require.NoError(t, gdb.Create(&alice).Error)
+
+			routed, err := importMessage(db, store, strings.NewReader(tt.raw), "<fallback@example.com>")
+			require.NoError(t, err)
+			assert.Equal(t, tt.routed, routed)
+
+			var mails []model.Mail
+			require.NoError(t, gdb.Preload("Attachments").Find(&mails).Error)
+			if tt.stored {
+				require.Len(t, mails, 1)
+				assert.Equal(t, "Numbers", mails[0].Subject)
+				assert.Equal(t, "See attached.", strings.TrimSpace(mails[0].Body))
+				if assert.Len(t, mails[0].Attachments, 1) {
+					assert.Equal(t, "report.txt", mails[0].Attachments[0].Filename)
+				}
+				var boxes []model.Mailbox
+				require.NoError(t, gdb.Where("mail_id = ?", mails[0].ID).Find(&boxes).Error)
+				if assert.Len(t, boxes, 1) {
+					assert.Equal(t, alice.Id, boxes[0].UserId)
+				}
+
+				// The same message fetched again is not stored twice.
+				routed, err := importMessage(db, store, strings.NewReader(tt.raw), "<fallback@example.com>")
+				require.NoError(t, err)
+				assert.True(t, routed)
+				var count int64
+				require.NoError(t, gdb.Model(&model.Mail{}).Count(&count).Error)
+				assert.Equal(t, int64(1), count)
+			} else {
+				assert.Empty(t, mails)
+			}
+
+			_, err = os.Stat(store.path(reportKey))
+			assert.Equal(t, tt.kept, err == nil)
+		})
+	}
+}
+
+func TestLoadImapState(t *testing.T) {
+	gdb := testdb.Open(t)
+	db := model.NewMailDB(gdb)
+	const account = "import@imap.example.com"
+
+	state, err := loadImapState(db, account, 7)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(7), state.UidValidity)
+	assert.Zero(t, state.LastUid)
+	require.NoError(t, gdb.Model(&model.ImapState{}).Where("id = ?", state.Id).Update("last_uid", 42).Error)
+
+	tests := []struct {
+		name        string
+		uidValidity uint32
+		lastUid     uint32
+	}{
+		{"same UIDVALIDITY", 7, 42},
+		{"UIDVALIDITY changed", 8, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadImapState(db, account, tt.uidValidity)
+			require.NoError(t, err)
+			assert.Equal(t, state.Id, got.Id)
+			assert.Equal(t, tt.uidValidity, got.UidValidity)
+			assert.Equal(t, tt.lastUid, got.LastUid)
+
+			var stored model.ImapState
+			require.NoError(t, gdb.Where("id = ?", state.Id).First(&stored).Error)
+			assert.Equal(t, tt.uidValidity, stored.UidValidity)
+			assert.Equal(t, tt.lastUid, stored.LastUid)
+		})
+	}
+
+	var count int64
+	require.NoError(t, gdb.Model(&model.ImapState{}).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+}
@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"mime"
+	netmail "net/mail"
+	"strings"
+
+	"github.com/emersion/go-message/charset"
+	"github.com/emersion/go-message/mail"
+)
+
+// inboundAddressParser decodes display names in any charset go-message
+// knows, not only UTF-8 and Latin-1.
+var inboundAddressParser = netmail.AddressParser{
+	WordDecoder: &mime.WordDecoder{CharsetReader: charset.Reader},
+}
+
+// headerRecipients returns the bare addresses listed in the given header
+// fields, in order and without duplicates. An address whose display name is
+// itself an address, as in "user@gomail.kurs" <relay@external>, is mail
+// relayed through an external account for the named local user, and the
+// display name is used instead.
+func headerRecipients(header mail.Header, fields ...string) []string {
+	var addrs []string
+	seen := make(map[string]bool)
+	for _, field := range fields {
+		for _, value := range header.Values(field) {
+			for _, addr := range parseAddressList(value) {
+				if relayed, ok := ParseAddress(addr.Name); ok {
+					addr.Address = relayed
+				}
+				bare := strings.ToLower(addr.Address)
+				if !seen[bare] {
+					seen[bare] = true
+					addrs = append(addrs, bare)
+				}
+			}
+		}
+	}
+	return addrs
+}
+
+// parseAddressList parses a header value as an address list. Malformed
+// lists are parsed entry by entry, so one broken address does not hide the
+// others.
+func parseAddressList(value string) []*netmail.Address {
+	if strings.TrimSpace(value) == "" {
+		return nil
+	}
+
+	list, err := inboundAddressParser.ParseList(value)
+	if err == nil {
+		return list
+	}
+
+	for _, part := range strings.Split(value, ",") {
+		if addr, err := inboundAddressParser.Parse(part); err == nil {
+			list = append(list, addr)
+		}
+	}
+	return list
+}
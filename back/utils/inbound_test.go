@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/emersion/go-message/mail"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAddressList(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  []string
+	}{
+		{"empty", "  ", nil},
+		{"single", "bob@gomail.kurs", []string{"bob@gomail.kurs"}},
+		{"display names", `Bob <bob@gomail.kurs>, "Carol, C." <carol@example.com>`, []string{"bob@gomail.kurs", "carol@example.com"}},
+		{"encoded display name", "=?KOI8-R?B?8NLJ18XU?= <bob@gomail.kurs>", []string{"bob@gomail.kurs"}},
+		{"broken entry skipped", "bob@gomail.kurs, not an address, carol@example.com", []string{"bob@gomail.kurs", "carol@example.com"}},
+		{"nothing valid", "undisclosed-recipients", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, addr := range parseAddressList(tt.value) {
+				got = append(got, addr.Address)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestHeaderRecipients(t *testing.T) {
+	tests := []struct {
+		name   string
+		header map[string][]string
+		fields []string
+		want   []string
+	}{
+		{
+			name:   "lower-cased",
+			header: map[string][]string{"To": {"Bob <Bob@GoMail.kurs>"}},
+			fields: []string{"To"},
+			want:   []string{"bob@gomail.kurs"},
+		},
+		{
+			name:   "relayed for a local user",
+			header: map[string][]string{"To": {`"bob@gomail.kurs" <relay@external.example>`}},
+			fields: []string{"To"},
+			want:   []string{"bob@gomail.kurs"},
+		},
+		{
+			name:   "display name that is not an address",
+			header: map[string][]string{"To": {`"Bob at work" <bob@external.example>`}},
+			fields: []string{"To"},
+			want:   []string{"bob@external.example"},
+		},
+		{
+			name: "fields in order without duplicates",
+			header: map[string][]string{
+				"To":            {"bob@gomail.kurs, carol@example.com"},
+				"Cc":            {"Carol <CAROL@example.com>", "dave@gomail.kurs"},
+				"Delivered-To":  {"erin@gomail.kurs"},
+				"X-Original-To": {"bob@gomail.kurs", "frank@gomail.kurs"},
+				"Reply-To":      {"ignored@example.com"},
+			},
+			fields: []string{"To", "Cc", "Delivered-To", "X-Original-To"},
+			want:   []string{"bob@gomail.kurs", "carol@example.com", "dave@gomail.kurs", "erin@gomail.kurs", "frank@gomail.kurs"},
+		},
+		{
+			name: "only the given fields",
+			header: map[string][]string{
+				"To":           {"bob@gomail.kurs"},
+				"Delivered-To": {"erin@gomail.kurs"},
+			},
+			fields: []string{"To", "Cc"},
+			want:   []string{"bob@gomail.kurs"},
+		},
+		{
+			name:   "missing fields",
+			header: map[string][]string{"Subject": {"Hi"}},
+			fields: []string{"To", "Cc"},
+			want:   nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var header mail.Header
+			for _, field := range []string{"To", "Cc", "Delivered-To", "X-Original-To", "Reply-To", "Subject"} {
+				for _, value := range tt.header[field] {
+					header.Add(field, value)
+				}
+			}
+			assert.Equal(t, tt.want, headerRecipients(header, tt.fields...))
+		})
+	}
+}
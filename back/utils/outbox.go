@@ -0,0 +1,149 @@
+package utils
+
+import (
+	"backend/internal/model"
+	"context"
+	"errors"
+	"log"
+	"net/textproto"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	outboxInterval    = 5 * time.Second
+	outboxBatch       = 50
+	outboxLease       = 5 * time.Minute
+	outboxMaxAttempts = 8
+	outboxBaseBackoff = time.Minute
+	outboxMaxBackoff  = 6 * time.Hour
+)
+
+// OutboxWorker delivers queued mail to external recipients, retrying
+// temporary failures with exponential backoff.
+type OutboxWorker struct {
+	db        model.MailDB
+	transport Transport
+}
+
+func NewOutboxWorker(db model.MailDB, transport Transport) *OutboxWorker {
+	return &OutboxWorker{
+		db:        db,
+		transport: transport,
+	}
+}
+
+func (w *OutboxWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(outboxInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := w.DeliverDue(); err != nil {
+			log.Println("Error delivering queued mail:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DeliverDue sends every delivery whose next attempt is due. Each
+// recipient gets a transport call of its own, so one rejected address
+// does not bounce or delay the others.
+func (w *OutboxWorker) DeliverDue() error {
+	var due []model.Delivery
+	if err := w.db.Where("status IN ? AND next_attempt_at <= ?",
+		[]string{model.DeliveryQueued, model.DeliveryDeferred}, time.Now()).
+		Order("next_attempt_at").
+		Limit(outboxBatch).
+		Find(&due).Error(); err != nil {
+		return err
+	}
+
+	var order []uint
+	byMail := make(map[uint][]model.Delivery)
+	for _, d := range due {
+		if !w.claim(d) {
+			continue
+		}
+		if _, ok := byMail[d.MailId]; !ok {
+			order = append(order, d.MailId)
+		}
+		byMail[d.MailId] = append(byMail[d.MailId], d)
+	}
+
+	for _, mailID := range order {
+		w.deliver(mailID, byMail[mailID])
+	}
+	return nil
+}
+
+// claim pushes the next attempt of d forward by a lease, so another worker
+// polling at the same time skips it. It reports whether this worker won.
+func (w *OutboxWorker) claim(d model.Delivery) bool {
+	tx := w.db.Model(&model.Delivery{}).
+		Where("id = ? AND next_attempt_at = ?", d.Id, d.NextAttemptAt).
+		Update("next_attempt_at", time.Now().Add(outboxLease))
+	return tx.Error() == nil && tx.RowsAffected() == 1
+}
+
+func (w *OutboxWorker) deliver(mailID uint, deliveries []model.Delivery) {
+	var mail model.Mail
+	if err := w.db.Preload("Attachments").Preload("Recipients").First(&mail, mailID).Error(); err != nil {
+		log.Printf("Failed to load queued mail %d: %v", mailID, err)
+		return
+	}
+
+	for _, d := range deliveries {
+		w.record(d, w.transport.Send(mail, []string{d.Recipient}))
+	}
+}
+
+// record stores the outcome of an attempt at d: sent, failed for good, or
+// deferred to a later attempt.
+func (w *OutboxWorker) record(d model.Delivery, sendErr error) {
+	now := time.Now()
+	updates := map[string]interface{}{
+		"attempts": gorm.Expr("attempts + 1"),
+	}
+
+	switch {
+	case sendErr == nil:
+		updates["status"] = model.DeliverySent
+		updates["sent_at"] = now
+		updates["last_error"] = ""
+	case isPermanentSendError(sendErr) || d.Attempts+1 >= outboxMaxAttempts:
+		updates["status"] = model.DeliveryFailed
+		updates["last_error"] = sendErr.Error()
+	default:
+		updates["status"] = model.DeliveryDeferred
+		updates["next_attempt_at"] = now.Add(retryBackoff(d.Attempts + 1))
+		updates["last_error"] = sendErr.Error()
+	}
+
+	if err := w.db.Model(&model.Delivery{}).Where("id = ?", d.Id).Updates(updates).Error(); err != nil {
+		log.Printf("Failed to record delivery %d: %v", d.Id, err)
+	}
+}
+
+// isPermanentSendError reports a 5xx SMTP reply, which will not succeed on
+// retry and is recorded as a bounce.
+func isPermanentSendError(err error) bool {
+	var protoErr *textproto.Error
+	return errors.As(err, &protoErr) && protoErr.Code >= 500
+}
+
+func retryBackoff(attempts int) time.Duration {
+	backoff := outboxBaseBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= outboxMaxBackoff {
+			return outboxMaxBackoff
+		}
+	}
+	return backoff
+}
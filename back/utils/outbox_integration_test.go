@@ -0,0 +1,119 @@
+//go:build integration
+
+package utils
+
+import (
+	"backend/internal/model"
+	"backend/internal/testdb"
+	"errors"
+	"net/textproto"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTransport records every send and fails the recipients in errs.
+type fakeTransport struct {
+	mu    sync.Mutex
+	sends [][]string
+	errs  map[string]error
+}
+
+func (t *fakeTransport) Send(mail model.Mail, recs []string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sends = append(t.sends, recs)
+	for _, rec := range recs {
+		if err := t.errs[rec]; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestOutboxWorker_DeliverDue(t *testing.T) {
+	db := testdb.Open(t)
+	now := time.Now()
+
+	mail := model.Mail{Sender: "alice@gomail.kurs", Subject: "Hello"}
+	require.NoError(t, db.Create(&mail).Error)
+
+	tests := []struct {
+		recipient    string
+		status       string
+		attempts     int
+		due          time.Time
+		err          error
+		wantStatus   string
+		wantAttempts int
+		wantBackoff  time.Duration
+	}{
+		{"ok@example.com", model.DeliveryQueued, 0, now.Add(-time.Minute), nil, model.DeliverySent, 1, 0},
+		{"bounce@example.com", model.DeliveryQueued, 0, now.Add(-time.Minute), &textproto.Error{Code: 550, Msg: "no such user"}, model.DeliveryFailed, 1, 0},
+		{"later@example.com", model.DeliveryQueued, 0, now.Add(-time.Minute), errors.New("connection refused"), model.DeliveryDeferred, 1, time.Minute},
+		{"retry@example.com", model.DeliveryDeferred, 2, now.Add(-time.Minute), errors.New("connection refused"), model.DeliveryDeferred, 3, 4 * time.Minute},
+		{"gaveup@example.com", model.DeliveryDeferred, outboxMaxAttempts - 1, now.Add(-time.Minute), errors.New("connection refused"), model.DeliveryFailed, outboxMaxAttempts, 0},
+		{"waiting@example.com", model.DeliveryDeferred, 1, now.Add(time.Hour), nil, model.DeliveryDeferred, 1, 0},
+		{"done@example.com", model.DeliverySent, 1, now.Add(-time.Hour), nil, model.DeliverySent, 1, 0},
+	}
+
+	transport := &fakeTransport{errs: map[string]error{}}
+	ids := make([]uint, len(tests))
+	for i, tt := range tests {
+		d := model.Delivery{MailId: mail.ID, Recipient: tt.recipient, Status: tt.status, Attempts: tt.attempts, NextAttemptAt: tt.due}
+		require.NoError(t, db.Create(&d).Error)
+		ids[i] = d.Id
+		transport.errs[tt.recipient] = tt.err
+	}
+
+	require.NoError(t, NewOutboxWorker(model.NewMailDB(db), transport).DeliverDue())
+
+	// Every due recipient is sent to on its own.
+	assert.ElementsMatch(t, [][]string{
+		{"ok@example.com"},
+		{"bounce@example.com"},
+		{"later@example.com"},
+		{"retry@example.com"},
+		{"gaveup@example.com"},
+	}, transport.sends)
+
+	for i, tt := range tests {
+		t.Run(tt.recipient, func(t *testing.T) {
+			var d model.Delivery
+			require.NoError(t, db.First(&d, ids[i]).Error)
+			assert.Equal(t, tt.wantStatus, d.Status)
+			assert.Equal(t, tt.wantAttempts, d.Attempts)
+			if tt.err != nil {
+				assert.Equal(t, tt.err.Error(), d.LastError)
+			}
+			if tt.wantStatus == model.DeliverySent && tt.status != model.DeliverySent {
+				assert.NotNil(t, d.SentAt)
+			}
+			if tt.wantBackoff > 0 {
+				assert.WithinDuration(t, time.Now().Add(tt.wantBackoff), d.NextAttemptAt, 5*time.Second)
+			}
+		})
+	}
+}
+
+func TestOutboxWorker_Claim(t *testing.T) {
+	db := testdb.Open(t)
+	worker := NewOutboxWorker(model.NewMailDB(db), &fakeTransport{})
+
+	require.NoError(t, db.Create(&model.Delivery{MailId: 1, Recipient: "bob@example.com", NextAttemptAt: time.Now().Add(-time.Minute)}).Error)
+	var polled model.Delivery
+	require.NoError(t, db.First(&polled).Error)
+
+	// Two workers that polled the same delivery: only the first claim wins,
+	// and it leases the delivery until the next attempt.
+	assert.True(t, worker.claim(polled))
+	assert.False(t, worker.claim(polled))
+
+	var claimed model.Delivery
+	require.NoError(t, db.First(&claimed, polled.Id).Error)
+	assert.WithinDuration(t, time.Now().Add(outboxLease), claimed.NextAttemptAt, 5*time.Second)
+	assert.True(t, worker.claim(claimed))
+}
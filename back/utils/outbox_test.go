@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net/textproto"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, time.Minute},
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{7, 64 * time.Minute},
+		{9, 256 * time.Minute},
+		{10, outboxMaxBackoff},
+		{100, outboxMaxBackoff},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.attempts), func(t *testing.T) {
+			assert.Equal(t, tt.want, retryBackoff(tt.attempts))
+		})
+	}
+}
+
+func TestIsPermanentSendError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"mailbox unavailable", &textproto.Error{Code: 550, Msg: "no such user"}, true},
+		{"wrapped rejection", fmt.Errorf("rcpt: %w", &textproto.Error{Code: 554, Msg: "rejected"}), true},
+		{"greylisted", &textproto.Error{Code: 451, Msg: "try again later"}, false},
+		{"connection refused", errors.New("dial tcp: connection refused"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isPermanentSendError(tt.err))
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package utils
+
+import (
+	"backend/internal/config"
+	"backend/internal/model"
+	"context"
+	"log"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+const purgeBatch = 100
+
+// PurgeWorker removes mails for good once every participant has deleted
+// them and the retention period has passed since the last one did. Mails
+// still waiting for external delivery are kept, and so are mails without
+// any mailbox, which nobody has deleted.
+type PurgeWorker struct {
+	db    model.MailDB
+	store *AttachmentStore
+	conf  config.PurgeConfig
+}
+
+func NewPurgeWorker(db model.MailDB, store *AttachmentStore, conf config.PurgeConfig) *PurgeWorker {
+	return &PurgeWorker{
+		db:    db,
+		store: store,
+		conf:  conf,
+	}
+}
+
+func (w *PurgeWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.conf.Interval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := w.PurgeDue(time.Now()); err != nil {
+			log.Println("Error purging deleted mail:", err)
+		} else if n > 0 {
+			log.Printf("Purged %d deleted mails", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PurgeDue removes every mail due at now, in batches, and returns how many
+// were removed.
+func (w *PurgeWorker) PurgeDue(now time.Time) (int, error) {
+	cutoff := now.Add(-w.conf.Retention)
+	total := 0
+	for {
+		var mails []model.Mail
+		if err := duePurge(w.db.Select("id"), cutoff).
+			Order("id").
+			Limit(purgeBatch).
+			Find(&mails).Error(); err != nil {
+			return total, err
+		}
+		if len(mails) == 0 {
+			return total, nil
+		}
+
+		ids := make([]uint, 0, len(mails))
+		for _, mail := range mails {
+			ids = append(ids, mail.ID)
+		}
+		n, keys, err := w.purge(ids, cutoff)
+		if err != nil {
+			return total, err
+		}
+		total += n
+		removeUnused(w.db, w.store, keys, cutoff)
+
+		if len(mails) < purgeBatch {
+			return total, nil
+		}
+	}
+}
+
+// duePurge narrows a mails query to the mails due for purging at cutoff.
+func duePurge(db model.MailDB, cutoff time.Time) model.MailDB {
+	return db.Where("created_at < ?", cutoff).
+		Where("EXISTS (SELECT 1 FROM mailboxes WHERE mailboxes.mail_id = mails.id)").
+		Where("NOT EXISTS (SELECT 1 FROM mailboxes WHERE mailboxes.mail_id = mails.id"+
+			" AND (mailboxes.folder NOT IN ? OR COALESCE(mailboxes.trashed_at, mailboxes.updated_at) >= ?))",
+			[]string{model.FolderTrash, model.FolderDeleted}, cutoff).
+		Where("NOT EXISTS (SELECT 1 FROM deliveries WHERE deliveries.mail_id = mails.id AND deliveries.status IN ?)",
+			[]string{model.DeliveryQueued, model.DeliveryDeferred})
+}
+
+// purge deletes those of the mails with ids still due at cutoff, and
+// everything attached to them. It returns how many it deleted and the
+// storage keys of their attachments. The mailboxes are locked before the
+// mails are checked again, so a mail restored in the meantime is kept and
+// one being restored waits for the purge to finish.
+func (w *PurgeWorker) purge(ids []uint, cutoff time.Time) (int, []string, error) {
+	var keys []string
+	var due []uint
+	err := w.db.Transaction(func(tx model.MailDB) error {
+		var boxes []model.Mailbox
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id").
+			Where("mail_id IN ?", ids).
+			Find(&boxes).Error(); err != nil {
+			return err
+		}
+		var mails []model.Mail
+		if err := duePurge(tx.Select("id").Where("id IN ?", ids), cutoff).Find(&mails).Error(); err != nil {
+			return err
+		}
+		if len(mails) == 0 {
+			return nil
+		}
+		for _, mail := range mails {
+			due = append(due, mail.ID)
+		}
+
+		var atts []model.Attachment
+		if err := tx.Select("DISTINCT storage_key").Where("mail_id IN ?", due).Find(&atts).Error(); err != nil {
+			return err
+		}
+		for _, att := range atts {
+			keys = append(keys, att.StorageKey)
+		}
+		return DeleteMails(tx, due)
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	return len(due), keys, nil
+}
+
+// DeleteMails deletes the mails with ids along with their mailboxes,
+// labels, recipients, attachments and deliveries. The stored attachment
+// files are left alone.
+func DeleteMails(tx model.MailDB, ids []uint) error {
+	if err := tx.Where("mailbox_id IN (SELECT id FROM mailboxes WHERE mail_id IN ?)", ids).
+		Delete(&model.MailboxLabel{}).Error(); err != nil {
+		return err
+	}
+	for _, table := range []interface{}{
+		&model.Mailbox{},
+		&model.Recipient{},
+		&model.Attachment{},
+		&model.Delivery{},
+	} {
+		if err := tx.Where("mail_id IN ?", ids).Delete(table).Error(); err != nil {
+			return err
+		}
+	}
+	return tx.Where("id IN ?", ids).Delete(&model.Mail{}).Error()
+}
+
+// removeUnused deletes the stored files of keys that no mail or draft
+// refers to any more. Files stored again after before are kept, as a
+// request uploading the same content may not have saved its mail yet.
+func removeUnused(db model.MailDB, store *AttachmentStore, keys []string, before time.Time) {
+	for _, key := range keys {
+		var used bool
+		if err := db.Model(&model.Attachment{}).
+			Select("count(*) > 0").
+			Where("storage_key = ?", key).
+			Find(&used).Error(); err != nil || used {
+			continue
+		}
+		if err := db.Model(&model.DraftAttachment{}).
+			Select("count(*) > 0").
+			Where("storage_key = ?", key).
+			Find(&used).Error(); err != nil || used {
+			continue
+		}
+
+		if err := store.Remove(key, before); err != nil {
+			log.Printf("Failed to remove stored attachment %s: %v", key, err)
+		}
+	}
+}
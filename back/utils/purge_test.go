@@ -0,0 +1,170 @@
+//go:build integration
+
+package utils
+
+import (
+	"backend/internal/config"
+	"backend/internal/model"
+	"backend/internal/testdb"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPurgeWorker_PurgeDue(t *testing.T) {
+	now := time.Now()
+	conf := config.PurgeConfig{Retention: 30 * 24 * time.Hour, Interval: time.Hour}
+	old := now.Add(-2 * conf.Retention)
+	recent := now.Add(-time.Hour)
+
+	deleted := func(userID uint, at time.Time) model.Mailbox {
+		return model.Mailbox{UserId: userID, Role: model.MailboxRecipient, Folder: model.FolderDeleted, TrashedAt: &at}
+	}
+
+	tests := []struct {
+		name       string
+		createdAt  time.Time
+		boxes      []model.Mailbox
+		deliveries []string
+		purged     bool
+	}{
+		{"deleted by everyone", old, []model.Mailbox{deleted(1, old), deleted(2, old)}, nil, true},
+		{"trashed by everyone", old, []model.Mailbox{{UserId: 1, Role: model.MailboxSender, Folder: model.FolderTrash, TrashedAt: &old}}, nil, true},
+		{"without mailboxes", old, nil, nil, false},
+		{"kept by one user", old, []model.Mailbox{deleted(1, old), {UserId: 2, Role: model.MailboxRecipient, Folder: model.FolderInbox}}, nil, false},
+		{"deleted recently", old, []model.Mailbox{deleted(1, old), deleted(2, recent)}, nil, false},
+		{"created recently", recent, []model.Mailbox{deleted(1, old)}, nil, false},
+		{"delivery pending", old, []model.Mailbox{deleted(1, old)}, []string{model.DeliverySent, model.DeliveryDeferred}, false},
+		{"delivery done", old, []model.Mailbox{deleted(1, old)}, []string{model.DeliverySent, model.DeliveryFailed}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := testdb.Open(t)
+			store, err := NewAttachmentStore(t.TempDir())
+			require.NoError(t, err)
+
+			mail := model.Mail{Model: gorm.Model{CreatedAt: tt.createdAt}, Sender: "alice@gomail.kurs"}
+			require.NoError(t, db.Create(&mail).Error)
+			for _, box := range tt.boxes {
+				box.MailId = mail.ID
+				box.UpdatedAt = old
+				require.NoError(t, db.Create(&box).Error)
+			}
+			for _, status := range tt.deliveries {
+				require.NoError(t, db.Create(&model.Delivery{MailId: mail.ID, Recipient: "bob@example.com", Status: status, NextAttemptAt: old}).Error)
+			}
+
+			n, err := NewPurgeWorker(model.NewMailDB(db), store, conf).PurgeDue(now)
+			require.NoError(t, err)
+
+			var mails, boxes, deliveries int64
+			require.NoError(t, db.Model(&model.Mail{}).Count(&mails).Error)
+			require.NoError(t, db.Model(&model.Mailbox{}).Count(&boxes).Error)
+			require.NoError(t, db.Model(&model.Delivery{}).Count(&deliveries).Error)
+			if tt.purged {
+				assert.Equal(t, 1, n)
+				assert.Zero(t, mails+boxes+deliveries)
+			} else {
+				assert.Zero(t, n)
+				assert.Equal(t, int64(1), mails)
+				assert.Equal(t, int64(len(tt.boxes)), boxes)
+				assert.Equal(t, int64(len(tt.deliveries)), deliveries)
+			}
+		})
+	}
+}
+
+func TestPurgeWorker_RemovesUnusedFiles(t *testing.T) {
+	now := time.Now()
+	conf := config.PurgeConfig{Retention: 30 * 24 * time.Hour, Interval: time.Hour}
+	old := now.Add(-2 * conf.Retention)
+
+	tests := []struct {
+		name      string
+		modified  time.Time
+		otherMail bool
+		draft     bool
+		removed   bool
+	}{
+		{"unused", old, false, false, true},
+		{"stored again since", now, false, false, false},
+		{"used by another mail", old, true, false, false},
+		{"used by a draft", old, false, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := testdb.Open(t)
+			store, err := NewAttachmentStore(t.TempDir())
+			require.NoError(t, err)
+
+			att, err := store.Put("report.txt", "text/plain", strings.NewReader("quarterly numbers"))
+			require.NoError(t, err)
+			path := store.path(att.StorageKey)
+			require.NoError(t, os.Chtimes(path, tt.modified, tt.modified))
+
+			mail := model.Mail{Model: gorm.Model{CreatedAt: old}, Sender: "alice@gomail.kurs", Attachments: []model.Attachment{att}}
+			require.NoError(t, db.Create(&mail).Error)
+			require.NoError(t, db.Create(&model.Mailbox{MailId: mail.ID, UserId: 1, Role: model.MailboxSender, Folder: model.FolderDeleted, TrashedAt: &old}).Error)
+			if tt.otherMail {
+				other := model.Mail{Sender: "alice@gomail.kurs", Attachments: []model.Attachment{att}}
+				require.NoError(t, db.Create(&other).Error)
+				require.NoError(t, db.Create(&model.Mailbox{MailId: other.ID, UserId: 1, Role: model.MailboxSender, Folder: model.FolderSent}).Error)
+			}
+			if tt.draft {
+				require.NoError(t, db.Create(&model.Draft{UserId: 1, Attachments: []model.DraftAttachment{{
+					Filename: att.Filename, ContentType: att.ContentType, Size: att.Size, Checksum: att.Checksum, StorageKey: att.StorageKey,
+				}}}).Error)
+			}
+
+			n, err := NewPurgeWorker(model.NewMailDB(db), store, conf).PurgeDue(now)
+			require.NoError(t, err)
+			assert.Equal(t, 1, n)
+
+			_, err = os.Stat(path)
+			assert.Equal(t, tt.removed, os.IsNotExist(err))
+		})
+	}
+}
+
+func TestPurgeWorker_RestoredMeanwhile(t *testing.T) {
+	now := time.Now()
+	conf := config.PurgeConfig{Retention: 30 * 24 * time.Hour, Interval: time.Hour}
+	old := now.Add(-2 * conf.Retention)
+	cutoff := now.Add(-conf.Retention)
+
+	db := testdb.Open(t)
+	store, err := NewAttachmentStore(t.TempDir())
+	require.NoError(t, err)
+
+	var ids []uint
+	var boxes []model.Mailbox
+	for range 2 {
+		mail := model.Mail{Model: gorm.Model{CreatedAt: old}, Sender: "alice@gomail.kurs"}
+		require.NoError(t, db.Create(&mail).Error)
+		box := model.Mailbox{MailId: mail.ID, UserId: 1, Role: model.MailboxSender, Folder: model.FolderTrash, TrashedAt: &old}
+		require.NoError(t, db.Create(&box).Error)
+		ids = append(ids, mail.ID)
+		boxes = append(boxes, box)
+	}
+
+	// The first mail is restored after PurgeDue picked it.
+	require.NoError(t, db.Model(&boxes[0]).Updates(map[string]interface{}{"folder": model.FolderSent, "trashed_at": nil}).Error)
+
+	n, _, err := NewPurgeWorker(model.NewMailDB(db), store, conf).purge(ids, cutoff)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	var kept []uint
+	require.NoError(t, db.Model(&model.Mail{}).Order("id").Pluck("id", &kept).Error)
+	assert.Equal(t, ids[:1], kept)
+	var box model.Mailbox
+	require.NoError(t, db.Where("mail_id = ?", ids[0]).First(&box).Error)
+	assert.Equal(t, model.FolderSent, box.Folder)
+}
@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"backend/internal/model"
+	"errors"
+)
+
+// ErrQuotaExceeded is returned when storing a mail would take a user past
+// their quota.
+var ErrQuotaExceeded = errors.New("Mailbox quota exceeded")
+
+// mailSize is the storage taken by a mail: its bodies and attachments.
+const mailSize = "octet_length(mails.body) + octet_length(mails.html_body)" +
+	" + COALESCE((SELECT SUM(size) FROM attachments WHERE attachments.mail_id = mails.id), 0)"
+
+// MailSize returns the storage mail takes, counted the same way as the
+// mails a user already keeps.
+func MailSize(mail *model.Mail) int64 {
+	size := int64(len(mail.Body) + len(mail.HTMLBody))
+	for _, att := range mail.Attachments {
+		size += att.Size
+	}
+	return size
+}
+
+// CheckQuota returns ErrQuotaExceeded if storing size more bytes would
+// take user past their quota.
+func CheckQuota(db model.MailDB, user model.User, size int64) error {
+	full, err := overQuota(db, []model.User{user}, size)
+	if err != nil {
+		return err
+	}
+	if full[user.Id] {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// overQuota reports which of users have no room left for size more bytes.
+// Every mail a user keeps counts, except the deleted ones waiting to be
+// purged. Users without a quota are never over it.
+func overQuota(db model.MailDB, users []model.User, size int64) (map[uint]bool, error) {
+	quotas := make(map[uint]int64)
+	var ids []uint
+	for _, user := range users {
+		if user.Quota > 0 {
+			quotas[user.Id] = user.Quota
+			ids = append(ids, user.Id)
+		}
+	}
+	full := make(map[uint]bool)
+	if len(ids) == 0 {
+		return full, nil
+	}
+
+	var usage []struct {
+		UserId uint
+		Used   int64
+	}
+	if err := db.Model(&model.Mailbox{}).
+		Select("mailboxes.user_id, SUM("+mailSize+") AS used").
+		Joins("JOIN mails ON mails.id = mailboxes.mail_id").
+		Where("mailboxes.user_id IN ? AND mailboxes.folder <> ?", ids, model.FolderDeleted).
+		Group("mailboxes.user_id").
+		Find(&usage).Error(); err != nil {
+		return nil, err
+	}
+
+	used := make(map[uint]int64, len(usage))
+	for _, u := range usage {
+		used[u.UserId] = u.Used
+	}
+	for id, quota := range quotas {
+		full[id] = used[id]+size > quota
+	}
+	return full, nil
+}
@@ -0,0 +1,92 @@
+//go:build integration
+
+package utils
+
+import (
+	"backend/internal/model"
+	"backend/internal/testdb"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// keptMail stores a mail of body bytes with an attachment of attachment
+// bytes, if any, which userID keeps in folder.
+func keptMail(t *testing.T, db *gorm.DB, userID uint, folder string, body int, attachment int64) {
+	t.Helper()
+	mail := model.Mail{Sender: "someone@example.com", Body: strings.Repeat("x", body)}
+	if attachment > 0 {
+		mail.Attachments = []model.Attachment{{Filename: "a.bin", Size: attachment, StorageKey: "key"}}
+	}
+	require.NoError(t, db.Create(&mail).Error)
+	require.NoError(t, db.Create(&model.Mailbox{MailId: mail.ID, UserId: userID, Role: model.MailboxRecipient, Folder: folder}).Error)
+}
+
+func TestCheckQuota(t *testing.T) {
+	tests := []struct {
+		name       string
+		quota      int64
+		folder     string
+		body       int
+		attachment int64
+		size       int64
+		exceeded   bool
+	}{
+		{"unlimited", 0, model.FolderInbox, 100, 0, 1000, false},
+		{"fits exactly", 100, model.FolderInbox, 40, 0, 60, false},
+		{"one byte too many", 100, model.FolderInbox, 40, 0, 61, true},
+		{"attachments count", 100, model.FolderArchive, 40, 50, 20, true},
+		{"deleted mails do not count", 100, model.FolderDeleted, 90, 0, 60, false},
+		{"trashed mails count", 100, model.FolderTrash, 90, 0, 60, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := testdb.Open(t)
+			user := model.User{Email: "bob@gomail.kurs", Password: "x", Quota: tt.quota}
+			require.NoError(t, db.Create(&user).Error)
+			keptMail(t, db, user.Id, tt.folder, tt.body, tt.attachment)
+
+			err := CheckQuota(model.NewMailDB(db), user, tt.size)
+			if tt.exceeded {
+				assert.ErrorIs(t, err, ErrQuotaExceeded)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestStoreMail_RecipientOverQuota(t *testing.T) {
+	db := testdb.Open(t)
+	alice := model.User{Email: "alice@gomail.kurs", Password: "x"}
+	bob := model.User{Email: "bob@gomail.kurs", Password: "x", Quota: 50}
+	carol := model.User{Email: "carol@gomail.kurs", Password: "x", Quota: 50}
+	for _, user := range []*model.User{&alice, &bob, &carol} {
+		require.NoError(t, db.Create(user).Error)
+	}
+	keptMail(t, db, bob.Id, model.FolderInbox, 40, 0)
+
+	mail := model.Mail{Sender: alice.Email, Body: strings.Repeat("x", 20)}
+	require.NoError(t, StoreMail(model.NewMailDB(db), &mail, alice.Id, []string{bob.Email, carol.Email}, []string{"dave@example.com"}))
+
+	var boxes []model.Mailbox
+	require.NoError(t, db.Where("mail_id = ?", mail.ID).Order("user_id").Find(&boxes).Error)
+	if assert.Len(t, boxes, 2) {
+		assert.Equal(t, alice.Id, boxes[0].UserId)
+		assert.Equal(t, carol.Id, boxes[1].UserId)
+	}
+
+	var deliveries []model.Delivery
+	require.NoError(t, db.Where("mail_id = ?", mail.ID).Order("recipient").Find(&deliveries).Error)
+	if assert.Len(t, deliveries, 2) {
+		assert.Equal(t, bob.Email, deliveries[0].Recipient)
+		assert.Equal(t, model.DeliveryFailed, deliveries[0].Status)
+		assert.Equal(t, ErrQuotaExceeded.Error(), deliveries[0].LastError)
+		assert.Equal(t, "dave@example.com", deliveries[1].Recipient)
+		assert.Equal(t, model.DeliveryQueued, deliveries[1].Status)
+	}
+}
@@ -0,0 +1,260 @@
+package utils
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	// allowedElements maps each permitted element to the attributes it may keep.
+	allowedElements = map[atom.Atom][]string{
+		atom.A:          {"href", "title"},
+		atom.Abbr:       {"title"},
+		atom.B:          nil,
+		atom.Blockquote: {"cite"},
+		atom.Br:         nil,
+		atom.Caption:    nil,
+		atom.Code:       nil,
+		atom.Del:        nil,
+		atom.Div:        {"align"},
+		atom.Em:         nil,
+		atom.Font:       {"color", "face", "size"},
+		atom.H1:         nil,
+		atom.H2:         nil,
+		atom.H3:         nil,
+		atom.H4:         nil,
+		atom.H5:         nil,
+		atom.H6:         nil,
+		atom.Hr:         nil,
+		atom.I:          nil,
+		atom.Img:        {"src", "alt", "title", "width", "height"},
+		atom.Li:         nil,
+		atom.Ol:         {"start", "type"},
+		atom.P:          {"align"},
+		atom.Pre:        nil,
+		atom.S:          nil,
+		atom.Small:      nil,
+		atom.Span:       nil,
+		atom.Strong:     nil,
+		atom.Sub:        nil,
+		atom.Sup:        nil,
+		atom.Table:      {"border", "cellpadding", "cellspacing", "width"},
+		atom.Tbody:      nil,
+		atom.Td:         {"align", "colspan", "rowspan", "valign", "width"},
+		atom.Tfoot:      nil,
+		atom.Th:         {"align", "colspan", "rowspan", "valign", "width"},
+		atom.Thead:      nil,
+		atom.Tr:         nil,
+		atom.U:          nil,
+		atom.Ul:         nil,
+	}
+
+	// droppedElements are removed together with everything inside them.
+	droppedElements = map[atom.Atom]bool{
+		atom.Script:   true,
+		atom.Style:    true,
+		atom.Iframe:   true,
+		atom.Object:   true,
+		atom.Embed:    true,
+		atom.Frame:    true,
+		atom.Frameset: true,
+		atom.Applet:   true,
+		atom.Noscript: true,
+		atom.Template: true,
+		atom.Form:     true,
+		atom.Title:    true,
+		atom.Head:     true,
+	}
+
+	// blockElements end a line when converting HTML to plain text.
+	blockElements = map[atom.Atom]bool{
+		atom.Blockquote: true,
+		atom.Br:         true,
+		atom.Div:        true,
+		atom.H1:         true,
+		atom.H2:         true,
+		atom.H3:         true,
+		atom.H4:         true,
+		atom.H5:         true,
+		atom.H6:         true,
+		atom.Hr:         true,
+		atom.Li:         true,
+		atom.P:          true,
+		atom.Pre:        true,
+		atom.Table:      true,
+		atom.Tr:         true,
+	}
+)
+
+// SanitizeHTML keeps only allow-listed elements and attributes of htmlStr.
+// Scripts, styles, event handlers and unsafe URLs are dropped. Remote images
+// are not loaded: their address is moved to data-remote-src so a client can
+// choose to show them.
+func SanitizeHTML(htmlStr string) string {
+	root := &html.Node{
+		Type:     html.ElementNode,
+		Data:     "div",
+		DataAtom: atom.Div,
+	}
+
+	nodes, err := html.ParseFragment(strings.NewReader(htmlStr), root)
+	if err != nil {
+		return ""
+	}
+	for _, n := range nodes {
+		root.AppendChild(n)
+	}
+	sanitizeNode(root)
+
+	var buf bytes.Buffer
+	for c := root.FirstChild; c != nil; c = c.NextSibling {
+		html.Render(&buf, c)
+	}
+	return buf.String()
+}
+
+func sanitizeNode(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+
+		switch c.Type {
+		case html.TextNode:
+		case html.ElementNode:
+			sanitizeNode(c)
+			if droppedElements[c.DataAtom] {
+				n.RemoveChild(c)
+			} else if attrs, ok := allowedElements[c.DataAtom]; ok {
+				c.Attr = sanitizeAttrs(c, attrs)
+			} else {
+				unwrapNode(n, c)
+			}
+		default:
+			n.RemoveChild(c)
+		}
+
+		c = next
+	}
+}
+
+// unwrapNode replaces c with its children, keeping the content of elements
+// that are not allowed themselves.
+func unwrapNode(parent, c *html.Node) {
+	for child := c.FirstChild; child != nil; {
+		next := child.NextSibling
+		c.RemoveChild(child)
+		parent.InsertBefore(child, c)
+		child = next
+	}
+	parent.RemoveChild(c)
+}
+
+func sanitizeAttrs(n *html.Node, allowed []string) []html.Attribute {
+	var attrs []html.Attribute
+	for _, attr := range n.Attr {
+		if attr.Namespace != "" || !containsString(allowed, attr.Key) {
+			continue
+		}
+
+		switch attr.Key {
+		case "href":
+			if !safeURL(attr.Val, "http", "https", "mailto") {
+				continue
+			}
+		case "src", "cite":
+			if n.DataAtom == atom.Img && safeURL(attr.Val, "http", "https") {
+				attrs = append(attrs, html.Attribute{Key: "data-remote-src", Val: attr.Val})
+				continue
+			}
+			if !safeURL(attr.Val, "cid") {
+				continue
+			}
+		}
+
+		attrs = append(attrs, attr)
+	}
+
+	if n.DataAtom == atom.A {
+		attrs = append(attrs,
+			html.Attribute{Key: "rel", Val: "noopener noreferrer nofollow"},
+			html.Attribute{Key: "target", Val: "_blank"},
+		)
+	}
+	return attrs
+}
+
+func safeURL(raw string, schemes ...string) bool {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return false
+	}
+	return containsString(schemes, strings.ToLower(u.Scheme))
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// HTMLToText renders the readable text of htmlStr, keeping paragraph and
+// line breaks and the targets of links.
+func HTMLToText(htmlStr string) string {
+	doc, err := html.Parse(strings.NewReader(htmlStr))
+	if err != nil {
+		return htmlStr
+	}
+
+	var buf strings.Builder
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.ElementNode && droppedElements[n.DataAtom] {
+			return
+		}
+		if n.Type == html.TextNode {
+			buf.WriteString(n.Data)
+		} else if n.Type == html.ElementNode && blockElements[n.DataAtom] {
+			buf.WriteString("\n")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+		if n.Type != html.ElementNode {
+			return
+		}
+
+		if n.DataAtom == atom.A {
+			for _, attr := range n.Attr {
+				if attr.Key == "href" && safeURL(attr.Val, "http", "https", "mailto") {
+					buf.WriteString(" <" + attr.Val + ">")
+				}
+			}
+		} else if blockElements[n.DataAtom] {
+			buf.WriteString("\n")
+		}
+	}
+	f(doc)
+
+	lines := strings.Split(buf.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.Fields(line), " ")
+	}
+	return strings.TrimSpace(collapseBlankLines(lines))
+}
+
+func collapseBlankLines(lines []string) string {
+	var out []string
+	for i, line := range lines {
+		if line == "" && i > 0 && lines[i-1] == "" {
+			continue
+		}
+		out = append(out, line)
+	}
+	return strings.Join(out, "\n")
+}
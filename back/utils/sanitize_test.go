@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanitizeHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"allowed markup", `<p align="center"><b>Hi</b> <em>there</em></p>`, `<p align="center"><b>Hi</b> <em>there</em></p>`},
+		{"script", `<p>Hi</p><script>alert(1)</script>`, `<p>Hi</p>`},
+		{"style and its rules", `<style>p { color: red }</style><p>Hi</p>`, `<p>Hi</p>`},
+		{"iframe with content", `<iframe src="https://evil.example"><p>inside</p></iframe>after`, `after`},
+		{"event handlers", `<p onclick="steal()" onmouseover="steal()">Hi</p><img src="cid:logo" onerror="steal()">`, `<p>Hi</p><img src="cid:logo"/>`},
+		{"unknown attributes", `<td bgcolor="red" colspan="2" style="x">1</td>`, `1`},
+		{"link", `<a href="https://example.com" title="Site">site</a>`, `<a href="https://example.com" title="Site" rel="noopener noreferrer nofollow" target="_blank">site</a>`},
+		{"mailto link", `<a href="mailto:bob@example.com">Bob</a>`, `<a href="mailto:bob@example.com" rel="noopener noreferrer nofollow" target="_blank">Bob</a>`},
+		{"javascript link", `<a href="javascript:alert(1)">click</a>`, `<a rel="noopener noreferrer nofollow" target="_blank">click</a>`},
+		{"javascript link with spaces and case", `<a href="  JavaScript:alert(1)">click</a>`, `<a rel="noopener noreferrer nofollow" target="_blank">click</a>`},
+		{"data link", `<a href="data:text/html;base64,PHNjcmlwdD4=">click</a>`, `<a rel="noopener noreferrer nofollow" target="_blank">click</a>`},
+		{"data image", `<img src="data:image/png;base64,iVBORw0KGgo=" alt="dot">`, `<img alt="dot"/>`},
+		{"remote image", `<img src="https://tracker.example/pixel.gif" width="1">`, `<img data-remote-src="https://tracker.example/pixel.gif" width="1"/>`},
+		{"plain http image", `<img src="http://example.com/a.png">`, `<img data-remote-src="http://example.com/a.png"/>`},
+		{"inline image", `<img src="cid:part1@example.com" alt="logo">`, `<img src="cid:part1@example.com" alt="logo"/>`},
+		{"javascript quote source", `<blockquote cite="javascript:alert(1)">quoted</blockquote>`, `<blockquote>quoted</blockquote>`},
+		{"unknown tag unwrapped", `<article><section>Hello <b>world</b></section></article>`, `Hello <b>world</b>`},
+		{"unknown tag around dropped one", `<center>Hi<script>alert(1)</script></center>`, `Hi`},
+		{"comment", `<p>Hi<!-- secret --></p>`, `<p>Hi</p>`},
+		{"escaped text", `<p>1 &lt; 2 &amp; 3</p>`, `<p>1 &lt; 2 &amp; 3</p>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, SanitizeHTML(tt.in))
+		})
+	}
+}
+
+func TestHTMLToText(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain text", `Hello`, "Hello"},
+		{"paragraphs", `<p>One</p><p>Two</p>`, "One\n\nTwo"},
+		{"line breaks", `One<br>Two<br/>Three`, "One\n\nTwo\n\nThree"},
+		{"whitespace collapsed", "<p>  many \t  spaces  here </p>", "many spaces here"},
+		{"text line breaks kept", "<pre>one\n  two</pre>", "one\ntwo"},
+		{"inline markup", `<p><b>Bold</b> and <i>italic</i></p>`, "Bold and italic"},
+		{"link target", `<a href="https://example.com">site</a>`, "site <https://example.com>"},
+		{"unsafe link target", `<a href="javascript:alert(1)">click</a>`, "click"},
+		{"script and style", `<style>p {}</style><p>Hi</p><script>alert(1)</script>`, "Hi"},
+		{"title", `<html><head><title>Subject</title></head><body>Hi</body></html>`, "Hi"},
+		{"list", `<ul><li>one</li><li>two</li></ul>`, "one\n\ntwo"},
+		{"entities", `<p>1 &lt; 2 &amp;&nbsp;3</p>`, "1 < 2 & 3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, HTMLToText(tt.in))
+		})
+	}
+}
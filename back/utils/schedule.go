@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"backend/internal/config"
+	"backend/internal/model"
+	"context"
+	"log"
+	"time"
+)
+
+const scheduleBatch = 100
+
+// ScheduleWorker sends scheduled mails once their send time has come,
+// through the same path as mail sent right away: local recipients get
+// their copies and external ones are queued for the outbox worker.
+type ScheduleWorker struct {
+	db   model.MailDB
+	conf config.ScheduleConfig
+}
+
+func NewScheduleWorker(db model.MailDB, conf config.ScheduleConfig) *ScheduleWorker {
+	return &ScheduleWorker{
+		db:   db,
+		conf: conf,
+	}
+}
+
+func (w *ScheduleWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.conf.Interval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := w.SendDue(time.Now()); err != nil {
+			log.Println("Error sending scheduled mail:", err)
+		} else if n > 0 {
+			log.Printf("Sent %d scheduled mails", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SendDue sends every scheduled mail due at now, in batches, and returns
+// how many were sent. A mail failing to send is logged and retried on the
+// next run without holding up the others.
+func (w *ScheduleWorker) SendDue(now time.Time) (int, error) {
+	total := 0
+	var after uint
+	for {
+		var mails []model.Mail
+		if err := w.db.Select("id").
+			Where("send_at <= ? AND id > ?", now, after).
+			Order("id").
+			Limit(scheduleBatch).
+			Find(&mails).Error(); err != nil {
+			return total, err
+		}
+
+		for _, mail := range mails {
+			after = mail.ID
+			sent, err := ReleaseMail(w.db, mail.ID, now)
+			if err != nil {
+				log.Printf("Failed to send scheduled mail %d: %v", mail.ID, err)
+				continue
+			}
+			if sent {
+				total++
+			}
+		}
+
+		if len(mails) < scheduleBatch {
+			return total, nil
+		}
+	}
+}
@@ -0,0 +1,108 @@
+//go:build integration
+
+package utils
+
+import (
+	"backend/internal/model"
+	"backend/internal/testdb"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// scheduledMail stores a mail alice scheduled for sendAt to bob, who has
+// an account, and to an external address.
+func scheduledMail(t *testing.T, db *gorm.DB, sendAt time.Time) (mail model.Mail, alice, bob model.User) {
+	t.Helper()
+	require.NoError(t, db.Create(&model.Domain{Name: "gomail.kurs"}).Error)
+	alice = model.User{Email: "alice@gomail.kurs", Password: "x"}
+	bob = model.User{Email: "bob@gomail.kurs", Password: "x"}
+	require.NoError(t, db.Create(&alice).Error)
+	require.NoError(t, db.Create(&bob).Error)
+
+	mail = model.Mail{
+		Sender:     alice.Email,
+		Subject:    "Later",
+		SendAt:     &sendAt,
+		Recipients: append(NewRecipients(model.RecipientTo, []string{bob.Email}), NewRecipients(model.RecipientBcc, []string{"carol@example.com"})...),
+	}
+	require.NoError(t, ScheduleMail(model.NewMailDB(db), &mail, alice.Id, model.FolderScheduled))
+	return mail, alice, bob
+}
+
+func TestReleaseMail(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		change   func(db *gorm.DB, mail model.Mail) error
+		released bool
+	}{
+		{"due", nil, true},
+		{"rescheduled", func(db *gorm.DB, mail model.Mail) error {
+			return db.Model(&mail).Update("send_at", now.Add(time.Hour)).Error
+		}, false},
+		{"cancelled", func(db *gorm.DB, mail model.Mail) error {
+			if err := db.Model(&mail).Update("send_at", nil).Error; err != nil {
+				return err
+			}
+			return DeleteMails(model.NewMailDB(db), []uint{mail.ID})
+		}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := testdb.Open(t)
+			mail, alice, bob := scheduledMail(t, db, now.Add(-time.Second))
+			if tt.change != nil {
+				require.NoError(t, tt.change(db, mail))
+			}
+
+			released, err := ReleaseMail(model.NewMailDB(db), mail.ID, now)
+			require.NoError(t, err)
+			assert.Equal(t, tt.released, released)
+
+			var boxes []model.Mailbox
+			require.NoError(t, db.Where("mail_id = ?", mail.ID).Order("id").Find(&boxes).Error)
+			var deliveries []model.Delivery
+			require.NoError(t, db.Where("mail_id = ?", mail.ID).Find(&deliveries).Error)
+
+			if !tt.released {
+				// Nothing is delivered: the sender keeps the only copy, if
+				// any, and nothing is queued.
+				for _, box := range boxes {
+					assert.Equal(t, alice.Id, box.UserId)
+					assert.Equal(t, model.FolderScheduled, box.Folder)
+				}
+				assert.Empty(t, deliveries)
+				return
+			}
+
+			var sent model.Mail
+			require.NoError(t, db.First(&sent, mail.ID).Error)
+			assert.Nil(t, sent.SendAt)
+			assert.WithinDuration(t, now, sent.CreatedAt, time.Millisecond)
+			if assert.Len(t, boxes, 2) {
+				assert.Equal(t, alice.Id, boxes[0].UserId)
+				assert.Equal(t, model.FolderSent, boxes[0].Folder)
+				assert.Equal(t, bob.Id, boxes[1].UserId)
+				assert.Equal(t, model.FolderInbox, boxes[1].Folder)
+			}
+			if assert.Len(t, deliveries, 1) {
+				assert.Equal(t, "carol@example.com", deliveries[0].Recipient)
+				assert.Equal(t, model.DeliveryQueued, deliveries[0].Status)
+			}
+
+			// A second release finds nothing left to send.
+			released, err = ReleaseMail(model.NewMailDB(db), mail.ID, now)
+			require.NoError(t, err)
+			assert.False(t, released)
+			var count int64
+			require.NoError(t, db.Model(&model.Mailbox{}).Where("mail_id = ?", mail.ID).Count(&count).Error)
+			assert.Equal(t, int64(2), count)
+		})
+	}
+}
@@ -1,41 +1,64 @@
 package utils
 
 import (
+	"backend/internal/config"
 	"backend/internal/model"
 	"crypto/tls"
-	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/smtp"
-
-	"github.com/jordan-wright/email"
+	"time"
 )
 
-func SendMailSMTP(mail model.Mail, recs []string) error {
-	var (
-		smtpHost = GetEnv("SMTP_HOST", "")
-		smtpUser = GetEnv("SMTP_USER", "")
-		smtpPass = GetEnv("MAIL_PASS", "")
-	)
+const smtpDialTimeout = 30 * time.Second
+
+type smtpTransport struct {
+	conf  config.TransportConfig
+	store *AttachmentStore
+}
+
+func NewSMTPTransport(conf config.TransportConfig, store *AttachmentStore) (Transport, error) {
+	if conf.Host == "" {
+		return nil, fmt.Errorf("smtp transport: host is required")
+	}
+
+	if conf.Port == "" {
+		switch conf.Mode {
+		case config.TransportSMTPS:
+			conf.Port = "465"
+		case config.TransportStartTLS:
+			conf.Port = "587"
+		case config.TransportSMTP:
+			conf.Port = "25"
+		default:
+			return nil, fmt.Errorf("smtp transport: unknown mode %q", conf.Mode)
+		}
+	}
 
-	if len(recs) <= 0 {
-		err := errors.New("receivers are empty")
-		log.Println("Receivers are empty:", err)
+	return &smtpTransport{
+		conf:  conf,
+		store: store,
+	}, nil
+}
+
+func (t *smtpTransport) Send(mail model.Mail, recs []string) error {
+	if len(recs) == 0 {
 		return nil
 	}
 
-	e := email.NewEmail()
-	e.From = fmt.Sprintf("\"%s\" <%s>", mail.Sender, smtpUser)
-	e.To = recs
-	e.Subject = fmt.Sprintf("Письмо из GoMail! %s", mail.Subject)
-	e.Text = []byte(mail.Body)
+	e, err := buildEmail(mail, recs, t.conf.User, t.store)
+	if err != nil {
+		return err
+	}
 
-	auth := smtp.PlainAuth("", smtpUser, smtpPass, smtpHost)
+	raw, err := e.Bytes()
+	if err != nil {
+		return err
+	}
 
-	if err := e.SendWithTLS(smtpHost+":465", auth, &tls.Config{
-		InsecureSkipVerify: true,
-		ServerName:         smtpHost,
-	}); err != nil {
+	err = t.deliver(recs, raw)
+	if err != nil {
 		log.Println("Failed to send email:", err)
 		return err
 	}
@@ -43,3 +66,67 @@ func SendMailSMTP(mail model.Mail, recs []string) error {
 	log.Println("Email sent successfully")
 	return nil
 }
+
+// deliver hands raw to the server with recs as the envelope recipients.
+// The envelope alone decides who gets the message, which is how Bcc
+// recipients receive it without appearing in the headers.
+func (t *smtpTransport) deliver(recs []string, raw []byte) error {
+	addr := net.JoinHostPort(t.conf.Host, t.conf.Port)
+	tlsConf := &tls.Config{
+		InsecureSkipVerify: t.conf.InsecureSkipVerify,
+		ServerName:         t.conf.Host,
+	}
+
+	dialer := &net.Dialer{Timeout: smtpDialTimeout}
+	var conn net.Conn
+	var err error
+	if t.conf.Mode == config.TransportSMTPS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConf)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, t.conf.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if t.conf.Mode == config.TransportStartTLS {
+		if err := c.StartTLS(tlsConf); err != nil {
+			return err
+		}
+	}
+	if t.conf.User != "" {
+		if ok, _ := c.Extension("AUTH"); ok {
+			if err := c.Auth(smtp.PlainAuth("", t.conf.User, t.conf.Password, t.conf.Host)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := c.Mail(t.conf.User); err != nil {
+		return err
+	}
+	for _, rec := range recs {
+		if err := c.Rcpt(rec); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(raw); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"backend/internal/model"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var ErrInvalidStorageKey = errors.New("invalid storage key")
+
+// AttachmentStore keeps attachment contents on disk, addressed by their
+// SHA-256 checksum, so the same file sent to many users is stored once.
+type AttachmentStore struct {
+	dir string
+}
+
+func NewAttachmentStore(dir string) (*AttachmentStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+
+	return &AttachmentStore{
+		dir: dir,
+	}, nil
+}
+
+// Put copies r into the store and describes it as an attachment named
+// filename. The returned attachment is not yet bound to a mail.
+func (s *AttachmentStore) Put(filename, contentType string, r io.Reader) (model.Attachment, error) {
+	tmp, err := os.CreateTemp(s.dir, "upload-*")
+	if err != nil {
+		return model.Attachment{}, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), r)
+	if err != nil {
+		return model.Attachment{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		return model.Attachment{}, err
+	}
+
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	path := s.path(checksum)
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return model.Attachment{}, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return model.Attachment{}, err
+	}
+
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	filename = filepath.Base(filename)
+	if filename == "." || filename == string(filepath.Separator) {
+		filename = "attachment"
+	}
+
+	return model.Attachment{
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        size,
+		Checksum:    checksum,
+		StorageKey:  checksum,
+	}, nil
+}
+
+func (s *AttachmentStore) Open(key string) (*os.File, error) {
+	if !validStorageKey(key) {
+		return nil, ErrInvalidStorageKey
+	}
+
+	return os.Open(s.path(key))
+}
+
+// Remove deletes the file stored under key unless it was stored again
+// after before. A missing file is not an error.
+func (s *AttachmentStore) Remove(key string, before time.Time) error {
+	if !validStorageKey(key) {
+		return ErrInvalidStorageKey
+	}
+
+	path := s.path(key)
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !info.ModTime().Before(before) {
+		return nil
+	}
+
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func validStorageKey(key string) bool {
+	if len(key) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(key)
+	return err == nil
+}
+
+func (s *AttachmentStore) path(key string) string {
+	return filepath.Join(s.dir, key[:2], key)
+}
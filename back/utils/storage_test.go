@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttachmentStore_Remove(t *testing.T) {
+	before := time.Now()
+
+	tests := []struct {
+		name     string
+		modified time.Time
+		stored   bool
+		removed  bool
+	}{
+		{"stored before", before.Add(-time.Hour), true, true},
+		{"stored at the cutoff", before, true, false},
+		{"stored again since", before.Add(time.Minute), true, false},
+		{"missing", before, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := NewAttachmentStore(t.TempDir())
+			require.NoError(t, err)
+			att, err := store.Put("notes.txt", "text/plain", strings.NewReader("some notes"))
+			require.NoError(t, err)
+			path := store.path(att.StorageKey)
+			if tt.stored {
+				require.NoError(t, os.Chtimes(path, tt.modified, tt.modified))
+			} else {
+				require.NoError(t, os.Remove(path))
+			}
+
+			require.NoError(t, store.Remove(att.StorageKey, before))
+
+			_, err = os.Stat(path)
+			assert.Equal(t, tt.removed, os.IsNotExist(err))
+		})
+	}
+}
+
+func TestAttachmentStore_RemoveInvalidKey(t *testing.T) {
+	store, err := NewAttachmentStore(t.TempDir())
+	require.NoError(t, err)
+
+	for _, key := range []string{"", "../../etc/passwd", strings.Repeat("z", 64)} {
+		assert.ErrorIs(t, store.Remove(key, time.Now()), ErrInvalidStorageKey, key)
+	}
+}
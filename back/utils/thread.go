@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"backend/internal/model"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// NewMessageID returns a unique Message-ID under domain, with angle
+// brackets.
+func NewMessageID(domain string) string {
+	suffix := make([]byte, 8)
+	rand.Read(suffix)
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), hex.EncodeToString(suffix), domain)
+}
+
+// FormatMessageIDs renders ids, given without angle brackets, as a
+// References style list.
+func FormatMessageIDs(ids []string) string {
+	formatted := make([]string, 0, len(ids))
+	for _, id := range ids {
+		formatted = append(formatted, "<"+id+">")
+	}
+	return strings.Join(formatted, " ")
+}
+
+// AssignThread sets the thread of mail. A reply joins the thread of any
+// stored ancestor; otherwise the thread is derived from the oldest known
+// Message-ID of the conversation, which is the mail's own for a new one.
+func AssignThread(db model.MailDB, mail *model.Mail) error {
+	ancestors := strings.Fields(mail.References)
+	if mail.InReplyTo != "" {
+		ancestors = append(ancestors, mail.InReplyTo)
+	}
+	if len(ancestors) == 0 {
+		mail.ThreadId = model.ThreadID(mail.MessageId)
+		return nil
+	}
+
+	var parents []model.Mail
+	if err := db.Select("thread_id").Where("message_id IN ? AND thread_id <> ''", ancestors).Limit(1).Find(&parents).Error(); err != nil {
+		return err
+	}
+	if len(parents) > 0 {
+		mail.ThreadId = parents[0].ThreadId
+	} else {
+		mail.ThreadId = model.ThreadID(ancestors[0])
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("token expired")
+
+	jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+)
+
+// TokenIssuer signs and verifies HS256 JWT access tokens.
+type TokenIssuer struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+type accessClaims struct {
+	UserID    uint  `json:"sub"`
+	IssuedAt  int64 `json:"iat"`
+	ExpiresAt int64 `json:"exp"`
+}
+
+func NewTokenIssuer(secret []byte, ttl time.Duration) *TokenIssuer {
+	return &TokenIssuer{
+		secret: secret,
+		ttl:    ttl,
+	}
+}
+
+func (ti *TokenIssuer) Issue(userID uint) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(ti.ttl)
+
+	payload, err := json.Marshal(accessClaims{
+		UserID:    userID,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expiresAt.Unix(),
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	unsigned := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + ti.sign(unsigned), expiresAt, nil
+}
+
+func (ti *TokenIssuer) Verify(token string) (uint, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != jwtHeader {
+		return 0, ErrInvalidToken
+	}
+
+	expected := ti.sign(parts[0] + "." + parts[1])
+	if !hmac.Equal([]byte(expected), []byte(parts[2])) {
+		return 0, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return 0, ErrInvalidToken
+	}
+
+	var claims accessClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.UserID == 0 {
+		return 0, ErrInvalidToken
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return 0, ErrExpiredToken
+	}
+
+	return claims.UserID, nil
+}
+
+func (ti *TokenIssuer) sign(unsigned string) string {
+	mac := hmac.New(sha256.New, ti.secret)
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// NewRefreshToken returns an opaque random token for the client and the hash
+// under which it is stored.
+func NewRefreshToken() (string, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	return token, HashRefreshToken(token), nil
+}
+
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
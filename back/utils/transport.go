@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"backend/internal/config"
+	"backend/internal/model"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jordan-wright/email"
+)
+
+// Transport delivers a mail to recipients outside of the service.
+type Transport interface {
+	Send(mail model.Mail, recs []string) error
+}
+
+// NewTransport builds the transport selected by conf.Mode: one of the SMTP
+// modes, or maildir to write messages to conf.MaildirPath.
+func NewTransport(conf config.TransportConfig, store *AttachmentStore) (Transport, error) {
+	if conf.Mode == config.TransportMaildir {
+		return NewMaildirTransport(conf.MaildirPath, conf.User, store)
+	}
+	return NewSMTPTransport(conf, store)
+}
+
+// maildirTransport writes every outgoing message into a Maildir instead of
+// sending it, for development and tests.
+type maildirTransport struct {
+	dir   string
+	from  string
+	store *AttachmentStore
+}
+
+func NewMaildirTransport(dir, from string, store *AttachmentStore) (Transport, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o750); err != nil {
+			return nil, err
+		}
+	}
+
+	return &maildirTransport{
+		dir:   dir,
+		from:  from,
+		store: store,
+	}, nil
+}
+
+func (t *maildirTransport) Send(mail model.Mail, recs []string) error {
+	if len(recs) == 0 {
+		return nil
+	}
+
+	e, err := buildEmail(mail, recs, t.from, t.store)
+	if err != nil {
+		return err
+	}
+	raw, err := e.Bytes()
+	if err != nil {
+		return err
+	}
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d.%s.gomail", time.Now().UnixNano(), hex.EncodeToString(suffix))
+
+	tmp := filepath.Join(t.dir, "tmp", name)
+	if err := os.WriteFile(tmp, raw, 0o640); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(t.dir, "new", name))
+}
+
+// buildEmail renders mail as an outgoing message from the service account,
+// keeping the local sender as the display name. The headers list the To and
+// Cc recipients of the mail; who actually receives it is up to the envelope,
+// so Bcc recipients are never written out. Mails without recipient records
+// are addressed to recs.
+func buildEmail(mail model.Mail, recs []string, account string, store *AttachmentStore) (*email.Email, error) {
+	e := email.NewEmail()
+	e.From = fmt.Sprintf("\"%s\" <%s>", mail.Sender, account)
+	if len(mail.Recipients) > 0 {
+		e.To = RecipientAddresses(mail.Recipients, model.RecipientTo)
+		e.Cc = RecipientAddresses(mail.Recipients, model.RecipientCc)
+	} else {
+		e.To = recs
+	}
+	e.Subject = fmt.Sprintf("Письмо из GoMail! %s", mail.Subject)
+	if mail.MessageId != "" {
+		e.Headers.Set("Message-Id", mail.MessageId)
+	}
+	if mail.InReplyTo != "" {
+		e.Headers.Set("In-Reply-To", mail.InReplyTo)
+	}
+	if mail.References != "" {
+		e.Headers.Set("References", mail.References)
+	}
+	e.Text = []byte(mail.Body)
+	if mail.HTMLBody != "" {
+		e.HTML = []byte(mail.HTMLBody)
+	}
+
+	for _, att := range mail.Attachments {
+		file, err := store.Open(att.StorageKey)
+		if err != nil {
+			return nil, err
+		}
+		_, err = e.Attach(file, att.Filename, att.ContentType)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return e, nil
+}
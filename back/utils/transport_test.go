@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"backend/internal/model"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-message/mail"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaildirTransport_Send(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewAttachmentStore(t.TempDir())
+	require.NoError(t, err)
+	att, err := store.Put("report.txt", "text/plain", strings.NewReader("quarterly numbers"))
+	require.NoError(t, err)
+
+	transport, err := NewMaildirTransport(dir, "service@gomail.kurs", store)
+	require.NoError(t, err)
+
+	sent := model.Mail{
+		MessageId:  "<sent@gomail.kurs>",
+		InReplyTo:  "<parent@example.com>",
+		References: "<root@example.com> <parent@example.com>",
+		Sender:     "alice@gomail.kurs",
+		Subject:    "Numbers",
+		Body:       "See attached.",
+		Recipients: []model.Recipient{
+			{Address: "bob@example.com", Type: model.RecipientTo},
+			{Address: "carol@example.com", Type: model.RecipientCc},
+			{Address: "hidden@example.com", Type: model.RecipientBcc},
+		},
+		Attachments: []model.Attachment{att},
+	}
+	require.NoError(t, transport.Send(sent, []string{"bob@example.com", "hidden@example.com"}))
+	// Nothing is written without recipients.
+	require.NoError(t, transport.Send(sent, nil))
+
+	tmp, err := os.ReadDir(filepath.Join(dir, "tmp"))
+	require.NoError(t, err)
+	assert.Empty(t, tmp)
+	written, err := os.ReadDir(filepath.Join(dir, "new"))
+	require.NoError(t, err)
+	require.Len(t, written, 1)
+
+	file, err := os.Open(filepath.Join(dir, "new", written[0].Name()))
+	require.NoError(t, err)
+	defer file.Close()
+	reader, err := mail.CreateReader(file)
+	require.NoError(t, err)
+
+	header := reader.Header
+	from, err := header.AddressList("From")
+	require.NoError(t, err)
+	assert.Equal(t, []*mail.Address{{Name: "alice@gomail.kurs", Address: "service@gomail.kurs"}}, from)
+	// Bcc recipients only appear on the envelope.
+	assert.Equal(t, []string{"bob@example.com"}, headerRecipients(header, "To"))
+	assert.Equal(t, []string{"carol@example.com"}, headerRecipients(header, "Cc"))
+	assert.Empty(t, header.Get("Bcc"))
+	assert.Equal(t, "<sent@gomail.kurs>", header.Get("Message-Id"))
+	assert.Equal(t, "<parent@example.com>", header.Get("In-Reply-To"))
+	assert.Equal(t, "<root@example.com> <parent@example.com>", header.Get("References"))
+	subject, err := header.Subject()
+	require.NoError(t, err)
+	assert.Contains(t, subject, "Numbers")
+
+	var body string
+	var files []string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		content, err := io.ReadAll(part.Body)
+		require.NoError(t, err)
+		switch h := part.Header.(type) {
+		case *mail.InlineHeader:
+			body += string(content)
+		case *mail.AttachmentHeader:
+			filename, _ := h.Filename()
+			files = append(files, filename+": "+string(content))
+		}
+	}
+	assert.Equal(t, "See attached.", strings.TrimSpace(body))
+	assert.Equal(t, []string{"report.txt: quarterly numbers"}, files)
+}
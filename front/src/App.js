@@ -267,11 +267,20 @@ const Table = styled.table`
   }
 `;
 
+const SESSION_KEY = 'session';
+
+const loadSession = () => {
+  // Sessions used to be stored as Basic credentials, which the API no
+  // longer accepts.
+  localStorage.removeItem('auth');
+  const stored = localStorage.getItem(SESSION_KEY);
+  return stored ? JSON.parse(stored) : null;
+};
+
 function App() {
-  const [auth, setAuth] = useState(() => {
-    const storedAuth = localStorage.getItem('auth');
-    return storedAuth ? storedAuth : null;
-  });
+  const [session, setSession] = useState(loadSession);
+  const sessionRef = useRef(session);
+  const refreshing = useRef(null);
   const [notification, setNotification] = useState(null);
 
   const showNotification = (message) => {
@@ -282,26 +291,67 @@ function App() {
     setNotification(null);
   };
 
-  const login = (username, password) => {
-    const encoded = btoa(`${username}:${password}`);
-    setAuth(encoded);
-    localStorage.setItem('auth', encoded);
+  const saveSession = (next) => {
+    sessionRef.current = next;
+    setSession(next);
+    if (next) {
+      localStorage.setItem(SESSION_KEY, JSON.stringify(next));
+    } else {
+      localStorage.removeItem(SESSION_KEY);
+    }
+  };
+
+  const login = async (email, password) => {
+    const { data } = await axios.post(`${API_URL}/login`, { email, password });
+    saveSession({ ...data, email });
   };
 
   const logout = () => {
-    setAuth(null);
-    localStorage.removeItem('auth');
+    const current = sessionRef.current;
+    saveSession(null);
+    if (current) {
+      axios.post(`${API_URL}/auth/logout`, { refresh_token: current.refresh_token }).catch(() => {});
+    }
   };
 
-  const authHeaders = auth ? { Authorization: `Basic ${auth}` } : {};
+  // Access tokens are short-lived: a request rejected with 401 refreshes
+  // the session once and is sent again. Concurrent requests share one
+  // refresh, as every refresh token can only be used once.
+  useEffect(() => {
+    const interceptor = axios.interceptors.response.use(null, async (error) => {
+      const request = error.config;
+      const current = sessionRef.current;
+      if (error.response?.status !== 401 || !current || !request || request.retried || request.url.startsWith(`${API_URL}/auth/`)) {
+        throw error;
+      }
+
+      if (!refreshing.current) {
+        refreshing.current = axios
+          .post(`${API_URL}/auth/refresh`, { refresh_token: current.refresh_token })
+          .then(({ data }) => {
+            saveSession({ ...data, email: current.email });
+            return data.access_token;
+          })
+          .catch((refreshError) => {
+            saveSession(null);
+            throw refreshError;
+          })
+          .finally(() => {
+            refreshing.current = null;
+          });
+      }
+
+      const accessToken = await refreshing.current;
+      request.retried = true;
+      request.headers.Authorization = `Bearer ${accessToken}`;
+      return axios(request);
+    });
+    return () => axios.interceptors.response.eject(interceptor);
+  }, []);
 
-  const getEmailFromAuth = () => {
-    if (!auth) return null;
-    const decoded = atob(auth);
-    return decoded.split(':')[0];
-  };
+  const authHeaders = session ? { Authorization: `Bearer ${session.access_token}` } : {};
 
-  const email = getEmailFromAuth();
+  const email = session?.email;
 
   const isAdmin = email && email.includes('@admin.gomail.kurs');
 
@@ -309,7 +359,7 @@ function App() {
     <Router>
       <GlobalStyle />
       {notification && <Notification message={notification} onClose={closeNotification} />}
-      {auth ? (
+      {session ? (
         <>
           <Header>
             <button onClick={logout}>Выйти</button>
@@ -368,8 +418,7 @@ function Login({ onLogin, showNotification }) {
 
   const handleLogin = async () => {
     try {
-      await axios.post(`${API_URL}/login`, { email, password });
-      onLogin(email, password);
+      await onLogin(email, password);
       navigate("/inbox");
     } catch (err) {
       showNotification(err.response?.data?.message || "Ошибка входа");
@@ -446,7 +495,7 @@ function Register({ onReg, showNotification }) {
 
     try {
       await axios.post(`${API_URL}/register`, { email, password });
-      onReg(email, password);
+      await onReg(email, password);
       navigate("/inbox");
     } catch (err) {
       showNotification(err.response?.data?.message || "Ошибка регистрации");